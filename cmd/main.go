@@ -1,27 +1,34 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
-	"time"
+	"os/signal"
+	"syscall"
 
 	"github.com/zeljkobenovic/kmon/internal/app"
 )
 
 func main() {
-	a, err := app.NewApp()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// restore the default signal behaviour after the first signal, so a second Ctrl-C terminates immediately
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	a, err := app.NewApp(ctx)
 	if err != nil {
-		log.Println("failed to instantiate pvman application: ", err.Error())
-		time.Sleep(3 * time.Second)
-		os.Exit(1)
+		log.Println("failed to instantiate kmon application: ", err.Error())
+		app.PauseInK9s()
+		os.Exit(app.ExitFailure)
 	}
 
 	if err = a.Run(); err != nil {
-		log.Println("failed to run pvman application: ", err.Error())
-		time.Sleep(3 * time.Second)
-		os.Exit(1)
+		log.Println("failed to run kmon application: ", err.Error())
+		os.Exit(app.ExitCode(ctx, err))
 	}
-
-	// allow timeout to see log output in K9s
-	time.Sleep(3 * time.Second)
 }
@@ -2,6 +2,7 @@ package app
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
@@ -9,206 +10,389 @@ import (
 	"github.com/zeljkobenovic/kmon/pkg/config"
 	"github.com/zeljkobenovic/kmon/pkg/kube"
 	"github.com/zeljkobenovic/kmon/pkg/kube/core"
+	"github.com/zeljkobenovic/kmon/pkg/logging"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 )
 
 type App struct {
-	core *core.Core
-	conf *config.Config
-	log  *slog.Logger
-	ctx  context.Context
+	core       *core.Core
+	conf       *config.Config
+	log        *slog.Logger
+	logHandler *logging.Handler
+	ctx        context.Context
+	// result collects the outcome of the running command
+	result *Result
+	// k9s is set for runs from a k9s plugin, see config.K9s
+	k9s bool
 }
 
-func NewApp() (*App, error) {
-	log := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{}))
-	ctx := context.Background()
+func NewApp(ctx context.Context) (*App, error) {
+	logHandler := logging.NewHandler(os.Stderr)
+	log := slog.New(logHandler)
 
 	c, err := config.NewConfig(log)
 	if err != nil {
 		return nil, err
 	}
 
-	kcl, err := kube.NewKubeClient(c.Namespace)
-	if err != nil {
-		return nil, err
-	}
-
 	return &App{
-		core: core.NewCore(log, ctx, kcl),
-		conf: c,
-		log:  log.WithGroup("app"),
-		ctx:  ctx,
+		conf:       c,
+		log:        log.WithGroup("app"),
+		logHandler: logHandler,
+		ctx:        ctx,
+		k9s:        runByK9s(),
 	}, nil
 }
 
 func (a *App) Run() error {
-	return a.conf.Execute(a)
+	err := a.conf.Execute(a)
+
+	// k9s takes the screen back as soon as kmon exits, so keep it until the failure was read
+	if a.k9s && err != nil {
+		waitForKey()
+	}
 
+	return err
 }
 
-func (a *App) PodCmdHandler() error {
-	switch a.conf.Pod.Mode {
-	case config.RunFromPVC:
-		return a.runPodFromPVC()
-	case config.RunFromSnapshot:
-		return a.runPodFromSnapshot()
+func (a *App) PreRun() error {
+	a.k9s = a.k9s || a.conf.K9s.Plugin
+
+	switch a.conf.Output {
+	case config.OutputText, config.OutputJSON, config.OutputYAML:
 	default:
-		return fmt.Errorf("invalid pod mode: %s", a.conf.Pod.Mode)
+		return core.Errorf(core.ErrValidationFailed, "invalid output format %q, expected one of: %s, %s, %s", a.conf.Output, config.OutputText, config.OutputJSON, config.OutputYAML)
 	}
-}
 
-func (a *App) PVCCmdHandler() error {
-	switch a.conf.PVC.Mode {
-	case config.SnapshotFromPVC:
-		return a.createSnapshotFromPVC()
-	case config.PVCfromSnapshot:
-		return a.createPVCfromSnapshot()
-	default:
-		return fmt.Errorf("invalid pod mode: %s", a.conf.PVC.Mode)
+	if err := a.logHandler.Configure(logging.Format(a.conf.LogFormat), a.conf.LogLevel); err != nil {
+		return err
 	}
-}
 
-func (a *App) createTestPVC() error {
-	pvc, err := a.core.PVC().Create(a.conf.Namespace, a.conf.PVC.Name)
+	// the client is built once the flags are parsed, so it talks to the cluster of --context
+	kcl, err := kube.NewKubeClient(a.conf.Context)
 	if err != nil {
-		return fmt.Errorf("failed to create pvc: %s", err)
+		return err
 	}
+	a.core = core.NewCore(slog.New(a.logHandler), a.ctx, kcl)
 
-	a.log.Info("pvc created", "name", pvc.Name, "time", pvc.CreationTimestamp.String())
 	return nil
 }
 
-func (a *App) runPodFromPVC() error {
-	pod, err := a.core.Pod().Create(
-		a.conf.Namespace,
-		a.conf.Pod.Name,
-		core.WithPVC(
-			a.conf.Pod.VolumeName,
-			a.conf.Pod.MountPath,
-			a.conf.Pod.PVCName,
-		),
-	)
-	if err != nil {
-		return fmt.Errorf("pod create failed: %v", err)
-	}
-
-	if err = a.core.Pod().WaitReady(pod.Namespace, pod.Name, 60); err != nil {
-		return fmt.Errorf("pod wait ready failed: %v", err)
-	}
-
-	a.log.Info("pod successfully created", "name", pod.Name, "time", pod.CreationTimestamp.String())
-
-	return nil
+func (a *App) PodCmdHandler() error {
+	return a.withContext("pod "+string(a.conf.Pod.Mode), func(ctx context.Context) error {
+		switch a.conf.Pod.Mode {
+		case config.RunFromPVC:
+			return a.runPodFromPVC(ctx)
+		case config.RunFromSnapshot:
+			return a.runPodFromSnapshot(ctx)
+		default:
+			return core.Errorf(core.ErrValidationFailed, "invalid pod mode: %s", a.conf.Pod.Mode)
+		}
+	})
 }
 
-func (a *App) runPodFromSnapshot() error {
-	pvc, err := a.core.PVC().Create(
-		a.conf.Namespace,
-		a.conf.PVC.Name,
-		core.WithRestoreFromVolumeSnapshot(a.conf.Pod.SnapshotName),
-	)
-	if err != nil {
-		return fmt.Errorf("failed to create pvc: %s", err)
-	}
+func (a *App) PVCCmdHandler() error {
+	return a.withContext("pvc "+string(a.conf.PVC.Mode), func(ctx context.Context) error {
+		switch a.conf.PVC.Mode {
+		case config.SnapshotFromPVC:
+			return a.createSnapshotFromPVC(ctx)
+		case config.PVCfromSnapshot:
+			return a.createPVCfromSnapshot(ctx)
+		default:
+			return core.Errorf(core.ErrValidationFailed, "invalid pvc mode: %s", a.conf.PVC.Mode)
+		}
+	})
+}
 
-	a.log.Info("pvc created", "name", pvc.Name, "time", pvc.CreationTimestamp.String())
+// withContext runs the command bound to the --timeout flag and the signal aware application context,
+// and prints its result in the requested output format once it completes.
+// If the command gets interrupted or times out, the registered cleanup actions are run
+func (a *App) withContext(command string, op func(ctx context.Context) error) error {
+	a.result = newResult(command)
 
-	pod, err := a.core.Pod().Create(
-		a.conf.Namespace,
-		a.conf.Pod.Name,
-		core.WithPVC(
-			a.conf.Pod.VolumeName,
-			a.conf.Pod.MountPath,
-			a.conf.Pod.PVCName,
-		),
-	)
-	if err != nil {
-		return fmt.Errorf("pod create failed: %v", err)
-	}
+	err := a.runWithContext(func(ctx context.Context) error {
+		if err := a.preflight(ctx, command); err != nil {
+			return err
+		}
 
-	a.log.Info("pod created", "name", pod.Name, "time", pod.CreationTimestamp.String())
+		return op(ctx)
+	})
 
-	return nil
-}
+	a.result.finish(err)
 
-func (a *App) runTest() error {
-	pvc, err := a.core.PVC().Create(a.conf.Namespace, a.conf.PVC.Name)
-	if err != nil {
+	if a.result.streamed {
+		a.log.Info("command finished", "command", command, "status", a.result.Status, "duration", a.result.Duration)
 		return err
 	}
 
-	a.log.Info("pvc created", "name", pvc.Name)
+	printResult := func() error { return a.result.print(os.Stdout, a.conf.Output) }
+	if a.k9s && a.conf.Output == config.OutputText {
+		printResult = func() error { return a.result.printPanel(os.Stdout) }
+	}
 
-	pod, err := a.core.Pod().Create(
-		a.conf.Namespace,
-		a.conf.Pod.Name,
-		core.WithPVC(
-			a.conf.Pod.VolumeName,
-			a.conf.Pod.MountPath,
-			pvc.Name,
-		),
-	)
-	if err != nil {
-		return err
+	if pErr := printResult(); pErr != nil {
+		return errors.Join(err, fmt.Errorf("could not print result: %w", pErr))
 	}
 
-	a.log.Info("pod created", "time", pod.CreationTimestamp.String())
+	return err
+}
 
-	if err = a.core.Pod().WaitReady(pod.Namespace, pod.Name, 60); err != nil {
-		return err
+func (a *App) runWithContext(op func(ctx context.Context) error) error {
+	ctx, cancel := a.ctx, context.CancelFunc(func() {})
+	if a.conf.Timeout > 0 {
+		ctx, cancel = context.WithTimeoutCause(a.ctx, a.conf.Timeout, core.Errorf(core.ErrTimeout, "operation timed out after %s", a.conf.Timeout))
 	}
+	defer cancel()
 
-	if err = a.core.Pod().Exec(pod.Namespace, pod.Name, []string{"ls", "-lah", "/"}); err != nil {
+	err := op(ctx)
+	if ctx.Err() == nil {
+		a.core.Cleanup().Discard()
 		return err
 	}
 
-	err = a.core.Pod().Delete(pod.Namespace, pod.Name)
-	if err != nil {
-		return err
+	a.log.Warn("operation interrupted, cleaning up", "reason", context.Cause(ctx))
+
+	if cErr := a.core.Cleanup().Run(); cErr != nil {
+		return fmt.Errorf("%w, cleanup failed: %w", context.Cause(ctx), cErr)
 	}
 
-	err = a.core.Pod().WaitDeleted(pod.Namespace, pod.Name, 60)
+	return context.Cause(ctx)
+}
+
+func (a *App) createTestPVC(ctx context.Context) error {
+	pvc, _, err := a.core.PVC().Create(ctx, a.conf.Namespace, a.conf.PVC.Name)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to create pvc: %w", err)
 	}
 
-	a.log.Info("pod deleted", "time", pod.CreationTimestamp.String())
+	a.log.Info("pvc created", "name", pvc.Name, "time", pvc.CreationTimestamp.String())
+	return nil
+}
+
+func (a *App) runPodFromPVC(ctx context.Context) error {
+	var podName string
 
-	if err = a.core.PVC().Delete(pvc.Namespace, pvc.Name); err != nil {
-		return err
-	}
+	return a.newWorkflow(string(config.RunFromPVC)).
+		Step(a.createPodStep(&a.conf.Pod.PVCName, &podName)).
+		Run(ctx)
+}
 
-	a.log.Info("pvc deleted", "name", pvc.Name)
+func (a *App) runPodFromSnapshot(ctx context.Context) error {
+	var pvcName, podName string
 
-	return nil
+	return a.newWorkflow(string(config.RunFromSnapshot)).
+		Step(a.createPVCStep(&pvcName, core.WithRestoreFromVolumeSnapshot(a.conf.Pod.SnapshotName))).
+		Step(a.createPodStep(&pvcName, &podName)).
+		Run(ctx)
 }
 
-func (a *App) createSnapshotFromPVC() error {
-	pvc, err := a.core.PVC().CreateVolumeSnapshotFromPVC(
-		a.conf.Namespace,
-		a.conf.PVC.SnapshotName,
-		a.conf.PVC.SnapshotClassName,
-		a.conf.PVC.SourcePVCName,
-	)
-	if err != nil {
-		return fmt.Errorf("failed to create pvc snapshot: %s", err)
-	}
+func (a *App) runTest(ctx context.Context) error {
+	var pvcName, podName string
+
+	return a.newWorkflow("test").
+		Step(a.createPVCStep(&pvcName)).
+		Step(a.createPodStep(&pvcName, &podName)).
+		Step(step{
+			name: "list pod content",
+			do: func(ctx context.Context) error {
+				return a.core.Pod().Exec(ctx, a.conf.Namespace, podName, []string{"ls", "-lah", "/"})
+			},
+		}).
+		Step(step{
+			name: "delete pod",
+			do: func(ctx context.Context) error {
+				return a.core.Pod().Delete(ctx, a.conf.Namespace, podName)
+			},
+			wait: func(ctx context.Context) error {
+				return a.core.Pod().WaitDeleted(ctx, a.conf.Namespace, podName, 60)
+			},
+		}).
+		Step(step{
+			name: "delete pvc",
+			do: func(ctx context.Context) error {
+				return a.core.PVC().Delete(ctx, a.conf.Namespace, pvcName)
+			},
+		}).
+		Run(ctx)
+}
 
-	a.log.Info("pvc snapshot", "name", pvc.Name, "time", pvc.CreationTimestamp.String())
+func (a *App) createSnapshotFromPVC(ctx context.Context) error {
+	return a.newWorkflow(string(config.SnapshotFromPVC)).
+		Step(step{
+			name: "create volume snapshot",
+			do: func(ctx context.Context) error {
+				vs, err := a.core.PVC().CreateVolumeSnapshotFromPVC(
+					ctx,
+					a.conf.Namespace,
+					a.conf.PVC.SnapshotName,
+					a.conf.PVC.SnapshotClassName,
+					a.conf.PVC.SourcePVCName,
+				)
+				if err != nil {
+					return fmt.Errorf("failed to create pvc snapshot: %w", err)
+				}
+
+				a.log.Info("pvc snapshot", "name", vs.Name, "time", vs.CreationTimestamp.String())
+				a.result.addResource("volumesnapshot", vs.Namespace, vs.Name, resourceCreated)
+
+				return nil
+			},
+		}).
+		Run(ctx)
+}
 
-	return nil
+func (a *App) createPVCfromSnapshot(ctx context.Context) error {
+	var pvcName string
+
+	return a.newWorkflow(string(config.PVCfromSnapshot)).
+		Step(a.createPVCStep(&pvcName, core.WithRestoreFromVolumeSnapshot(a.conf.PVC.SnapshotName))).
+		Run(ctx)
 }
 
-func (a *App) createPVCfromSnapshot() error {
-	pvc, err := a.core.PVC().Create(
-		a.conf.Namespace,
-		a.conf.PVC.Name,
-		core.WithRestoreFromVolumeSnapshot(a.conf.Pod.SnapshotName),
-	)
-	if err != nil {
-		return fmt.Errorf("failed to create pvc: %s", err)
+// createPVCStep creates a pvc named after the --name pvc flag and stores the name of the created pvc in pvcName
+func (a *App) createPVCStep(pvcName *string, opts ...core.PVCOptions) step {
+	*pvcName = a.conf.PVC.Name
+
+	if a.conf.GenerateName {
+		opts = append(opts, core.WithPVCGenerateName())
 	}
 
-	a.log.Info("pvc created", "name", pvc.Name, "time", pvc.CreationTimestamp.String())
+	return a.pvcStep("create pvc", pvcName, opts...)
+}
 
-	return nil
+// pvcStep creates the pvc pvcName points to and stores the name of the created pvc back in pvcName.
+// An existing pvc with the same spec is reused and left in place on rollback
+func (a *App) pvcStep(name string, pvcName *string, opts ...core.PVCOptions) step {
+	var created bool
+
+	return step{
+		name: name,
+		do: func(ctx context.Context) error {
+			var replaced bool
+			pvc, isNew, err := a.core.PVC().Create(ctx, a.conf.Namespace, *pvcName, opts...)
+
+			var mismatch *core.SpecMismatchError
+			// only objects kmon created are replaced, a pvc of the user keeps its data
+			if errors.As(err, &mismatch) && a.conf.Force && !mismatch.Unmanaged {
+				pvc, err = a.core.PVC().Replace(ctx, a.conf.Namespace, *pvcName, opts...)
+				isNew, replaced = true, true
+			}
+			if errors.As(err, &mismatch) && mismatch.Unmanaged {
+				return fmt.Errorf("failed to create pvc, pick another name: %w", err)
+			}
+			if errors.As(err, &mismatch) {
+				return fmt.Errorf("failed to create pvc, use --force to replace it: %w", err)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to create pvc: %w", err)
+			}
+
+			*pvcName, created = pvc.Name, isNew
+			a.log.Info("pvc created", "name", pvc.Name, "time", pvc.CreationTimestamp.String(), "reused", !created)
+			a.result.addResource("pvc", pvc.Namespace, pvc.Name, a.resourceAction(created, replaced))
+
+			return nil
+		},
+		undo: func(ctx context.Context) error {
+			if !created {
+				return nil
+			}
+
+			// a later step might have deleted the pvc already
+			if err := a.core.PVC().Delete(ctx, a.conf.Namespace, *pvcName); err != nil && !apierrors.IsNotFound(err) {
+				return err
+			}
+
+			return nil
+		},
+	}
+}
+
+// createPodStep creates a pod with the pvc pvcName points to mounted, stores the name of the created pod in podName
+// and waits for it to become ready. The pvc name is resolved when the step runs, so it can be set by one of the previous steps
+func (a *App) createPodStep(pvcName *string, podName *string) step {
+	*podName = a.conf.Pod.Name
+
+	return a.podStep("create pod", podName, 60, func() []core.PodOptions {
+		opts := []core.PodOptions{
+			core.WithPVC(
+				a.conf.Pod.VolumeName,
+				a.conf.Pod.MountPath,
+				*pvcName,
+			),
+		}
+		if a.conf.GenerateName {
+			opts = append(opts, core.WithGenerateName())
+		}
+
+		return opts
+	})
+}
+
+// podStep creates the pod podName points to, using the options returned by opts once the step runs,
+// stores the name of the created pod back in podName and waits for it to become ready.
+// An existing pod with the same spec is reused and left in place on rollback
+func (a *App) podStep(name string, podName *string, readyTimeoutSec int, opts func() []core.PodOptions) step {
+	var created bool
+
+	return step{
+		name: name,
+		do: func(ctx context.Context) error {
+			podOpts := opts()
+
+			var replaced bool
+			pod, isNew, err := a.core.Pod().Create(ctx, a.conf.Namespace, *podName, podOpts...)
+
+			var mismatch *core.SpecMismatchError
+			// only objects kmon created are replaced, a pod of the user keeps running
+			if errors.As(err, &mismatch) && a.conf.Force && !mismatch.Unmanaged {
+				pod, err = a.core.Pod().Replace(ctx, a.conf.Namespace, *podName, podOpts...)
+				isNew, replaced = true, true
+			}
+			if errors.As(err, &mismatch) && mismatch.Unmanaged {
+				return fmt.Errorf("pod create failed, pick another name: %w", err)
+			}
+			if errors.As(err, &mismatch) {
+				return fmt.Errorf("pod create failed, use --force to replace it: %w", err)
+			}
+			if err != nil {
+				return fmt.Errorf("pod create failed: %w", err)
+			}
+
+			*podName, created = pod.Name, isNew
+			a.log.Info("pod created", "name", pod.Name, "time", pod.CreationTimestamp.String(), "reused", !created)
+			a.result.addResource("pod", pod.Namespace, pod.Name, a.resourceAction(created, replaced))
+
+			return nil
+		},
+		undo: func(ctx context.Context) error {
+			if !created {
+				return nil
+			}
+
+			if err := a.core.Pod().Delete(ctx, a.conf.Namespace, *podName); err != nil && !apierrors.IsNotFound(err) {
+				return err
+			}
+
+			return nil
+		},
+		wait: func(ctx context.Context) error {
+			if err := a.core.Pod().WaitReady(ctx, a.conf.Namespace, *podName, readyTimeoutSec); err != nil {
+				return fmt.Errorf("pod wait ready failed: %w", err)
+			}
+
+			return nil
+		},
+	}
+}
+
+func (a *App) resourceAction(created, replaced bool) resourceAction {
+	switch {
+	case replaced:
+		return resourceReplaced
+	case created:
+		return resourceCreated
+	default:
+		return resourceReused
+	}
 }
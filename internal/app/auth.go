@@ -0,0 +1,427 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/zeljkobenovic/kmon/pkg/kube/core"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	snapshotGroup = "snapshot.storage.k8s.io"
+	storageGroup  = "storage.k8s.io"
+)
+
+// rbacRule grants verbs on a resource, written as resource or resource/subresource like in a Role
+type rbacRule struct {
+	group    string
+	resource string
+	verbs    []string
+	// cluster is set for cluster scoped resources and for resources read in all namespaces, granted by a ClusterRole
+	cluster bool
+	// namespace is set for resources of a fixed namespace, granted by a Role in that namespace
+	namespace string
+}
+
+var (
+	inspectionPodRules = []rbacRule{
+		{resource: "pods", verbs: []string{"get", "list", "watch", "create", "delete"}},
+		{resource: "pods/exec", verbs: []string{"create"}},
+		{resource: "events", verbs: []string{"list", "watch"}},
+	}
+	pvcCreateRules = []rbacRule{
+		{resource: "persistentvolumeclaims", verbs: []string{"get", "list", "watch", "create", "delete"}},
+	}
+	restoreRules = slices.Concat(pvcCreateRules, []rbacRule{
+		{group: snapshotGroup, resource: "volumesnapshots", verbs: []string{"get"}},
+	})
+	pvcGetRules = []rbacRule{
+		{resource: "persistentvolumeclaims", verbs: []string{"get"}},
+	}
+	orphanListRules = []rbacRule{
+		{resource: "persistentvolumeclaims", verbs: []string{"list"}},
+		{resource: "pods", verbs: []string{"list"}},
+		{resource: "persistentvolumes", verbs: []string{"list"}, cluster: true},
+		{group: "apps", resource: "deployments", verbs: []string{"list"}},
+		{group: "apps", resource: "statefulsets", verbs: []string{"list"}},
+		{group: "apps", resource: "daemonsets", verbs: []string{"list"}},
+		{group: "batch", resource: "jobs", verbs: []string{"list"}},
+		{group: "batch", resource: "cronjobs", verbs: []string{"list"}},
+		{group: snapshotGroup, resource: "volumesnapshots", verbs: []string{"list"}},
+		{group: snapshotGroup, resource: "volumesnapshotcontents", verbs: []string{"list"}, cluster: true},
+	}
+)
+
+// commandRules lists the rules every command needs, keyed by the command name shown in the result,
+// followed by the flags which make the command need more
+var commandRules = map[string][]rbacRule{
+	"pod run-from-pvc":      inspectionPodRules,
+	"pod run-from-snapshot": slices.Concat(inspectionPodRules, restoreRules),
+	"pvc snapshot-from-pvc": {
+		{group: snapshotGroup, resource: "volumesnapshots", verbs: []string{"create"}},
+		{resource: "persistentvolumeclaims", verbs: []string{"get"}},
+		{resource: "persistentvolumes", verbs: []string{"get"}, cluster: true},
+		{group: storageGroup, resource: "storageclasses", verbs: []string{"get"}, cluster: true},
+		{group: snapshotGroup, resource: "volumesnapshotclasses", verbs: []string{"list"}, cluster: true},
+	},
+	"pvc pvc-from-snapshot":  pvcCreateRules,
+	"pvc ls":                 slices.Concat(inspectionPodRules, pvcGetRules),
+	"pvc cp":                 slices.Concat(inspectionPodRules, pvcGetRules),
+	"pvc cp --from-snapshot": slices.Concat(inspectionPodRules, restoreRules),
+	"snapshot ls":            slices.Concat(inspectionPodRules, restoreRules),
+	"snapshot diff":          slices.Concat(inspectionPodRules, restoreRules),
+	"pvc backup":             slices.Concat(inspectionPodRules, pvcGetRules),
+	"pvc restore-archive":    slices.Concat(inspectionPodRules, pvcCreateRules),
+	"pvc migrate": slices.Concat(inspectionPodRules, pvcCreateRules, []rbacRule{
+		{resource: "persistentvolumes", verbs: []string{"get", "patch"}, cluster: true},
+		{group: "apps", resource: "replicasets", verbs: []string{"get"}},
+		{group: "apps", resource: "deployments/scale", verbs: []string{"get", "update"}},
+		{group: "apps", resource: "statefulsets/scale", verbs: []string{"get", "update"}},
+	}),
+	"pvc resize": {
+		{resource: "persistentvolumeclaims", verbs: []string{"get", "list", "watch", "patch"}},
+		{resource: "pods", verbs: []string{"list", "watch", "delete"}},
+		{group: storageGroup, resource: "storageclasses", verbs: []string{"get"}, cluster: true},
+	},
+	"pvc usage": {
+		{resource: "persistentvolumeclaims", verbs: []string{"list"}},
+		{resource: "pods", verbs: []string{"list"}},
+		{resource: "nodes/proxy", verbs: []string{"get"}, cluster: true},
+		{group: snapshotGroup, resource: "volumesnapshots", verbs: []string{"list"}},
+	},
+	"pvc orphans": orphanListRules,
+	"pvc orphans --delete": slices.Concat(orphanListRules, []rbacRule{
+		{resource: "persistentvolumeclaims", verbs: []string{"delete"}},
+		{resource: "persistentvolumes", verbs: []string{"delete"}, cluster: true},
+		{group: snapshotGroup, resource: "volumesnapshots", verbs: []string{"delete"}},
+		{group: snapshotGroup, resource: "volumesnapshotcontents", verbs: []string{"delete"}, cluster: true},
+	}),
+	"pv rebind": slices.Concat(pvcCreateRules, []rbacRule{
+		{resource: "persistentvolumes", verbs: []string{"get", "patch", "update"}, cluster: true},
+	}),
+	"pod forward": {
+		{resource: "pods", verbs: []string{"list"}},
+		{resource: "pods/portforward", verbs: []string{"create"}},
+	},
+	"exec": {
+		{resource: "pods", verbs: []string{"list"}},
+		{resource: "pods/exec", verbs: []string{"create"}},
+	},
+	"logs": {
+		{resource: "pods", verbs: []string{"list", "watch"}},
+		{resource: "pods/log", verbs: []string{"get"}},
+		{resource: "events", verbs: []string{"list"}},
+	},
+	"net matrix": {
+		{resource: "pods", verbs: []string{"list"}},
+		{resource: "pods/exec", verbs: []string{"create"}},
+	},
+	"net matrix --ephemeral": {
+		{resource: "pods", verbs: []string{"get", "list", "watch"}},
+		{resource: "pods/exec", verbs: []string{"create"}},
+		{resource: "pods/ephemeralcontainers", verbs: []string{"update"}},
+	},
+	"net matrix --launch": slices.Concat(inspectionPodRules, []rbacRule{
+		{resource: "pods", verbs: []string{"list"}},
+	}),
+	"net dns": slices.Concat(inspectionPodRules, []rbacRule{
+		{resource: "pods", verbs: []string{"list"}, namespace: clusterDNSNamespace},
+	}),
+	"doctor": {
+		{group: "apps", resource: "deployments", verbs: []string{"list"}, cluster: true},
+		{group: storageGroup, resource: "csidrivers", verbs: []string{"list"}, cluster: true},
+		{group: storageGroup, resource: "storageclasses", verbs: []string{"list"}, cluster: true},
+		{group: snapshotGroup, resource: "volumesnapshotclasses", verbs: []string{"list"}, cluster: true},
+	},
+	"pvc describe": {
+		{resource: "persistentvolumeclaims", verbs: []string{"get", "list"}},
+		{resource: "pods", verbs: []string{"list"}},
+		{resource: "events", verbs: []string{"list"}},
+		// events of the cluster scoped persistent volume and volume attachments are in no namespace
+		{resource: "events", verbs: []string{"list"}, cluster: true},
+		{resource: "persistentvolumes", verbs: []string{"get"}, cluster: true},
+		{group: storageGroup, resource: "volumeattachments", verbs: []string{"list"}, cluster: true},
+		{group: snapshotGroup, resource: "volumesnapshots", verbs: []string{"list"}},
+	},
+}
+
+// permissionReport is the outcome of the auth check command and of a failed preflight
+type permissionReport struct {
+	Command string                 `json:"command"`
+	Checks  []core.PermissionCheck `json:"checks"`
+}
+
+func (r permissionReport) printText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	if _, err := fmt.Fprintln(tw, "VERB\tRESOURCE\tNAMESPACE\tALLOWED\tREASON"); err != nil {
+		return err
+	}
+
+	for _, c := range r.Checks {
+		resource := c.Resource
+		if c.Subresource != "" {
+			resource += "/" + c.Subresource
+		}
+		if c.Group != "" {
+			resource += "." + c.Group
+		}
+
+		namespace, allowed, reason := c.Namespace, "no", c.Reason
+		if namespace == "" {
+			namespace = "*"
+		}
+		if c.Allowed {
+			allowed = "yes"
+		}
+		if reason == "" {
+			reason = "-"
+		}
+
+		if _, err := fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", c.Verb, resource, namespace, allowed, reason); err != nil {
+			return err
+		}
+	}
+
+	return tw.Flush()
+}
+
+func (a *App) AuthCheckCmdHandler() error {
+	return a.withContext("auth check", a.authCheck)
+}
+
+// authCheck reviews all permissions the command needs, failing if any of them is missing
+func (a *App) authCheck(ctx context.Context) error {
+	command := a.conf.Auth.Command
+
+	rules, err := rulesOf(command)
+	if err != nil {
+		return err
+	}
+
+	checks, err := a.core.Auth().Check(ctx, a.permissions(rules))
+	if err != nil {
+		return err
+	}
+
+	a.result.Data = permissionReport{Command: command, Checks: checks}
+
+	if missing := deniedChecks(checks); len(missing) > 0 {
+		return core.Errorf(core.ErrForbidden, "missing %d permissions to run %s", len(missing), command)
+	}
+
+	return nil
+}
+
+// preflight reviews the permissions the command needs before it changes anything, failing with a table of the missing ones.
+// Commands which are not known, or a cluster failing to review the permissions, do not block the command
+func (a *App) preflight(ctx context.Context, command string) error {
+	command = a.commandVariant(command)
+
+	rules, ok := commandRules[command]
+	if !ok {
+		return nil
+	}
+
+	checks, err := a.core.Auth().Check(ctx, a.permissions(rules))
+	if err != nil {
+		a.log.Warn("skipping permission preflight", "command", command, "err", err)
+		return nil
+	}
+
+	missing := deniedChecks(checks)
+	if len(missing) == 0 {
+		return nil
+	}
+
+	a.result.Data = permissionReport{Command: command, Checks: missing}
+
+	return core.Errorf(core.ErrForbidden, "missing %d permissions to run %s, check them with kmon auth check %q", len(missing), command, command)
+}
+
+// commandVariant appends the flags which make the command need more permissions to its name
+func (a *App) commandVariant(command string) string {
+	switch {
+	case command == "pvc orphans" && a.conf.PVC.Orphans.Delete:
+		return command + " --delete"
+	case command == "pvc cp" && a.conf.PVC.Copy.FromSnapshot:
+		return command + " --from-snapshot"
+	case command == "net matrix" && a.conf.Net.Matrix.Ephemeral:
+		return command + " --ephemeral"
+	case command == "net matrix" && a.conf.Net.Matrix.Launch:
+		return command + " --launch"
+	default:
+		return command
+	}
+}
+
+// permissions expands the rules into single permissions, in the namespace unless the resource is cluster scoped
+// or the command runs across all namespaces
+func (a *App) permissions(rules []rbacRule) []core.Permission {
+	namespace := a.conf.Namespace
+	if a.conf.PVC.Usage.AllNamespaces || a.conf.PVC.Orphans.AllNamespaces {
+		namespace = ""
+	}
+
+	var permissions []core.Permission
+	for _, r := range rules {
+		resource, subresource, _ := strings.Cut(r.resource, "/")
+
+		ns := namespace
+		switch {
+		case r.cluster:
+			ns = ""
+		case r.namespace != "":
+			ns = r.namespace
+		}
+
+		for _, verb := range r.verbs {
+			permissions = append(permissions, core.Permission{Verb: verb, Group: r.group, Resource: resource, Subresource: subresource, Namespace: ns})
+		}
+	}
+
+	return permissions
+}
+
+func deniedChecks(checks []core.PermissionCheck) []core.PermissionCheck {
+	var denied []core.PermissionCheck
+	for _, c := range checks {
+		if !c.Allowed {
+			denied = append(denied, c)
+		}
+	}
+
+	return denied
+}
+
+func rulesOf(command string) ([]rbacRule, error) {
+	rules, ok := commandRules[command]
+	if !ok {
+		commands := make([]string, 0, len(commandRules))
+		for name := range commandRules {
+			commands = append(commands, fmt.Sprintf("%q", name))
+		}
+		sort.Strings(commands)
+
+		return nil, core.Errorf(core.ErrValidationFailed, "unknown command %q, expected one of: %s", command, strings.Join(commands, ", "))
+	}
+
+	return rules, nil
+}
+
+func (a *App) AuthRBACCmdHandler() error {
+	command := a.conf.Auth.Command
+
+	rules, err := rulesOf(command)
+	if err != nil {
+		return err
+	}
+
+	// the roles are printed on their own, so they can be piped into kubectl apply
+	out, err := minimalRoles(command, a.conf.Namespace, rules)
+	if err != nil {
+		return err
+	}
+
+	_, err = os.Stdout.Write(out)
+	return err
+}
+
+// minimalRoles renders the Roles granting the namespaced rules, the one of the namespace first and then the ones
+// of fixed namespaces, followed by the ClusterRole granting the cluster scoped ones
+func minimalRoles(command, namespace string, rules []rbacRule) ([]byte, error) {
+	name := kubeName("kmon", strings.NewReplacer(" ", "-", "--", "").Replace(command))
+
+	namespaces := []string{namespace}
+	namespaced := map[string][]rbacv1.PolicyRule{}
+	var cluster []rbacv1.PolicyRule
+	for _, r := range mergeRules(rules) {
+		switch {
+		case r.cluster:
+			cluster = append(cluster, policyRule(r))
+		case r.namespace != "":
+			if !slices.Contains(namespaces, r.namespace) {
+				namespaces = append(namespaces, r.namespace)
+			}
+			namespaced[r.namespace] = append(namespaced[r.namespace], policyRule(r))
+		default:
+			namespaced[namespace] = append(namespaced[namespace], policyRule(r))
+		}
+	}
+
+	var docs []any
+	for _, ns := range namespaces {
+		if len(namespaced[ns]) == 0 {
+			continue
+		}
+
+		docs = append(docs, rbacv1.Role{
+			TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "Role"},
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ns},
+			Rules:      namespaced[ns],
+		})
+	}
+	if len(cluster) > 0 {
+		docs = append(docs, rbacv1.ClusterRole{
+			TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "ClusterRole"},
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Rules:      cluster,
+		})
+	}
+
+	var out []byte
+	for i, doc := range docs {
+		data, err := yaml.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+
+		if i > 0 {
+			out = append(out, "---\n"...)
+		}
+		out = append(out, data...)
+	}
+
+	return out, nil
+}
+
+// mergeRules merges the verbs of the rules on the same resource, keeping the order the resources first appear in
+func mergeRules(rules []rbacRule) []rbacRule {
+	var merged []rbacRule
+	index := map[string]int{}
+	for _, r := range rules {
+		key := fmt.Sprintf("%t/%s/%s/%s", r.cluster, r.namespace, r.group, r.resource)
+
+		i, ok := index[key]
+		if !ok {
+			index[key] = len(merged)
+			merged = append(merged, rbacRule{group: r.group, resource: r.resource, cluster: r.cluster, namespace: r.namespace, verbs: slices.Clone(r.verbs)})
+			continue
+		}
+
+		for _, verb := range r.verbs {
+			if !slices.Contains(merged[i].verbs, verb) {
+				merged[i].verbs = append(merged[i].verbs, verb)
+			}
+		}
+	}
+
+	return merged
+}
+
+func policyRule(r rbacRule) rbacv1.PolicyRule {
+	return rbacv1.PolicyRule{
+		APIGroups: []string{r.group},
+		Resources: []string{r.resource},
+		Verbs:     r.verbs,
+	}
+}
@@ -0,0 +1,483 @@
+package app
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/zeljkobenovic/kmon/pkg/archive"
+	"github.com/zeljkobenovic/kmon/pkg/kube/core"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// backupManifestVersion is bumped on incompatible changes to the archive layout
+const backupManifestVersion = 1
+
+// backupManifest describes the content of a backup archive and the pvc it was taken from
+type backupManifest struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"createdAt"`
+	Source    struct {
+		Namespace string                           `json:"namespace"`
+		Name      string                           `json:"name"`
+		Spec      corev1.PersistentVolumeClaimSpec `json:"spec"`
+	} `json:"source"`
+	Capacity string `json:"capacity,omitempty"`
+	archive.Stats
+	// Checksum is the sha256 checksum of the content of all regular files, in archive order
+	Checksum string `json:"checksum"`
+}
+
+// backupState is persisted next to an incomplete backup, so an interrupted backup can be resumed.
+// Chunks are planned once, Offset is the end of the last completed chunk within the archive
+type backupState struct {
+	Namespace string        `json:"namespace"`
+	PVC       string        `json:"pvc"`
+	Chunks    [][]string    `json:"chunks"`
+	Completed int           `json:"completed"`
+	Offset    int64         `json:"offset"`
+	Hash      []byte        `json:"hash"`
+	Stats     archive.Stats `json:"stats"`
+}
+
+// archiveSummary is the outcome of the pvc backup and pvc restore-archive commands
+type archiveSummary struct {
+	PVC      string `json:"pvc"`
+	File     string `json:"file"`
+	Checksum string `json:"checksum"`
+	Resumed  bool   `json:"resumed,omitempty"`
+	archive.Stats
+}
+
+func (s archiveSummary) printText(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "  %s <-> %s: %d files, %d directories, %d bytes, %s\n", s.PVC, s.File, s.Files, s.Dirs, s.Bytes, s.Checksum)
+	return err
+}
+
+func (a *App) PVCBackupCmdHandler() error {
+	return a.withContext("pvc backup", a.backupPVC)
+}
+
+func (a *App) PVCRestoreArchiveCmdHandler() error {
+	return a.withContext("pvc restore-archive", a.restoreArchive)
+}
+
+// backupPVC streams the content of the pvc out of a read-only inspection pod, one chunk of files at a time
+func (a *App) backupPVC(ctx context.Context) error {
+	conf := a.conf.PVC.Archive
+
+	chunkSize, err := resource.ParseQuantity(conf.ChunkSize)
+	if err != nil {
+		return fmt.Errorf("invalid --chunk-size: %w", err)
+	}
+
+	pvcName, podName := conf.PVCName, ""
+
+	return a.newWorkflow("pvc backup").
+		Step(a.multiInspectionPodStep(&podName, inspectVolume{pvcName: &pvcName, mountPath: inspectMountPath, readOnly: true})).
+		Step(step{
+			name: "back up volume",
+			do: func(ctx context.Context) error {
+				return a.writeBackup(ctx, podName, chunkSize.Value())
+			},
+		}).
+		Run(ctx)
+}
+
+func (a *App) writeBackup(ctx context.Context, podName string, chunkSize int64) error {
+	conf := a.conf.PVC.Archive
+	statePath := conf.File + ".state"
+
+	pvc, err := a.core.PVC().Get(ctx, a.conf.Namespace, conf.PVCName)
+	if err != nil {
+		return err
+	}
+
+	state, err := readBackupState(statePath)
+	if err != nil {
+		return err
+	}
+
+	resumed := state != nil
+	if resumed && (state.Namespace != a.conf.Namespace || state.PVC != conf.PVCName) {
+		return core.Errorf(core.ErrValidationFailed, "%s belongs to an incomplete backup of %s/%s, remove it to start over", statePath, state.Namespace, state.PVC)
+	}
+
+	h := sha256.New()
+
+	var f *os.File
+	if resumed {
+		if err = h.(encoding.BinaryUnmarshaler).UnmarshalBinary(state.Hash); err != nil {
+			return fmt.Errorf("could not restore checksum state: %w", err)
+		}
+
+		if f, err = os.OpenFile(conf.File, os.O_RDWR, 0); err != nil {
+			return err
+		}
+		if err = f.Truncate(state.Offset); err == nil {
+			_, err = f.Seek(state.Offset, io.SeekStart)
+		}
+		if err != nil {
+			_ = f.Close()
+			return err
+		}
+
+		a.log.Info("resuming backup", "file", conf.File, "chunk", state.Completed+1, "chunks", len(state.Chunks))
+	} else {
+		if _, err = os.Stat(conf.File); err == nil && !a.conf.Force {
+			return core.Errorf(core.ErrAlreadyExists, "%s already exists, use --force to overwrite it", conf.File)
+		}
+
+		entries, err := a.listFiles(ctx, podName, inspectMountPath, true)
+		if err != nil {
+			return err
+		}
+
+		state = &backupState{Namespace: a.conf.Namespace, PVC: conf.PVCName, Chunks: planChunks(entries, chunkSize)}
+		if state.Hash, err = h.(encoding.BinaryMarshaler).MarshalBinary(); err != nil {
+			return err
+		}
+		if err = writeBackupState(statePath, state); err != nil {
+			return err
+		}
+		if f, err = os.Create(conf.File); err != nil {
+			return err
+		}
+	}
+	defer f.Close()
+
+	for state.Completed < len(state.Chunks) {
+		chunk := state.Chunks[state.Completed]
+
+		var stats archive.Stats
+		err = archive.AppendFrame(f, func(tw *tar.Writer) error {
+			s, err := a.streamChunk(ctx, podName, chunk, tw, h)
+			stats = s
+			return err
+		})
+		if err == nil {
+			err = f.Sync()
+		}
+		if err != nil {
+			return fmt.Errorf("chunk %d of %d: %w", state.Completed+1, len(state.Chunks), err)
+		}
+
+		if state.Offset, err = f.Seek(0, io.SeekCurrent); err != nil {
+			return err
+		}
+		if state.Hash, err = h.(encoding.BinaryMarshaler).MarshalBinary(); err != nil {
+			return err
+		}
+
+		state.Completed++
+		state.Stats.Files += stats.Files
+		state.Stats.Dirs += stats.Dirs
+		state.Stats.Bytes += stats.Bytes
+		state.Stats.Skipped += stats.Skipped
+
+		if err = writeBackupState(statePath, state); err != nil {
+			return err
+		}
+
+		a.log.Info("chunk written", "chunk", state.Completed, "chunks", len(state.Chunks), "bytes", state.Stats.Bytes)
+	}
+
+	m := backupManifest{
+		Version:   backupManifestVersion,
+		CreatedAt: time.Now().UTC(),
+		Stats:     state.Stats,
+		Checksum:  "sha256:" + hex.EncodeToString(h.Sum(nil)),
+	}
+	m.Source.Namespace, m.Source.Name, m.Source.Spec = pvc.Namespace, pvc.Name, pvc.Spec
+	if capacity, ok := pvc.Status.Capacity[corev1.ResourceStorage]; ok {
+		m.Capacity = capacity.String()
+	}
+
+	manifest, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err = archive.FinishBackup(f, manifest); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Remove(statePath); err != nil {
+		return err
+	}
+
+	a.result.Data = archiveSummary{PVC: conf.PVCName, File: conf.File, Checksum: m.Checksum, Resumed: resumed, Stats: m.Stats}
+	a.log.Info("backup written", "pvc", conf.PVCName, "file", conf.File, "files", m.Files, "bytes", m.Bytes)
+
+	return nil
+}
+
+// streamChunk archives the listed paths within the inspection pod and copies the entries into tw
+func (a *App) streamChunk(ctx context.Context, podName string, paths []string, tw *tar.Writer, h hash.Hash) (archive.Stats, error) {
+	var list strings.Builder
+	for _, p := range paths {
+		list.WriteString("./" + p + "\n")
+	}
+
+	pr, pw := io.Pipe()
+	var stderr bytes.Buffer
+
+	execErr := make(chan error, 1)
+	go func() {
+		err := a.core.Pod().ExecStream(ctx, a.conf.Namespace, podName, []string{
+			"tar", "cf", "-", "-C", inspectMountPath, "--no-recursion", "-T", "-",
+		}, strings.NewReader(list.String()), pw, &stderr)
+		_ = pw.CloseWithError(err)
+		execErr <- err
+	}()
+
+	stats, err := archive.CopyEntries(tw, pr, h)
+	// drain the archive padding, so the exec stream can complete
+	_, _ = io.Copy(io.Discard, pr)
+	_ = pr.Close()
+
+	if eErr := <-execErr; eErr != nil {
+		return stats, remoteError(eErr, &stderr)
+	}
+
+	return stats, err
+}
+
+// planChunks splits the entries into chunks of roughly chunkSize bytes of file content.
+// All directories go into the first chunk, so they are created before any of the files; special files are skipped
+func planChunks(entries []FileEntry, chunkSize int64) [][]string {
+	chunks := [][]string{nil}
+
+	var size int64
+	for _, e := range entries {
+		switch e.Type {
+		case fileTypeDir:
+			chunks[0] = append(chunks[0], e.Path)
+		case fileTypeFile, fileTypeSymlink:
+			if size > 0 && size+e.Size > chunkSize {
+				chunks = append(chunks, nil)
+				size = 0
+			}
+
+			chunks[len(chunks)-1] = append(chunks[len(chunks)-1], e.Path)
+			size += e.Size
+		}
+	}
+
+	return chunks
+}
+
+func readBackupState(name string) (*backupState, error) {
+	b, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var state backupState
+	if err = json.Unmarshal(b, &state); err != nil {
+		return nil, fmt.Errorf("invalid backup state %s: %w", name, err)
+	}
+
+	return &state, nil
+}
+
+// writeBackupState replaces the state file atomically, so an interruption never leaves a partial state behind
+func writeBackupState(name string, state *backupState) error {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	if err = os.WriteFile(name+".tmp", b, 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(name+".tmp", name)
+}
+
+// restoreArchive verifies the archive, creates a pvc after the spec of the source pvc and extracts the archive into it
+func (a *App) restoreArchive(ctx context.Context) error {
+	conf := a.conf.PVC.Archive
+
+	var (
+		m                backupManifest
+		pvcName, podName = conf.Name, ""
+	)
+
+	// applied when the pvc is created, once the manifest has been read
+	fromManifest := func(pvc *corev1.PersistentVolumeClaim) {
+		size := m.Source.Spec.Resources.Requests[corev1.ResourceStorage]
+		if capacity, err := resource.ParseQuantity(m.Capacity); err == nil && capacity.Cmp(size) > 0 {
+			size = capacity
+		}
+
+		opts := []core.PVCOptions{core.WithStorageSize(size)}
+		if len(m.Source.Spec.AccessModes) > 0 {
+			opts = append(opts, core.WithAccessModes(m.Source.Spec.AccessModes...))
+		}
+		if conf.StorageClassName != "" {
+			opts = append(opts, core.WithStorageClassName(conf.StorageClassName))
+		} else if m.Source.Spec.StorageClassName != nil {
+			opts = append(opts, core.WithStorageClassName(*m.Source.Spec.StorageClassName))
+		}
+
+		for _, opt := range opts {
+			opt(pvc)
+		}
+	}
+
+	opts := []core.PVCOptions{fromManifest}
+	if a.conf.GenerateName {
+		opts = append(opts, core.WithPVCGenerateName())
+	}
+
+	return a.newWorkflow("pvc restore-archive").
+		Step(step{
+			name: "verify archive",
+			do: func(ctx context.Context) error {
+				var err error
+				m, err = verifyBackup(conf.File)
+				if err != nil {
+					return err
+				}
+
+				a.log.Info("archive verified", "file", conf.File, "source", m.Source.Namespace+"/"+m.Source.Name, "checksum", m.Checksum)
+
+				return nil
+			},
+		}).
+		Step(a.pvcStep("create pvc", &pvcName, opts...)).
+		Step(a.inspectionPodStep(&pvcName, &podName)).
+		Step(step{
+			name: "extract archive",
+			do: func(ctx context.Context) error {
+				stats, err := a.extractBackup(ctx, podName, conf.File)
+				if err != nil {
+					return err
+				}
+
+				a.result.Data = archiveSummary{PVC: pvcName, File: conf.File, Checksum: m.Checksum, Stats: stats}
+				a.log.Info("archive restored", "file", conf.File, "pvc", pvcName, "files", stats.Files, "bytes", stats.Bytes)
+
+				return nil
+			},
+		}).
+		Run(ctx)
+}
+
+// verifyBackup reads the whole archive, checking its content against the checksum of the manifest
+func verifyBackup(name string) (backupManifest, error) {
+	var m backupManifest
+
+	f, err := os.Open(name)
+	if err != nil {
+		return m, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	raw, err := archive.ReadBackup(f, func(hdr *tar.Header, content io.Reader) error {
+		if hdr.Typeflag != tar.TypeReg {
+			return nil
+		}
+
+		_, err := io.Copy(h, content)
+		return err
+	})
+	if err != nil {
+		return m, err
+	}
+
+	if err = json.Unmarshal(raw, &m); err != nil {
+		return m, fmt.Errorf("invalid manifest: %w", err)
+	}
+	if m.Version != backupManifestVersion {
+		return m, core.Errorf(core.ErrValidationFailed, "unsupported archive version %d", m.Version)
+	}
+
+	if sum := "sha256:" + hex.EncodeToString(h.Sum(nil)); sum != m.Checksum {
+		return m, core.Errorf(core.ErrValidationFailed, "checksum mismatch, the archive is corrupted: expected %s, got %s", m.Checksum, sum)
+	}
+
+	return m, nil
+}
+
+// extractBackup streams the data entries of the archive into the inspection pod, extracting them into the volume
+func (a *App) extractBackup(ctx context.Context, podName, name string) (archive.Stats, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return archive.Stats{}, err
+	}
+	defer f.Close()
+
+	pr, pw := io.Pipe()
+	var stderr bytes.Buffer
+
+	type archiveResult struct {
+		stats archive.Stats
+		err   error
+	}
+
+	archived := make(chan archiveResult, 1)
+	go func() {
+		var stats archive.Stats
+
+		tw := tar.NewWriter(pw)
+		_, err := archive.ReadBackup(f, func(hdr *tar.Header, content io.Reader) error {
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+
+			switch hdr.Typeflag {
+			case tar.TypeDir:
+				stats.Dirs++
+			case tar.TypeReg:
+				n, err := io.Copy(tw, content)
+				if err != nil {
+					return err
+				}
+
+				stats.Files++
+				stats.Bytes += n
+			}
+
+			return nil
+		})
+		if err == nil {
+			err = tw.Close()
+		}
+
+		_ = pw.CloseWithError(err)
+		archived <- archiveResult{stats: stats, err: err}
+	}()
+
+	err = a.core.Pod().ExecStream(ctx, a.conf.Namespace, podName, []string{"tar", "xf", "-", "-C", inspectMountPath}, pr, nil, &stderr)
+	_ = pr.Close()
+
+	res := <-archived
+	if err != nil {
+		return res.stats, remoteError(err, &stderr)
+	}
+	if res.err != nil && !errors.Is(res.err, io.ErrClosedPipe) {
+		return res.stats, res.err
+	}
+
+	return res.stats, nil
+}
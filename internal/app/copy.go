@@ -0,0 +1,195 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/zeljkobenovic/kmon/pkg/archive"
+	"github.com/zeljkobenovic/kmon/pkg/kube/core"
+)
+
+// copyRef is one side of a copy, either a local path or a path within a volume written as <pvc>:<path>
+type copyRef struct {
+	volume string
+	path   string
+}
+
+func parseCopyRef(ref string) copyRef {
+	volume, p, found := strings.Cut(ref, ":")
+	// a single letter before the colon is a drive letter on Windows, not a pvc
+	if !found || volume == "" || (runtime.GOOS == "windows" && len(volume) == 1) {
+		return copyRef{path: ref}
+	}
+
+	return copyRef{volume: volume, path: p}
+}
+
+// copySummary is the outcome of the pvc cp command
+type copySummary struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	archive.Stats
+}
+
+func (c copySummary) printText(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "  copied %s to %s: %d files, %d directories, %d bytes\n", c.Source, c.Destination, c.Files, c.Dirs, c.Bytes)
+	return err
+}
+
+func (a *App) PVCCopyCmdHandler() error {
+	return a.withContext("pvc cp", a.copyPVC)
+}
+
+func (a *App) copyPVC(ctx context.Context) error {
+	src, dst := parseCopyRef(a.conf.PVC.Copy.Source), parseCopyRef(a.conf.PVC.Copy.Destination)
+
+	var (
+		pvcName, podName string
+		copyStep         step
+	)
+
+	switch {
+	case src.volume != "" && dst.volume == "":
+		pvcName = src.volume
+		copyStep = a.downloadStep(&podName, src.path, dst.path)
+	case src.volume == "" && dst.volume != "":
+		if a.conf.PVC.Copy.FromSnapshot {
+			return core.Errorf(core.ErrValidationFailed, "--from-snapshot can only be used to copy from a snapshot")
+		}
+
+		pvcName = dst.volume
+		copyStep = a.uploadStep(&podName, src.path, dst.path)
+	default:
+		return core.Errorf(core.ErrValidationFailed, "exactly one of the source and the destination has to be a volume path, written as <pvc>:<path>")
+	}
+
+	wf := a.newWorkflow("pvc cp")
+	if a.conf.PVC.Copy.FromSnapshot {
+		wf.Step(a.restoreSnapshotStep(src.volume, &pvcName))
+	}
+
+	return wf.
+		Step(a.inspectionPodStep(&pvcName, &podName)).
+		Step(copyStep).
+		Run(ctx)
+}
+
+// downloadStep streams a tar archive of the volume path out of the inspection pod and extracts it to the local path.
+// As with cp, copying into an existing directory places the copied file or directory inside of it
+func (a *App) downloadStep(podName *string, volumePath, localPath string) step {
+	return step{
+		name: "copy from volume",
+		do: func(ctx context.Context) error {
+			remote := inspectPath(volumePath)
+			dir, base := path.Dir(remote), path.Base(remote)
+			if remote == inspectMountPath {
+				dir, base = inspectMountPath, "."
+			}
+
+			dest := localPath
+			if fi, err := os.Stat(dest); err == nil && fi.IsDir() && base != "." {
+				dest = filepath.Join(dest, base)
+			}
+
+			pr, pw := io.Pipe()
+			var stderr bytes.Buffer
+
+			execErr := make(chan error, 1)
+			go func() {
+				err := a.core.Pod().ExecStream(ctx, a.conf.Namespace, *podName, []string{"tar", "cf", "-", "-C", dir, base}, nil, pw, &stderr)
+				_ = pw.CloseWithError(err)
+				execErr <- err
+			}()
+
+			stats, err := archive.Extract(pr, base, dest)
+			// drain the archive padding, so the exec stream can complete
+			_, _ = io.Copy(io.Discard, pr)
+			_ = pr.Close()
+
+			if eErr := <-execErr; eErr != nil {
+				return remoteError(eErr, &stderr)
+			}
+			if err != nil {
+				return err
+			}
+
+			a.result.Data = copySummary{Source: volumePath, Destination: dest, Stats: stats}
+			a.log.Info("copied from volume", "path", volumePath, "destination", dest, "files", stats.Files, "bytes", stats.Bytes)
+
+			return nil
+		},
+	}
+}
+
+// uploadStep streams a tar archive of the local path into the inspection pod, extracting it at the volume path
+func (a *App) uploadStep(podName *string, localPath, volumePath string) step {
+	return step{
+		name: "copy to volume",
+		do: func(ctx context.Context) error {
+			fi, err := os.Stat(localPath)
+			if err != nil {
+				return err
+			}
+
+			remote := inspectPath(volumePath)
+			dir, root := path.Dir(remote), path.Base(remote)
+			if remote == inspectMountPath {
+				if !fi.IsDir() {
+					return core.Errorf(core.ErrValidationFailed, "copying a file requires a destination file path within the volume")
+				}
+
+				dir, root = inspectMountPath, "."
+			}
+
+			pr, pw := io.Pipe()
+			var stderr bytes.Buffer
+
+			type archiveResult struct {
+				stats archive.Stats
+				err   error
+			}
+
+			archived := make(chan archiveResult, 1)
+			go func() {
+				stats, err := archive.Write(pw, localPath, root)
+				_ = pw.CloseWithError(err)
+				archived <- archiveResult{stats: stats, err: err}
+			}()
+
+			err = a.core.Pod().ExecStream(ctx, a.conf.Namespace, *podName, []string{
+				"sh", "-c", `mkdir -p "$1" && tar xf - -C "$1"`, "sh", dir,
+			}, pr, nil, &stderr)
+			_ = pr.Close()
+
+			res := <-archived
+			if err != nil {
+				return remoteError(err, &stderr)
+			}
+			if res.err != nil && !errors.Is(res.err, io.ErrClosedPipe) {
+				return res.err
+			}
+
+			a.result.Data = copySummary{Source: localPath, Destination: volumePath, Stats: res.stats}
+			a.log.Info("copied to volume", "source", localPath, "path", volumePath, "files", res.stats.Files, "bytes", res.stats.Bytes)
+
+			return nil
+		},
+	}
+}
+
+// remoteError enriches the error of a command executed in a pod with its stderr output
+func remoteError(err error, stderr *bytes.Buffer) error {
+	if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		return fmt.Errorf("%w: %s", err, msg)
+	}
+
+	return err
+}
@@ -0,0 +1,387 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	v3 "github.com/kubernetes-csi/external-snapshotter/client/v8/apis/volumesnapshot/v1"
+	"github.com/zeljkobenovic/kmon/pkg/kube/core"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	kindCSIVolume        = "CSIVolume"
+	kindVolumeAttachment = "VolumeAttachment"
+	kindPod              = "Pod"
+
+	// describeEventLimit bounds the events shown for every object of the lineage
+	describeEventLimit = 5
+)
+
+// LineageNode is an object in the storage lineage of a pvc, with the objects derived from it as children
+type LineageNode struct {
+	Kind      string        `json:"kind"`
+	Namespace string        `json:"namespace,omitempty"`
+	Name      string        `json:"name"`
+	Status    string        `json:"status,omitempty"`
+	Details   []string      `json:"details,omitempty"`
+	Events    []core.Event  `json:"events,omitempty"`
+	Children  []LineageNode `json:"children,omitempty"`
+}
+
+func (n LineageNode) printText(w io.Writer) error {
+	return n.printTree(w, "  ", "")
+}
+
+// printTree prints the node after the branch connecting it to its parent, and its children indented by prefix
+func (n LineageNode) printTree(w io.Writer, prefix, branch string) error {
+	name := n.Name
+	if n.Namespace != "" {
+		name = n.Namespace + "/" + name
+	}
+
+	header := n.Kind + " " + name
+	if n.Status != "" {
+		header += " [" + n.Status + "]"
+	}
+	if _, err := fmt.Fprintln(w, prefix+branch+header); err != nil {
+		return err
+	}
+
+	switch branch {
+	case "├── ":
+		prefix += "│   "
+	case "└── ":
+		prefix += "    "
+	}
+
+	detailPrefix := prefix + "  "
+	if len(n.Children) > 0 {
+		detailPrefix = prefix + "│ "
+	}
+
+	for _, d := range n.Details {
+		if _, err := fmt.Fprintln(w, detailPrefix+d); err != nil {
+			return err
+		}
+	}
+	for _, e := range n.Events {
+		if _, err := fmt.Fprintf(w, "%s%s ago %s %s: %s\n", detailPrefix, humanAge(e.Time), e.Type, e.Reason, e.Message); err != nil {
+			return err
+		}
+	}
+
+	for i, c := range n.Children {
+		childBranch := "├── "
+		if i == len(n.Children)-1 {
+			childBranch = "└── "
+		}
+
+		if err := c.printTree(w, prefix, childBranch); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (a *App) PVCDescribeCmdHandler() error {
+	return a.withContext("pvc describe", a.describePVC)
+}
+
+// describePVC collects the lineage of the pvc: its volume down to the node attachments, the consuming pods,
+// the snapshots taken from it and the pvcs restored from those snapshots
+func (a *App) describePVC(ctx context.Context) error {
+	ns, name := a.conf.Namespace, a.conf.PVC.Describe.PVCName
+
+	claim, err := a.core.PVC().Get(ctx, ns, name)
+	if err != nil {
+		return err
+	}
+
+	root := a.pvcNode(ctx, *claim)
+
+	if claim.Spec.VolumeName != "" {
+		volNode, err := a.pvNode(ctx, claim.Spec.VolumeName)
+		if err != nil {
+			return err
+		}
+		root.Children = append(root.Children, volNode)
+	}
+
+	pods, err := a.core.Pod().List(ctx, ns)
+	if err != nil {
+		return err
+	}
+	for _, po := range pods {
+		for _, pvcName := range core.PVCNames(po.Spec) {
+			if pvcName == name {
+				root.Children = append(root.Children, a.podNode(ctx, po))
+				break
+			}
+		}
+	}
+
+	snapshots, err := a.core.PVC().ListVolumeSnapshots(ctx, ns)
+	if err != nil {
+		return err
+	}
+
+	pvcs, err := a.core.PVC().List(ctx, ns)
+	if err != nil {
+		return err
+	}
+
+	for _, vs := range snapshots {
+		if source := vs.Spec.Source.PersistentVolumeClaimName; source == nil || *source != name {
+			continue
+		}
+
+		vsNode := a.snapshotNode(ctx, vs)
+		for _, restored := range pvcs {
+			if restoredFrom(restored, vs.Name) {
+				vsNode.Children = append(vsNode.Children, a.pvcNode(ctx, restored))
+			}
+		}
+
+		root.Children = append(root.Children, vsNode)
+	}
+
+	a.result.Data = root
+	a.log.Info("described pvc", "pvc", ns+"/"+name, "volume", claim.Spec.VolumeName)
+
+	return nil
+}
+
+func (a *App) pvcNode(ctx context.Context, claim corev1.PersistentVolumeClaim) LineageNode {
+	requested := claim.Spec.Resources.Requests[corev1.ResourceStorage]
+
+	n := LineageNode{
+		Kind:      kindPVC,
+		Namespace: claim.Namespace,
+		Name:      claim.Name,
+		Status:    string(claim.Status.Phase),
+		Events:    a.lineageEvents(ctx, claim.Namespace, claim.UID),
+	}
+
+	if claim.Spec.StorageClassName != nil {
+		n.Details = append(n.Details, "storage class: "+*claim.Spec.StorageClassName)
+	}
+
+	size := "requested: " + requested.String()
+	if capacity, ok := claim.Status.Capacity[corev1.ResourceStorage]; ok {
+		size += ", capacity: " + capacity.String()
+	}
+	n.Details = append(n.Details, size)
+
+	if modes := accessModes(claim.Spec.AccessModes); modes != "" {
+		n.Details = append(n.Details, "access modes: "+modes)
+	}
+	if src := claim.Spec.DataSource; src != nil {
+		n.Details = append(n.Details, fmt.Sprintf("data source: %s %s", src.Kind, src.Name))
+	}
+	if claim.Status.Phase == corev1.ClaimPending && claim.Spec.VolumeName == "" {
+		n.Details = append(n.Details, "not bound to any pv yet")
+	}
+	for _, c := range claim.Status.Conditions {
+		n.Details = append(n.Details, conditionDetail(string(c.Type), string(c.Status), c.Reason, c.Message))
+	}
+
+	return n
+}
+
+// pvNode describes the volume down to its csi handle and node attachments. A missing volume is reported, not returned
+func (a *App) pvNode(ctx context.Context, name string) (LineageNode, error) {
+	vol, err := a.core.PV().Get(ctx, name)
+	if apierrors.IsNotFound(err) {
+		return LineageNode{Kind: kindPV, Name: name, Status: "Missing"}, nil
+	}
+	if err != nil {
+		return LineageNode{}, err
+	}
+
+	capacity := vol.Spec.Capacity[corev1.ResourceStorage]
+
+	n := LineageNode{
+		Kind:   kindPV,
+		Name:   vol.Name,
+		Status: string(vol.Status.Phase),
+		Details: []string{
+			"reclaim policy: " + string(vol.Spec.PersistentVolumeReclaimPolicy),
+			"capacity: " + capacity.String(),
+		},
+		Events: a.lineageEvents(ctx, "", vol.UID),
+	}
+
+	if ref := vol.Spec.ClaimRef; ref != nil {
+		n.Details = append(n.Details, fmt.Sprintf("claim: %s/%s", ref.Namespace, ref.Name))
+	}
+	if vol.Status.Message != "" {
+		n.Details = append(n.Details, "message: "+vol.Status.Message)
+	}
+
+	attachments, err := a.core.PV().Attachments(ctx, vol.Name)
+	if err != nil {
+		return LineageNode{}, err
+	}
+
+	var attachmentNodes []LineageNode
+	for _, va := range attachments {
+		attachmentNodes = append(attachmentNodes, a.attachmentNode(ctx, va))
+	}
+
+	csi := vol.Spec.CSI
+	if csi == nil {
+		n.Details = append(n.Details, "not provisioned by a csi driver")
+		n.Children = attachmentNodes
+		return n, nil
+	}
+
+	csiNode := LineageNode{
+		Kind:     kindCSIVolume,
+		Name:     csi.Driver,
+		Details:  []string{"volume handle: " + csi.VolumeHandle},
+		Children: attachmentNodes,
+	}
+	if csi.FSType != "" {
+		csiNode.Details = append(csiNode.Details, "fs type: "+csi.FSType)
+	}
+	if len(attachmentNodes) == 0 {
+		csiNode.Details = append(csiNode.Details, "not attached to any node")
+	}
+
+	n.Children = []LineageNode{csiNode}
+
+	return n, nil
+}
+
+func (a *App) attachmentNode(ctx context.Context, va storagev1.VolumeAttachment) LineageNode {
+	n := LineageNode{
+		Kind:    kindVolumeAttachment,
+		Name:    va.Name,
+		Status:  "Detached",
+		Details: []string{"node: " + va.Spec.NodeName},
+		Events:  a.lineageEvents(ctx, "", va.UID),
+	}
+
+	if va.Status.Attached {
+		n.Status = "Attached"
+	}
+	if va.DeletionTimestamp != nil {
+		n.Status = "Detaching"
+	}
+	if e := va.Status.AttachError; e != nil {
+		n.Status = "AttachError"
+		n.Details = append(n.Details, fmt.Sprintf("attach error %s ago: %s", humanAge(e.Time.Time), e.Message))
+	}
+	if e := va.Status.DetachError; e != nil {
+		n.Status = "DetachError"
+		n.Details = append(n.Details, fmt.Sprintf("detach error %s ago: %s", humanAge(e.Time.Time), e.Message))
+	}
+
+	return n
+}
+
+func (a *App) podNode(ctx context.Context, po corev1.Pod) LineageNode {
+	n := LineageNode{
+		Kind:      kindPod,
+		Namespace: po.Namespace,
+		Name:      po.Name,
+		Status:    string(po.Status.Phase),
+		Events:    a.lineageEvents(ctx, po.Namespace, po.UID),
+	}
+
+	if po.Spec.NodeName != "" {
+		n.Details = append(n.Details, "node: "+po.Spec.NodeName)
+	}
+	for _, cs := range po.Status.ContainerStatuses {
+		if w := cs.State.Waiting; w != nil {
+			n.Details = append(n.Details, fmt.Sprintf("container %s waiting: %s", cs.Name, w.Reason))
+		}
+	}
+
+	return n
+}
+
+func (a *App) snapshotNode(ctx context.Context, vs v3.VolumeSnapshot) LineageNode {
+	n := LineageNode{
+		Kind:      kindVolumeSnapshot,
+		Namespace: vs.Namespace,
+		Name:      vs.Name,
+		Status:    "Pending",
+		Events:    a.lineageEvents(ctx, vs.Namespace, vs.UID),
+	}
+
+	if vs.Spec.VolumeSnapshotClassName != nil {
+		n.Details = append(n.Details, "snapshot class: "+*vs.Spec.VolumeSnapshotClassName)
+	}
+
+	if st := vs.Status; st != nil {
+		if st.ReadyToUse != nil && *st.ReadyToUse {
+			n.Status = "Ready"
+		}
+		if st.RestoreSize != nil {
+			n.Details = append(n.Details, "restore size: "+st.RestoreSize.String())
+		}
+		if st.BoundVolumeSnapshotContentName != nil {
+			n.Details = append(n.Details, "content: "+*st.BoundVolumeSnapshotContentName)
+		}
+		if st.Error != nil && st.Error.Message != nil {
+			n.Status = "Error"
+			n.Details = append(n.Details, "error: "+*st.Error.Message)
+		}
+	}
+
+	n.Details = append(n.Details, fmt.Sprintf("taken %s ago", humanAge(vs.CreationTimestamp.Time)))
+
+	return n
+}
+
+// restoredFrom tells whether the pvc was restored from the snapshot of the same namespace
+func restoredFrom(claim corev1.PersistentVolumeClaim, snapshotName string) bool {
+	if src := claim.Spec.DataSource; src != nil && src.Kind == kindVolumeSnapshot && src.Name == snapshotName {
+		return true
+	}
+
+	ref := claim.Spec.DataSourceRef
+	return ref != nil && ref.Kind == kindVolumeSnapshot && ref.Name == snapshotName &&
+		(ref.Namespace == nil || *ref.Namespace == claim.Namespace)
+}
+
+// lineageEvents fetches the recent events of an object. Events are informational, so failing to read them
+// does not fail the command
+func (a *App) lineageEvents(ctx context.Context, namespace string, uid types.UID) []core.Event {
+	events, err := a.core.Events().Recent(ctx, namespace, uid, describeEventLimit)
+	if err != nil {
+		a.log.Warn("could not read events", "namespace", namespace, "uid", uid, "err", err)
+		return nil
+	}
+
+	return events
+}
+
+func accessModes(modes []corev1.PersistentVolumeAccessMode) string {
+	out := make([]string, 0, len(modes))
+	for _, m := range modes {
+		out = append(out, string(m))
+	}
+
+	return strings.Join(out, ", ")
+}
+
+func conditionDetail(condType, status, reason, message string) string {
+	detail := fmt.Sprintf("condition %s=%s", condType, status)
+	if reason != "" {
+		detail += " (" + reason + ")"
+	}
+	if message != "" {
+		detail += ": " + message
+	}
+
+	return detail
+}
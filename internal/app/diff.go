@@ -0,0 +1,249 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/zeljkobenovic/kmon/pkg/kube/core"
+)
+
+type changeType string
+
+const (
+	changeAdded   changeType = "added"
+	changeRemoved changeType = "removed"
+	changeChanged changeType = "changed"
+)
+
+// FileChange is a single difference between two volumes
+type FileChange struct {
+	Path        string     `json:"path"`
+	Change      changeType `json:"change"`
+	Type        string     `json:"type"`
+	OldSize     int64      `json:"oldSize,omitempty"`
+	NewSize     int64      `json:"newSize,omitempty"`
+	OldChecksum string     `json:"oldChecksum,omitempty"`
+	NewChecksum string     `json:"newChecksum,omitempty"`
+}
+
+// volumeDiff is the outcome of the snapshot diff command
+type volumeDiff struct {
+	Old     string       `json:"old"`
+	New     string       `json:"new"`
+	Path    string       `json:"path"`
+	Changes []FileChange `json:"changes"`
+}
+
+func (d volumeDiff) printText(w io.Writer) error {
+	if len(d.Changes) == 0 {
+		_, err := fmt.Fprintf(w, "  no differences between %s and %s\n", d.Old, d.New)
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	if _, err := fmt.Fprintln(tw, "CHANGE\tOLD SIZE\tNEW SIZE\tSHA256\tPATH"); err != nil {
+		return err
+	}
+
+	for _, c := range d.Changes {
+		oldSize, newSize := "-", "-"
+		if c.Change != changeAdded {
+			oldSize = humanBytes(c.OldSize)
+		}
+		if c.Change != changeRemoved {
+			newSize = humanBytes(c.NewSize)
+		}
+
+		checksum := shortChecksum(c.NewChecksum)
+		if c.Change == changeRemoved {
+			checksum = shortChecksum(c.OldChecksum)
+		}
+
+		if _, err := fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", c.Change, oldSize, newSize, checksum, c.Path); err != nil {
+			return err
+		}
+	}
+
+	return tw.Flush()
+}
+
+func shortChecksum(sum string) string {
+	if len(sum) > 12 {
+		return sum[:12]
+	}
+	if sum == "" {
+		return "-"
+	}
+
+	return sum
+}
+
+const (
+	diffOldMountPath = inspectMountPath + "/old"
+	diffNewMountPath = inspectMountPath + "/new"
+)
+
+func (a *App) SnapshotDiffCmdHandler() error {
+	return a.withContext("snapshot diff", a.diffSnapshots)
+}
+
+// diffSnapshots restores the snapshots into temporary pvcs, mounts them read-only into a single inspection pod
+// and compares their content. The new side can be a live pvc instead of a snapshot
+func (a *App) diffSnapshots(ctx context.Context) error {
+	conf := a.conf.Snapshot.Diff
+
+	if (conf.New == "") == (conf.AgainstPVC == "") {
+		return core.Errorf(core.ErrValidationFailed, "either a second snapshot or --against-pvc has to be specified")
+	}
+
+	var oldPVC, newPVC, podName string
+
+	wf := a.newWorkflow("snapshot diff").
+		Step(a.restoreSnapshotStep(conf.Old, &oldPVC))
+
+	newSource := conf.New
+	if conf.AgainstPVC != "" {
+		newPVC, newSource = conf.AgainstPVC, "pvc/"+conf.AgainstPVC
+	} else {
+		wf.Step(a.restoreSnapshotStep(conf.New, &newPVC))
+	}
+
+	return wf.
+		Step(a.multiInspectionPodStep(&podName,
+			inspectVolume{pvcName: &oldPVC, mountPath: diffOldMountPath, readOnly: true},
+			inspectVolume{pvcName: &newPVC, mountPath: diffNewMountPath, readOnly: true},
+		)).
+		Step(step{
+			name: "compare files",
+			do: func(ctx context.Context) error {
+				volumePath := path.Clean("/" + conf.Path)
+
+				oldFiles, oldFound, err := a.checksummedFiles(ctx, podName, path.Join(diffOldMountPath, volumePath))
+				if err != nil {
+					return fmt.Errorf("could not list %s: %w", conf.Old, err)
+				}
+
+				newFiles, newFound, err := a.checksummedFiles(ctx, podName, path.Join(diffNewMountPath, volumePath))
+				if err != nil {
+					return fmt.Errorf("could not list %s: %w", newSource, err)
+				}
+
+				if !oldFound && !newFound {
+					return core.Errorf(core.ErrNotFound, "path %s exists in neither %s nor %s", volumePath, conf.Old, newSource)
+				}
+
+				changes := compareFiles(oldFiles, newFiles)
+				a.result.Data = volumeDiff{Old: conf.Old, New: newSource, Path: volumePath, Changes: changes}
+				a.log.Info("compared volumes", "old", conf.Old, "new", newSource, "changes", len(changes))
+
+				return nil
+			},
+		}).
+		Run(ctx)
+}
+
+// checksummedFile is a file entry along with the checksum of its content
+type checksummedFile struct {
+	FileEntry
+	checksum string
+}
+
+// checksummedFiles lists all files below root recursively, with the sha256 checksum of the regular files.
+// A missing root is listed as empty, as its files were added or removed, and reported by the returned bool
+func (a *App) checksummedFiles(ctx context.Context, podName, root string) (map[string]checksummedFile, bool, error) {
+	res, err := a.core.Pod().ExecCapture(ctx, a.conf.Namespace, podName, "", []string{"test", "-e", root})
+	if err != nil {
+		return nil, false, err
+	}
+	if res.ExitCode != 0 {
+		a.log.Info("path does not exist", "path", root)
+		return map[string]checksummedFile{}, false, nil
+	}
+
+	entries, err := a.listFiles(ctx, podName, root, true)
+	if err != nil {
+		return nil, true, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := []string{"find", root, "-type", "f", "-exec", "sha256sum", "{}", "+"}
+	if err = a.core.Pod().ExecStream(ctx, a.conf.Namespace, podName, cmd, nil, &stdout, &stderr); err != nil {
+		return nil, true, remoteError(err, &stderr)
+	}
+
+	sums, err := parseChecksums(&stdout, root)
+	if err != nil {
+		return nil, true, err
+	}
+
+	files := make(map[string]checksummedFile, len(entries))
+	for _, e := range entries {
+		files[e.Path] = checksummedFile{FileEntry: e, checksum: sums[e.Path]}
+	}
+
+	return files, true, nil
+}
+
+// parseChecksums parses the "checksum  name" lines printed by sha256sum, keyed by the path relative to root
+func parseChecksums(r io.Reader, root string) (map[string]string, error) {
+	sums := map[string]string{}
+
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		sum, name, found := strings.Cut(sc.Text(), "  ")
+		if !found {
+			return nil, fmt.Errorf("unexpected sha256sum output: %q", sc.Text())
+		}
+
+		rel := strings.TrimPrefix(strings.TrimPrefix(name, root), "/")
+		if rel == "" {
+			rel = path.Base(name)
+		}
+
+		sums[rel] = sum
+	}
+
+	return sums, sc.Err()
+}
+
+// compareFiles reports the files added, removed and changed between the old and the new listing.
+// Directories are only reported when added or removed, files when their type, size or content differ
+func compareFiles(oldFiles, newFiles map[string]checksummedFile) []FileChange {
+	var changes []FileChange
+
+	for p, o := range oldFiles {
+		n, ok := newFiles[p]
+		switch {
+		case !ok:
+			changes = append(changes, FileChange{Path: p, Change: changeRemoved, Type: o.Type, OldSize: o.Size, OldChecksum: o.checksum})
+		case o.Type != n.Type || (o.Type != fileTypeDir && (o.Size != n.Size || o.checksum != n.checksum)):
+			changes = append(changes, FileChange{
+				Path:        p,
+				Change:      changeChanged,
+				Type:        n.Type,
+				OldSize:     o.Size,
+				NewSize:     n.Size,
+				OldChecksum: o.checksum,
+				NewChecksum: n.checksum,
+			})
+		}
+	}
+
+	for p, n := range newFiles {
+		if _, ok := oldFiles[p]; !ok {
+			changes = append(changes, FileChange{Path: p, Change: changeAdded, Type: n.Type, NewSize: n.Size, NewChecksum: n.checksum})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+
+	return changes
+}
@@ -0,0 +1,356 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	v3 "github.com/kubernetes-csi/external-snapshotter/client/v8/apis/volumesnapshot/v1"
+	"github.com/zeljkobenovic/kmon/pkg/kube/core"
+	storagev1 "k8s.io/api/storage/v1"
+)
+
+const (
+	problemError   = "error"
+	problemWarning = "warning"
+
+	// inTreeProvisionerPrefix is the prefix of the provisioners built into Kubernetes, which do not support snapshots
+	inTreeProvisionerPrefix = "kubernetes.io/"
+
+	// cloningUnknown is the cloning support of CSI drivers, which do not advertise whether they implement it
+	cloningUnknown = "unknown"
+	cloningNo      = "no"
+)
+
+// doctorReport is the snapshot and CSI support of the cluster, with the problems found on the way
+type doctorReport struct {
+	SnapshotAPI         core.SnapshotAPI          `json:"snapshotAPI"`
+	SnapshotControllers []core.SnapshotController `json:"snapshotControllers"`
+	Drivers             []driverReport            `json:"drivers"`
+	StorageClasses      []storageClassReport      `json:"storageClasses"`
+	SnapshotClasses     []snapshotClassReport     `json:"snapshotClasses"`
+	Problems            []doctorProblem           `json:"problems,omitempty"`
+}
+
+// driverReport is a CSI driver, known from its CSIDriver object or from the snapshot classes naming it
+type driverReport struct {
+	Name                 string   `json:"name"`
+	Registered           bool     `json:"registered"`
+	AttachRequired       bool     `json:"attachRequired"`
+	StorageClasses       []string `json:"storageClasses,omitempty"`
+	SnapshotClasses      []string `json:"snapshotClasses,omitempty"`
+	DefaultSnapshotClass string   `json:"defaultSnapshotClass,omitempty"`
+}
+
+type storageClassReport struct {
+	Name        string `json:"name"`
+	Provisioner string `json:"provisioner"`
+	Default     bool   `json:"default"`
+	Expansion   bool   `json:"expansion"`
+	// CSI is set if the provisioner is a CSI driver registered by a CSIDriver object
+	CSI bool `json:"csi"`
+	// Cloning is no for provisioners other than CSI drivers, and unknown for CSI drivers
+	Cloning       string `json:"cloning"`
+	SnapshotClass string `json:"snapshotClass,omitempty"`
+}
+
+type snapshotClassReport struct {
+	Name           string `json:"name"`
+	Driver         string `json:"driver"`
+	DeletionPolicy string `json:"deletionPolicy"`
+	Default        bool   `json:"default"`
+}
+
+type doctorProblem struct {
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+func (r *doctorReport) problem(severity, format string, args ...any) {
+	r.Problems = append(r.Problems, doctorProblem{Severity: severity, Message: fmt.Sprintf(format, args...)})
+}
+
+// errors returns the messages of the problems preventing snapshots
+func (r *doctorReport) errors() []string {
+	var messages []string
+	for _, p := range r.Problems {
+		if p.Severity == problemError {
+			messages = append(messages, p.Message)
+		}
+	}
+
+	return messages
+}
+
+func (r doctorReport) printText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	versions, preferred, resources := "-", "-", "-"
+	if len(r.SnapshotAPI.Versions) > 0 {
+		versions, preferred = strings.Join(r.SnapshotAPI.Versions, ","), r.SnapshotAPI.PreferredVersion
+	}
+	if len(r.SnapshotAPI.Resources) > 0 {
+		resources = strings.Join(r.SnapshotAPI.Resources, ",")
+	}
+
+	lines := []string{
+		"snapshot api " + core.SnapshotGroup,
+		"  VERSIONS\tPREFERRED\tRESOURCES",
+		fmt.Sprintf("  %s\t%s\t%s", versions, preferred, resources),
+		"snapshot controllers",
+		"  NAMESPACE\tNAME\tREADY\tIMAGE",
+	}
+	for _, c := range r.SnapshotControllers {
+		lines = append(lines, fmt.Sprintf("  %s\t%s\t%d/%d\t%s", c.Namespace, c.Name, c.Ready, c.Replicas, c.Image))
+	}
+
+	lines = append(lines, "csi drivers", "  DRIVER\tREGISTERED\tATTACH\tSTORAGE CLASSES\tSNAPSHOT CLASSES\tDEFAULT SNAPSHOT CLASS")
+	for _, d := range r.Drivers {
+		lines = append(lines, fmt.Sprintf("  %s\t%s\t%s\t%s\t%s\t%s", d.Name, yesNo(d.Registered), yesNo(d.AttachRequired),
+			orDash(strings.Join(d.StorageClasses, ",")), orDash(strings.Join(d.SnapshotClasses, ",")), orDash(d.DefaultSnapshotClass)))
+	}
+
+	lines = append(lines, "storage classes", "  NAME\tPROVISIONER\tCSI\tDEFAULT\tEXPANSION\tCLONING\tSNAPSHOT CLASS")
+	for _, sc := range r.StorageClasses {
+		lines = append(lines, fmt.Sprintf("  %s\t%s\t%s\t%s\t%s\t%s\t%s", sc.Name, sc.Provisioner, yesNo(sc.CSI), yesNo(sc.Default),
+			yesNo(sc.Expansion), sc.Cloning, orDash(sc.SnapshotClass)))
+	}
+
+	lines = append(lines, "snapshot classes", "  NAME\tDRIVER\tDELETION POLICY\tDEFAULT")
+	for _, vsc := range r.SnapshotClasses {
+		lines = append(lines, fmt.Sprintf("  %s\t%s\t%s\t%s", vsc.Name, vsc.Driver, vsc.DeletionPolicy, yesNo(vsc.Default)))
+	}
+
+	if len(r.Problems) > 0 {
+		lines = append(lines, "problems")
+	}
+	for _, p := range r.Problems {
+		lines = append(lines, fmt.Sprintf("  %s\t%s", p.Severity, p.Message))
+	}
+
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(tw, line); err != nil {
+			return err
+		}
+	}
+
+	return tw.Flush()
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+
+	return "no"
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
+
+func (a *App) DoctorCmdHandler() error {
+	return a.withContext("doctor", a.doctor)
+}
+
+// doctor reports the snapshot and CSI support of the cluster. It fails if snapshots can not work at all,
+// problems which only affect some drivers or classes are reported as warnings
+func (a *App) doctor(ctx context.Context) error {
+	report := &doctorReport{}
+	a.result.Data = report
+
+	storage := a.core.Storage()
+
+	api, err := storage.SnapshotAPI(ctx)
+	if err != nil {
+		return err
+	}
+	report.SnapshotAPI = api
+
+	switch {
+	case len(api.Versions) == 0:
+		report.problem(problemError, "the %s CRDs are not installed", core.SnapshotGroup)
+	case !slices.Contains(api.Versions, core.SnapshotVersion):
+		report.problem(problemError, "%s is served in %s only, kmon needs %s", core.SnapshotGroup, strings.Join(api.Versions, ","), core.SnapshotVersion)
+	case len(api.Missing()) > 0:
+		report.problem(problemError, "%s/%s does not serve %s", core.SnapshotGroup, core.SnapshotVersion, strings.Join(api.Missing(), ","))
+	}
+
+	if report.SnapshotControllers, err = storage.SnapshotControllers(ctx); err != nil {
+		return err
+	}
+	if len(report.SnapshotControllers) == 0 {
+		report.problem(problemWarning, "no snapshot controller deployment found, snapshots stay pending unless the cluster runs one on its control plane")
+	}
+	for _, c := range report.SnapshotControllers {
+		if c.Ready == 0 {
+			report.problem(problemError, "snapshot controller %s/%s has no ready replica", c.Namespace, c.Name)
+		}
+	}
+
+	drivers, err := storage.CSIDrivers(ctx)
+	if err != nil {
+		return err
+	}
+
+	classes, err := storage.StorageClasses(ctx)
+	if err != nil {
+		return err
+	}
+
+	var snapshotClasses []v3.VolumeSnapshotClass
+	if slices.Contains(api.Resources, "volumesnapshotclasses") {
+		if snapshotClasses, err = storage.VolumeSnapshotClasses(ctx); err != nil {
+			return err
+		}
+	}
+
+	report.Drivers = driverReports(drivers, classes, snapshotClasses)
+	report.StorageClasses = storageClassReports(classes, drivers, snapshotClasses)
+	for _, vsc := range snapshotClasses {
+		report.SnapshotClasses = append(report.SnapshotClasses, snapshotClassReport{
+			Name:           vsc.Name,
+			Driver:         vsc.Driver,
+			DeletionPolicy: string(vsc.DeletionPolicy),
+			Default:        core.IsDefaultSnapshotClass(vsc),
+		})
+	}
+
+	checkClasses(report, snapshotClasses)
+
+	if errs := report.errors(); len(errs) > 0 {
+		return core.Errorf(core.ErrNotReady, "volume snapshots are not supported by the cluster: %s", strings.Join(errs, "; "))
+	}
+
+	a.log.Info("checked cluster", "drivers", len(report.Drivers), "storageClasses", len(report.StorageClasses),
+		"snapshotClasses", len(report.SnapshotClasses), "warnings", len(report.Problems))
+
+	return nil
+}
+
+// driverReports maps the CSI drivers to the classes using them, including drivers only known from a snapshot class.
+// Provisioners of storage classes without a CSIDriver object are not taken for CSI drivers, as external provisioners
+// like local-path are not
+func driverReports(drivers []storagev1.CSIDriver, classes []storagev1.StorageClass, snapshotClasses []v3.VolumeSnapshotClass) []driverReport {
+	byName := map[string]*driverReport{}
+	driver := func(name string) *driverReport {
+		if d, ok := byName[name]; ok {
+			return d
+		}
+
+		d := &driverReport{Name: name}
+		byName[name] = d
+
+		return d
+	}
+
+	for _, d := range drivers {
+		r := driver(d.Name)
+		r.Registered = true
+		r.AttachRequired = d.Spec.AttachRequired == nil || *d.Spec.AttachRequired
+	}
+	for _, vsc := range snapshotClasses {
+		r := driver(vsc.Driver)
+		r.SnapshotClasses = append(r.SnapshotClasses, vsc.Name)
+	}
+	for _, sc := range classes {
+		if r, ok := byName[sc.Provisioner]; ok {
+			r.StorageClasses = append(r.StorageClasses, sc.Name)
+		}
+	}
+
+	reports := make([]driverReport, 0, len(byName))
+	for _, r := range byName {
+		// the default is the class a snapshot without a class name gets, selected like kmon pvc does
+		if len(r.SnapshotClasses) > 0 {
+			r.DefaultSnapshotClass, _ = core.SelectSnapshotClass(snapshotClasses, r.Name)
+		}
+
+		reports = append(reports, *r)
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].Name < reports[j].Name })
+
+	return reports
+}
+
+// storageClassReports tells for every storage class whether its provisioner is a registered CSI driver,
+// which cloning and snapshots need
+func storageClassReports(classes []storagev1.StorageClass, drivers []storagev1.CSIDriver, snapshotClasses []v3.VolumeSnapshotClass) []storageClassReport {
+	registered := map[string]bool{}
+	for _, d := range drivers {
+		registered[d.Name] = true
+	}
+
+	reports := make([]storageClassReport, 0, len(classes))
+	for _, sc := range classes {
+		r := storageClassReport{
+			Name:        sc.Name,
+			Provisioner: sc.Provisioner,
+			Default:     core.IsDefaultStorageClass(sc),
+			Expansion:   sc.AllowVolumeExpansion != nil && *sc.AllowVolumeExpansion,
+			CSI:         registered[sc.Provisioner],
+			Cloning:     cloningNo,
+		}
+		if r.CSI {
+			r.Cloning = cloningUnknown
+			r.SnapshotClass, _ = core.SelectSnapshotClass(snapshotClasses, sc.Provisioner)
+		}
+
+		reports = append(reports, r)
+	}
+
+	return reports
+}
+
+// checkClasses reports the classes which can not be used for snapshots, or are ambiguous defaults
+func checkClasses(report *doctorReport, snapshotClasses []v3.VolumeSnapshotClass) {
+	defaults := 0
+	for _, sc := range report.StorageClasses {
+		if sc.Default {
+			defaults++
+		}
+
+		switch {
+		case strings.HasPrefix(sc.Provisioner, inTreeProvisionerPrefix):
+			report.problem(problemWarning, "storage class %s uses the in-tree provisioner %s, which supports neither snapshots nor cloning", sc.Name, sc.Provisioner)
+		case !sc.CSI:
+			report.problem(problemWarning, "storage class %s uses the provisioner %s, which has no CSIDriver object: "+
+				"unless it is a CSI driver missing its CSIDriver object, it supports neither snapshots nor cloning", sc.Name, sc.Provisioner)
+		default:
+			if _, err := core.SelectSnapshotClass(snapshotClasses, sc.Provisioner); err != nil {
+				report.problem(problemWarning, "pvcs of storage class %s can not be snapshotted without a snapshot class name: %s", sc.Name, err)
+			}
+		}
+	}
+
+	switch {
+	case defaults == 0:
+		report.problem(problemWarning, "no default storage class, pvcs have to name one")
+	case defaults > 1:
+		report.problem(problemWarning, "%d storage classes are marked as default", defaults)
+	}
+
+	for _, d := range report.Drivers {
+		if !d.Registered {
+			report.problem(problemWarning, "csi driver %s is used by a snapshot class but has no CSIDriver object, it may not be installed", d.Name)
+		}
+
+		snapshotDefaults := 0
+		for _, vsc := range report.SnapshotClasses {
+			if vsc.Driver == d.Name && vsc.Default {
+				snapshotDefaults++
+			}
+		}
+		if snapshotDefaults > 1 {
+			report.problem(problemWarning, "csi driver %s has %d default snapshot classes, the snapshot controller rejects snapshots without a class name", d.Name, snapshotDefaults)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/zeljkobenovic/kmon/pkg/kube/core"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// defaultContainerAnnotation names the container kubectl execs into when none is given
+const defaultContainerAnnotation = "kubectl.kubernetes.io/default-container"
+
+// execTarget is the outcome of the command in a container of a pod
+type execTarget struct {
+	Pod       string `json:"pod"`
+	Container string `json:"container"`
+	core.ExecResult
+	Error string `json:"error,omitempty"`
+}
+
+func (t execTarget) String() string {
+	return t.Pod + "/" + t.Container
+}
+
+func (t execTarget) failed() bool {
+	return t.Error != "" || t.ExitCode != 0
+}
+
+// execReport is the outcome of the command in every targeted container
+type execReport struct {
+	Command []string     `json:"command"`
+	Targets []execTarget `json:"targets"`
+}
+
+// printText prints the outputs once for all containers producing them, along with the containers
+func (r execReport) printText(w io.Writer) error {
+	type group struct {
+		targets []string
+		first   execTarget
+	}
+
+	var groups []*group
+	byOutput := map[[4]string]*group{}
+	for _, t := range r.Targets {
+		key := [4]string{t.Stdout, t.Stderr, fmt.Sprint(t.ExitCode), t.Error}
+
+		g, ok := byOutput[key]
+		if !ok {
+			g = &group{first: t}
+			byOutput[key] = g
+			groups = append(groups, g)
+		}
+		g.targets = append(g.targets, t.String())
+	}
+
+	for _, g := range groups {
+		status := fmt.Sprintf("exit code %d", g.first.ExitCode)
+		if g.first.Error != "" {
+			status = "error: " + g.first.Error
+		}
+
+		if _, err := fmt.Fprintf(w, "  %d× %s (%s)\n", len(g.targets), strings.Join(g.targets, ", "), status); err != nil {
+			return err
+		}
+
+		for _, out := range []string{g.first.Stdout, g.first.Stderr} {
+			for _, line := range strings.Split(strings.TrimRight(out, "\n"), "\n") {
+				if line == "" {
+					continue
+				}
+				if _, err := fmt.Fprintf(w, "    %s\n", line); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func (a *App) ExecCmdHandler() error {
+	return a.withContext("exec", a.execSelector)
+}
+
+// execSelector runs the command in the containers of all pods matching the selector, at most Parallel at once,
+// failing if it could not run or exited non-zero in any of them
+func (a *App) execSelector(ctx context.Context) error {
+	conf := a.conf.Exec
+	if conf.Parallel < 1 {
+		return core.Errorf(core.ErrValidationFailed, "parallel must be at least 1, got %d", conf.Parallel)
+	}
+
+	pods, err := a.core.Pod().ListBySelector(ctx, a.conf.Namespace, conf.Selector)
+	if err != nil {
+		return err
+	}
+	if len(pods) == 0 {
+		return core.Errorf(core.ErrNotFound, "no pod matches the selector %s in namespace %s", conf.Selector, a.conf.Namespace)
+	}
+	sort.Slice(pods, func(i, j int) bool { return pods[i].Name < pods[j].Name })
+
+	report := &execReport{Command: conf.Command}
+	a.result.Data = report
+
+	for _, po := range pods {
+		for _, container := range a.execContainers(&po) {
+			t := execTarget{Pod: po.Name, Container: container}
+			if po.Status.Phase != corev1.PodRunning {
+				t.Error = "pod is " + strings.ToLower(string(po.Status.Phase))
+			}
+			report.Targets = append(report.Targets, t)
+		}
+	}
+	if len(report.Targets) == 0 {
+		return core.Errorf(core.ErrNotFound, "no pod matching the selector %s has a container %s", conf.Selector, conf.Container)
+	}
+
+	a.log.Info("running command", "command", conf.Command, "containers", len(report.Targets), "parallel", conf.Parallel)
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, conf.Parallel)
+	for i := range report.Targets {
+		t := &report.Targets[i]
+		if t.Error != "" {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				t.Error = context.Cause(ctx).Error()
+				return
+			}
+			defer func() { <-slots }()
+
+			res, err := a.core.Pod().ExecCapture(ctx, a.conf.Namespace, t.Pod, t.Container, conf.Command)
+			t.ExecResult = res
+			if err != nil {
+				t.Error = err.Error()
+			}
+		}()
+	}
+	wg.Wait()
+
+	var failed []string
+	for _, t := range report.Targets {
+		if t.failed() {
+			failed = append(failed, t.String())
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("command failed in %d of %d containers: %s", len(failed), len(report.Targets), strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+// execContainers returns the containers of the pod to run the command in: all of them, the requested one
+// if the pod has it, or the default one like kubectl picks it
+func (a *App) execContainers(po *corev1.Pod) []string {
+	conf := a.conf.Exec
+
+	var names []string
+	for _, c := range po.Spec.Containers {
+		if conf.AllContainers || conf.Container == c.Name {
+			names = append(names, c.Name)
+		}
+	}
+	if conf.AllContainers || conf.Container != "" {
+		return names
+	}
+
+	if name := po.Annotations[defaultContainerAnnotation]; name != "" {
+		return []string{name}
+	}
+
+	return []string{po.Spec.Containers[0].Name}
+}
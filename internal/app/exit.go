@@ -0,0 +1,50 @@
+package app
+
+import (
+	"context"
+
+	"github.com/zeljkobenovic/kmon/pkg/kube/core"
+)
+
+// Exit codes of kmon, documented in the README, so scripts and CronJobs can react to the kind of failure
+const (
+	// ExitFailure is the exit code of a failure of an unknown kind
+	ExitFailure = 1
+	// ExitValidationFailed is the exit code of invalid flags, arguments or objects, rejected before or by the API server
+	ExitValidationFailed = 2
+	ExitNotFound         = 3
+	// ExitAlreadyExists is also the exit code of an existing object with a different spec
+	ExitAlreadyExists = 4
+	ExitForbidden     = 5
+	ExitTimeout       = 6
+	// ExitNotReady is the exit code of objects which are not ready yet or failed, like a pending snapshot or a crashing pod
+	ExitNotReady = 7
+	// ExitInterrupted is the exit code of a command interrupted by a signal, following the shell convention of 128+SIGINT
+	ExitInterrupted = 130
+)
+
+var exitCodes = map[error]int{
+	core.ErrValidationFailed: ExitValidationFailed,
+	core.ErrNotFound:         ExitNotFound,
+	core.ErrAlreadyExists:    ExitAlreadyExists,
+	core.ErrForbidden:        ExitForbidden,
+	core.ErrTimeout:          ExitTimeout,
+	core.ErrNotReady:         ExitNotReady,
+}
+
+// ExitCode maps the error of a command to the process exit code, ctx being the signal aware application context
+func ExitCode(ctx context.Context, err error) int {
+	if err == nil {
+		return 0
+	}
+
+	if ctx.Err() != nil {
+		return ExitInterrupted
+	}
+
+	if code, ok := exitCodes[core.KindOf(err)]; ok {
+		return code
+	}
+
+	return ExitFailure
+}
@@ -0,0 +1,309 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zeljkobenovic/kmon/pkg/config"
+	"github.com/zeljkobenovic/kmon/pkg/kube/core"
+)
+
+// forwardRefreshInterval is how often the pods are looked up, to replace lost connections and add new pods
+const forwardRefreshInterval = 5 * time.Second
+
+// forwardReport lists the local URLs and the pods connections were forwarded to
+type forwardReport struct {
+	Selector string   `json:"selector"`
+	URLs     []string `json:"urls"`
+	Pods     []string `json:"pods"`
+}
+
+func (r forwardReport) printText(w io.Writer) error {
+	for _, u := range r.URLs {
+		if _, err := fmt.Fprintf(w, "  forwarded %s to pods %s\n", u, strings.Join(r.Pods, ", ")); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// portMapping is a local port forwarded to a port of the pods, the local port 0 being picked by the OS
+type portMapping struct {
+	local  uint16
+	remote uint16
+}
+
+// parsePortMapping parses a port written as remote or local:remote, an empty local port being picked by the OS
+func parsePortMapping(s string) (portMapping, error) {
+	local, remote, found := strings.Cut(s, ":")
+	if !found {
+		local, remote = s, s
+	}
+
+	l, err := strconv.ParseUint(orZero(local), 10, 16)
+	if err != nil {
+		return portMapping{}, core.Errorf(core.ErrValidationFailed, "invalid local port in %q", s)
+	}
+
+	r, err := strconv.ParseUint(remote, 10, 16)
+	if err != nil || r == 0 {
+		return portMapping{}, core.Errorf(core.ErrValidationFailed, "invalid remote port in %q", s)
+	}
+
+	return portMapping{local: uint16(l), remote: uint16(r)}, nil
+}
+
+func orZero(s string) string {
+	if s == "" {
+		return "0"
+	}
+
+	return s
+}
+
+// podBackend is a pod forwarded to through ports bound on the loopback interface, in the order of the port mappings
+type podBackend struct {
+	pod   string
+	ports []core.ForwardedPort
+}
+
+// forwarder proxies the connections accepted on the local ports to the pods matching the selector.
+// Every pod is forwarded to through its own port forwarding, so a lost pod only drops its own connections
+// and is replaced by the next ready pod, while the local ports stay bound
+type forwarder struct {
+	app        *App
+	namespace  string
+	selector   string
+	mappings   []portMapping
+	roundRobin bool
+
+	mu       sync.Mutex
+	running  map[string]bool
+	backends []podBackend
+	next     int
+	used     map[string]bool
+}
+
+func (a *App) PodForwardCmdHandler() error {
+	return a.withContext("pod forward", a.forwardPod)
+}
+
+// forwardPod forwards the local ports to a ready pod matching the selector, or to all of them in turn,
+// until the command gets interrupted
+func (a *App) forwardPod(ctx context.Context) error {
+	conf := a.conf.Pod.Forward
+
+	f := &forwarder{
+		app:        a,
+		namespace:  a.conf.Namespace,
+		selector:   conf.Selector,
+		roundRobin: conf.RoundRobin,
+		running:    map[string]bool{},
+		used:       map[string]bool{},
+	}
+
+	for _, p := range conf.Ports {
+		m, err := parsePortMapping(p)
+		if err != nil {
+			return err
+		}
+		f.mappings = append(f.mappings, m)
+	}
+
+	report := &forwardReport{Selector: conf.Selector}
+	a.result.Data = report
+
+	var listeners []net.Listener
+	defer func() {
+		for _, l := range listeners {
+			_ = l.Close()
+		}
+	}()
+
+	for i, m := range f.mappings {
+		l, err := net.Listen("tcp", net.JoinHostPort(conf.Address, strconv.Itoa(int(m.local))))
+		if err != nil {
+			return fmt.Errorf("could not listen on local port %d: %w", m.local, err)
+		}
+		listeners = append(listeners, l)
+
+		u := "http://" + l.Addr().String()
+		report.URLs = append(report.URLs, u)
+		a.log.Info("forwarding local port", "url", u, "remotePort", m.remote)
+
+		// the URL is needed while forwarding, long before the result gets printed
+		if a.conf.Output == config.OutputText {
+			_, _ = fmt.Fprintf(os.Stdout, "Forwarding from %s -> %d\n", u, m.remote)
+		}
+
+		go f.serve(ctx, l, i)
+	}
+
+	err := f.supervise(ctx)
+
+	f.mu.Lock()
+	for pod := range f.used {
+		report.Pods = append(report.Pods, pod)
+	}
+	f.mu.Unlock()
+	sort.Strings(report.Pods)
+
+	return err
+}
+
+// supervise starts the port forwardings to the ready pods, until ctx is done
+func (f *forwarder) supervise(ctx context.Context) error {
+	ticker := time.NewTicker(forwardRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		pods, err := f.app.core.Pod().ListBySelector(ctx, f.namespace, f.selector)
+		switch {
+		case core.KindOf(err) == core.ErrValidationFailed:
+			return err
+		case err != nil:
+			f.app.log.Warn("could not list pods, retrying", "error", err)
+		}
+
+		ready := 0
+		for i := range pods {
+			if !core.IsReady(&pods[i]) {
+				continue
+			}
+			ready++
+
+			if f.start(ctx, pods[i].Name) && !f.roundRobin {
+				break
+			}
+		}
+		if err == nil && ready == 0 {
+			f.app.log.Warn("no ready pod matches the selector, waiting", "selector", f.selector)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// start starts forwarding to the pod, unless it is forwarded to already or, without round robin, another pod is.
+// It reports whether the pod is forwarded to
+func (f *forwarder) start(ctx context.Context, pod string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.running[pod] {
+		return true
+	}
+	if !f.roundRobin && len(f.running) > 0 {
+		return false
+	}
+	f.running[pod] = true
+
+	ports := make([]string, 0, len(f.mappings))
+	for _, m := range f.mappings {
+		ports = append(ports, fmt.Sprintf(":%d", m.remote))
+	}
+
+	go func() {
+		err := f.app.core.Pod().Forward(ctx, f.namespace, pod, []string{"127.0.0.1"}, ports, func(bound []core.ForwardedPort) {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+
+			f.backends = append(f.backends, podBackend{pod: pod, ports: bound})
+			f.used[pod] = true
+			f.app.log.Info("connected to pod", "pod", pod)
+		})
+		if ctx.Err() == nil {
+			f.app.log.Warn("lost port forwarding, reconnecting", "pod", pod, "error", err)
+		}
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		delete(f.running, pod)
+		for i, b := range f.backends {
+			if b.pod == pod {
+				f.backends = append(f.backends[:i], f.backends[i+1:]...)
+				break
+			}
+		}
+	}()
+
+	return true
+}
+
+// backend returns the next pod to forward a connection to in turn, or false if none is connected
+func (f *forwarder) backend() (podBackend, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.backends) == 0 {
+		return podBackend{}, false
+	}
+
+	b := f.backends[f.next%len(f.backends)]
+	f.next++
+
+	return b, true
+}
+
+// serve accepts the connections on the local port of the port mapping i, until the listener gets closed
+func (f *forwarder) serve(ctx context.Context, l net.Listener, i int) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() == nil && !errors.Is(err, net.ErrClosed) {
+				f.app.log.Warn("could not accept connection", "error", err)
+			}
+			return
+		}
+
+		go f.proxy(conn, i)
+	}
+}
+
+// proxy copies the connection to and from the port of the next pod
+func (f *forwarder) proxy(conn net.Conn, i int) {
+	defer conn.Close()
+
+	b, ok := f.backend()
+	if !ok {
+		f.app.log.Warn("dropping connection, no pod is connected yet", "selector", f.selector)
+		return
+	}
+
+	upstream, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(int(b.ports[i].Local))))
+	if err != nil {
+		f.app.log.Warn("could not connect to forwarded port", "pod", b.pod, "error", err)
+		return
+	}
+	defer upstream.Close()
+
+	f.app.log.Debug("forwarding connection", "pod", b.pod, "remotePort", b.ports[i].Remote)
+
+	done := make(chan struct{}, 2)
+	go func() {
+		_, _ = io.Copy(upstream, conn)
+		done <- struct{}{}
+	}()
+	go func() {
+		_, _ = io.Copy(conn, upstream)
+		done <- struct{}{}
+	}()
+
+	// either side closing ends the connection, the deferred closes unblock the other copy
+	<-done
+}
@@ -0,0 +1,137 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/zeljkobenovic/kmon/pkg/kube/core"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// inspectMountPath is where the inspected volume is mounted within the inspection pod
+	inspectMountPath = "/mnt/kmon"
+	// inspectVolumeName is the name of the inspected volume within the inspection pod
+	inspectVolumeName = "kmon-inspect"
+	// inspectReadyTimeoutSec allows for the volume to be provisioned, e.g. restored from a snapshot
+	inspectReadyTimeoutSec = 180
+	// maxNamePrefixLength leaves room for the suffix generated by the API server, within the 63 characters limit
+	maxNamePrefixLength = 52
+)
+
+// kubeName joins the parts into a name short enough to be used as a name or a generateName prefix
+func kubeName(parts ...string) string {
+	name := strings.Join(parts, "-")
+	if len(name) > maxNamePrefixLength {
+		name = name[:maxNamePrefixLength]
+	}
+
+	return strings.TrimRight(name, "-.")
+}
+
+// inspectVolume is a pvc mounted into the inspection pod
+type inspectVolume struct {
+	// pvcName is resolved when the step runs, so it can be set by one of the previous steps
+	pvcName   *string
+	mountPath string
+	readOnly  bool
+}
+
+// inspectionPodStep runs a temporary pod with the pvc pvcName points to mounted at inspectMountPath.
+// The name of the pod is stored in podName
+func (a *App) inspectionPodStep(pvcName *string, podName *string) step {
+	return a.multiInspectionPodStep(podName, inspectVolume{pvcName: pvcName, mountPath: inspectMountPath})
+}
+
+// multiInspectionPodStep runs a temporary pod with all the volumes mounted, named with a generated suffix so concurrent
+// runs on the same pvcs never share a pod one of them deletes once done.
+// The pod is scheduled next to an existing consumer of the pvcs, so ReadWriteOnce volumes can be attached.
+// The generated name of the pod is stored in podName
+func (a *App) multiInspectionPodStep(podName *string, volumes ...inspectVolume) step {
+	var nodeName string
+
+	s := a.podStep("run inspection pod", podName, inspectReadyTimeoutSec, func() []core.PodOptions {
+		opts := []core.PodOptions{
+			core.WithLabels(map[string]string{core.ManagedByLabel: core.ManagedBy}),
+			core.WithGenerateName(),
+		}
+		for i, vol := range volumes {
+			opts = append(opts, core.WithVolume(fmt.Sprintf("%s-%d", inspectVolumeName, i), vol.mountPath, *vol.pvcName, vol.readOnly))
+		}
+		if nodeName != "" {
+			opts = append(opts, core.WithNodeName(nodeName))
+		}
+
+		return opts
+	})
+	s.temporary = true
+
+	do := s.do
+	s.do = func(ctx context.Context) error {
+		pvcNames := make([]string, 0, len(volumes))
+		for _, vol := range volumes {
+			pvcNames = append(pvcNames, *vol.pvcName)
+		}
+
+		if *podName == "" {
+			*podName = kubeName(append([]string{"kmon-inspect"}, pvcNames...)...)
+		}
+
+		for _, pvcName := range pvcNames {
+			consumers, err := a.core.Pod().ListByPVC(ctx, a.conf.Namespace, pvcName)
+			if err != nil {
+				return err
+			}
+
+			for _, po := range consumers {
+				if po.Spec.NodeName != "" && po.Status.Phase == corev1.PodRunning {
+					nodeName = po.Spec.NodeName
+					a.log.Info("scheduling inspection pod next to pvc consumer", "pod", po.Name, "node", nodeName)
+
+					return do(ctx)
+				}
+			}
+		}
+
+		return do(ctx)
+	}
+
+	return s
+}
+
+// restoreSnapshotStep restores the snapshot into a temporary pvc, deleted once the workflow completes,
+// and stores the name of the pvc in pvcName
+func (a *App) restoreSnapshotStep(snapshotName string, pvcName *string) step {
+	return step{
+		name:      "restore snapshot " + snapshotName,
+		temporary: true,
+		do: func(ctx context.Context) error {
+			pvc, _, err := a.core.PVC().CreateFromSnapshot(
+				ctx,
+				a.conf.Namespace,
+				kubeName("kmon-restore", snapshotName),
+				snapshotName,
+				core.WithPVCGenerateName(),
+			)
+			if err != nil {
+				return fmt.Errorf("failed to restore snapshot: %w", err)
+			}
+
+			*pvcName = pvc.Name
+			a.log.Info("snapshot restored", "snapshot", snapshotName, "pvc", pvc.Name)
+			a.result.addResource("pvc", pvc.Namespace, pvc.Name, resourceCreated)
+
+			return nil
+		},
+		undo: func(ctx context.Context) error {
+			return a.core.PVC().Delete(ctx, a.conf.Namespace, *pvcName)
+		},
+	}
+}
+
+// inspectPath returns the path within the inspection pod for the path within the volume
+func inspectPath(volumePath string) string {
+	return path.Join(inspectMountPath, path.Clean("/"+volumePath))
+}
@@ -0,0 +1,196 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/zeljkobenovic/kmon/pkg/k9s"
+	"golang.org/x/term"
+)
+
+// k9sAncestorDepth bounds the search for k9s among the parent processes, which start plugins through a shell
+const k9sAncestorDepth = 5
+
+// k9sInstallReport is the outcome of the k9s install command
+type k9sInstallReport struct {
+	File      string         `json:"file"`
+	Installed []string       `json:"installed"`
+	Removed   []string       `json:"removed,omitempty"`
+	Conflicts []k9s.Conflict `json:"conflicts,omitempty"`
+}
+
+func (r k9sInstallReport) printText(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "  installed %d plugins into %s\n", len(r.Installed), r.File); err != nil {
+		return err
+	}
+
+	for _, name := range r.Removed {
+		if _, err := fmt.Fprintf(w, "  removed stale plugin %s\n", name); err != nil {
+			return err
+		}
+	}
+
+	for _, c := range r.Conflicts {
+		if _, err := fmt.Fprintf(w, "  skipped %s\n", c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (a *App) K9sInstallCmdHandler() error {
+	plugins, err := a.k9sPlugins()
+	if err != nil {
+		return err
+	}
+
+	// the plugins are printed on their own, so they can be redirected into a file
+	if a.conf.K9s.Install.Print {
+		data, err := k9s.Marshal(plugins)
+		if err != nil {
+			return err
+		}
+
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+
+	return a.withContext("k9s install", func(_ context.Context) error {
+		return a.installK9sPlugins(plugins)
+	})
+}
+
+// k9sPlugins generates the plugins running this kmon executable, by name if it is the one found in the PATH
+func (a *App) k9sPlugins() (map[string]k9s.Plugin, error) {
+	kmon, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("could not find the kmon executable: %w", err)
+	}
+
+	if found, err := exec.LookPath("kmon"); err == nil && samePath(found, kmon) {
+		kmon = "kmon"
+	}
+
+	return k9s.Generate(kmon, k9s.DetectShell(a.conf.K9s.Install.Shell, os.Getenv("SHELL")), "--k9s"), nil
+}
+
+func samePath(a, b string) bool {
+	a, errA := filepath.EvalSymlinks(a)
+	b, errB := filepath.EvalSymlinks(b)
+
+	return errA == nil && errB == nil && a == b
+}
+
+// installK9sPlugins merges the plugins into the k9s plugins file, skipping the ones conflicting with plugins of other tools
+func (a *App) installK9sPlugins(plugins map[string]k9s.Plugin) error {
+	path := a.conf.K9s.Install.File
+	if path == "" {
+		var err error
+		if path, err = k9s.PluginsFilePath(); err != nil {
+			return err
+		}
+	}
+
+	file, err := k9s.ReadPluginsFile(path)
+	if err != nil {
+		return err
+	}
+
+	report := k9sInstallReport{File: path, Conflicts: k9s.Conflicts(file.Plugins(), plugins)}
+	a.result.Data = &report
+
+	conflicting := map[string]bool{}
+	for _, c := range report.Conflicts {
+		conflicting[c.Plugin] = true
+		a.log.Warn("skipping conflicting k9s plugin", "plugin", c.Plugin, "conflictsWith", c.With, "shortCut", c.ShortCut)
+	}
+
+	installed := map[string]k9s.Plugin{}
+	for name, p := range plugins {
+		if conflicting[name] {
+			continue
+		}
+
+		file.Set(name, p)
+		installed[name] = p
+		report.Installed = append(report.Installed, name)
+	}
+	sort.Strings(report.Installed)
+
+	// a conflicting plugin installed before is removed too, so the shortcut is left to the other tool
+	report.Removed = file.Prune(installed)
+
+	if err := file.Write(path); err != nil {
+		return err
+	}
+
+	a.log.Info("installed k9s plugins", "file", path, "count", len(report.Installed), "conflicts", len(report.Conflicts))
+
+	return nil
+}
+
+// PauseInK9s waits for a keypress when started by k9s, so errors raised before the command runs can be read
+func PauseInK9s() {
+	if runByK9s() {
+		waitForKey()
+	}
+}
+
+// runByK9s tells whether k9s is one of the parent processes. Processes are only inspected on Linux, elsewhere
+// the --k9s flag has to be set
+func runByK9s() bool {
+	pid := os.Getppid()
+	for i := 0; i < k9sAncestorDepth && pid > 1; i++ {
+		stat, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+		if err != nil {
+			return false
+		}
+
+		// the stat line reads "pid (comm) state ppid ...", where comm may contain spaces and parentheses
+		line := string(stat)
+		start, end := strings.IndexByte(line, '('), strings.LastIndexByte(line, ')')
+		if start < 0 || end < start {
+			return false
+		}
+		if line[start+1:end] == "k9s" {
+			return true
+		}
+
+		fields := strings.Fields(line[end+1:])
+		if len(fields) < 2 {
+			return false
+		}
+		if pid, err = strconv.Atoi(fields[1]); err != nil {
+			return false
+		}
+	}
+
+	return false
+}
+
+// waitForKey blocks until a key is pressed, or returns right away if stdin is not a terminal
+func waitForKey() {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return
+	}
+
+	_, _ = fmt.Fprint(os.Stderr, "press any key to return to k9s")
+	defer fmt.Fprintln(os.Stderr)
+
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return
+	}
+	defer func() { _ = term.Restore(fd, state) }()
+
+	_, _ = os.Stdin.Read(make([]byte, 1))
+}
@@ -0,0 +1,272 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zeljkobenovic/kmon/pkg/config"
+)
+
+// FileEntry is a single file within a volume listing
+type FileEntry struct {
+	Path    string    `json:"path"`
+	Type    string    `json:"type"`
+	Size    int64     `json:"size"`
+	Mode    string    `json:"mode"`
+	ModTime time.Time `json:"mtime"`
+}
+
+// listing is the outcome of the pvc ls and snapshot ls commands
+type listing struct {
+	Source  string      `json:"source"`
+	Path    string      `json:"path"`
+	Entries []FileEntry `json:"entries"`
+}
+
+func (l listing) printText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	if _, err := fmt.Fprintln(tw, "MODE\tSIZE\tMODIFIED\tNAME"); err != nil {
+		return err
+	}
+
+	for _, e := range l.Entries {
+		name := e.Path
+		if e.Type == fileTypeDir {
+			name += "/"
+		}
+
+		if _, err := fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", e.Mode, humanBytes(e.Size), e.ModTime.Format(time.DateTime), name); err != nil {
+			return err
+		}
+	}
+
+	return tw.Flush()
+}
+
+const (
+	fileTypeFile    = "file"
+	fileTypeDir     = "dir"
+	fileTypeSymlink = "symlink"
+	fileTypeOther   = "other"
+)
+
+func (a *App) PVCListCmdHandler() error {
+	return a.withContext("pvc ls", func(ctx context.Context) error {
+		return a.listVolume(ctx, a.conf.PVC.List, false)
+	})
+}
+
+func (a *App) SnapshotListCmdHandler() error {
+	return a.withContext("snapshot ls", func(ctx context.Context) error {
+		return a.listVolume(ctx, a.conf.Snapshot.List, true)
+	})
+}
+
+// listVolume lists the content of a pvc, or of a snapshot restored into a temporary pvc, from within an inspection pod
+func (a *App) listVolume(ctx context.Context, list config.List, fromSnapshot bool) error {
+	var pvcName, podName string
+
+	wf := a.newWorkflow("ls")
+	if fromSnapshot {
+		wf.Step(a.restoreSnapshotStep(list.Target, &pvcName))
+	} else {
+		pvcName = list.Target
+	}
+
+	return wf.
+		Step(a.multiInspectionPodStep(&podName, inspectVolume{pvcName: &pvcName, mountPath: inspectMountPath, readOnly: true})).
+		Step(step{
+			name: "list files",
+			do: func(ctx context.Context) error {
+				entries, err := a.listFiles(ctx, podName, inspectPath(list.Path), list.Recursive || list.DiskUsage)
+				if err != nil {
+					return err
+				}
+
+				if list.DiskUsage {
+					entries = diskUsage(entries, list.Recursive)
+				}
+
+				a.result.Data = listing{Source: list.Target, Path: path.Clean("/" + list.Path), Entries: entries}
+
+				return nil
+			},
+		}).
+		Run(ctx)
+}
+
+// listFiles runs find and stat within the inspection pod and parses their output.
+// Paths of the returned entries are relative to root, the listed path within the pod
+func (a *App) listFiles(ctx context.Context, podName, root string, recursive bool) ([]FileEntry, error) {
+	cmd := []string{"find", root}
+	if !recursive {
+		cmd = append(cmd, "-maxdepth", "1")
+	}
+	cmd = append(cmd, "-exec", "stat", "-c", "%s\t%f\t%Y\t%n", "{}", "+")
+
+	var stdout, stderr bytes.Buffer
+	if err := a.core.Pod().ExecStream(ctx, a.conf.Namespace, podName, cmd, nil, &stdout, &stderr); err != nil {
+		return nil, remoteError(err, &stderr)
+	}
+
+	return parseStatOutput(&stdout, root)
+}
+
+// parseStatOutput parses the "size\tmode\tmtime\tname" lines printed by stat. The root entry itself
+// is only included if it is not a directory, so listing a single file returns that file
+func parseStatOutput(r io.Reader, root string) ([]FileEntry, error) {
+	out, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []FileEntry
+	for _, line := range strings.Split(strings.TrimRight(string(out), "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+
+		fields := strings.SplitN(line, "\t", 4)
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("unexpected stat output: %q", line)
+		}
+
+		size, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid size in %q: %w", line, err)
+		}
+
+		rawMode, err := strconv.ParseUint(fields[1], 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mode in %q: %w", line, err)
+		}
+
+		mtime, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mtime in %q: %w", line, err)
+		}
+
+		mode := unixMode(uint32(rawMode))
+
+		rel := strings.TrimPrefix(strings.TrimPrefix(fields[3], root), "/")
+		if rel == "" {
+			if mode.IsDir() {
+				continue
+			}
+
+			rel = path.Base(fields[3])
+		}
+
+		entries = append(entries, FileEntry{
+			Path:    rel,
+			Type:    fileType(mode),
+			Size:    size,
+			Mode:    mode.String(),
+			ModTime: time.Unix(mtime, 0).UTC(),
+		})
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+
+	return entries, nil
+}
+
+// diskUsage sets the size of every directory to the total size of the files within it.
+// Unless recursive is set, only the top level entries are kept
+func diskUsage(entries []FileEntry, recursive bool) []FileEntry {
+	totals := map[string]int64{}
+	for _, e := range entries {
+		if e.Type == fileTypeDir {
+			continue
+		}
+
+		for dir := path.Dir(e.Path); dir != "."; dir = path.Dir(dir) {
+			totals[dir] += e.Size
+		}
+	}
+
+	var out []FileEntry
+	for _, e := range entries {
+		if !recursive && strings.Contains(e.Path, "/") {
+			continue
+		}
+
+		if e.Type == fileTypeDir {
+			e.Size = totals[e.Path]
+		}
+
+		out = append(out, e)
+	}
+
+	return out
+}
+
+// unixMode converts the raw st_mode printed by stat into a fs.FileMode
+func unixMode(raw uint32) fs.FileMode {
+	mode := fs.FileMode(raw & 0o777)
+
+	switch raw & 0o170000 {
+	case 0o040000:
+		mode |= fs.ModeDir
+	case 0o120000:
+		mode |= fs.ModeSymlink
+	case 0o010000:
+		mode |= fs.ModeNamedPipe
+	case 0o140000:
+		mode |= fs.ModeSocket
+	case 0o020000:
+		mode |= fs.ModeDevice | fs.ModeCharDevice
+	case 0o060000:
+		mode |= fs.ModeDevice
+	}
+
+	if raw&0o4000 != 0 {
+		mode |= fs.ModeSetuid
+	}
+	if raw&0o2000 != 0 {
+		mode |= fs.ModeSetgid
+	}
+	if raw&0o1000 != 0 {
+		mode |= fs.ModeSticky
+	}
+
+	return mode
+}
+
+func fileType(mode fs.FileMode) string {
+	switch {
+	case mode.IsDir():
+		return fileTypeDir
+	case mode.IsRegular():
+		return fileTypeFile
+	case mode&fs.ModeSymlink != 0:
+		return fileTypeSymlink
+	default:
+		return fileTypeOther
+	}
+}
+
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f%ci", float64(n)/float64(div), "KMGTPE"[exp])
+}
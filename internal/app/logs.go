@@ -0,0 +1,384 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/zeljkobenovic/kmon/pkg/kube/core"
+	"golang.org/x/term"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// maxLogLineSize bounds the length of a single log line, longer lines end the stream of their container
+	maxLogLineSize = 1024 * 1024
+	// bundleEventLimit bounds the events written to the support bundle for every pod
+	bundleEventLimit = 50
+)
+
+// logColors are the ANSI colors of the pod prefixes, assigned to the pods in turn
+var logColors = []string{"\x1b[36m", "\x1b[33m", "\x1b[32m", "\x1b[35m", "\x1b[34m", "\x1b[31m"}
+
+const colorReset = "\x1b[0m"
+
+// logsContainer is the number of log lines written for a container, into File for a support bundle
+type logsContainer struct {
+	Pod       string `json:"pod"`
+	Container string `json:"container"`
+	Lines     int    `json:"lines"`
+	File      string `json:"file,omitempty"`
+}
+
+type logsReport struct {
+	Selector   string          `json:"selector"`
+	Dir        string          `json:"dir,omitempty"`
+	Containers []logsContainer `json:"containers"`
+}
+
+func (r logsReport) printText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	if _, err := fmt.Fprintln(tw, "POD\tCONTAINER\tLINES\tFILE"); err != nil {
+		return err
+	}
+
+	for _, c := range r.Containers {
+		if _, err := fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", c.Pod, c.Container, c.Lines, orDash(c.File)); err != nil {
+			return err
+		}
+	}
+
+	return tw.Flush()
+}
+
+// logFilter keeps the lines matching the grep expression and, for JSON lines, the field values
+type logFilter struct {
+	grep   *regexp.Regexp
+	fields map[string]string
+}
+
+func newLogFilter(grep string, fields []string) (*logFilter, error) {
+	f := &logFilter{fields: map[string]string{}}
+
+	if grep != "" {
+		var err error
+		if f.grep, err = regexp.Compile(grep); err != nil {
+			return nil, core.Errorf(core.ErrValidationFailed, "invalid grep expression: %w", err)
+		}
+	}
+
+	for _, field := range fields {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok || key == "" {
+			return nil, core.Errorf(core.ErrValidationFailed, "invalid field filter %q, expected key=value", field)
+		}
+		f.fields[key] = value
+	}
+
+	return f, nil
+}
+
+// match reports whether the line is kept. Lines which are not JSON objects are dropped when filtering on fields
+func (f *logFilter) match(line string) bool {
+	if f.grep != nil && !f.grep.MatchString(line) {
+		return false
+	}
+	if len(f.fields) == 0 {
+		return true
+	}
+
+	var obj map[string]any
+	if err := json.Unmarshal([]byte(line), &obj); err != nil {
+		return false
+	}
+
+	for key, want := range f.fields {
+		value, ok := jsonField(obj, key)
+		if !ok || fmt.Sprint(value) != want {
+			return false
+		}
+	}
+
+	return true
+}
+
+// jsonField looks up a field by its dotted path, e.g. http.status
+func jsonField(obj map[string]any, path string) (any, bool) {
+	var value any = obj
+	for _, key := range strings.Split(path, ".") {
+		m, ok := value.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if value, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+
+	return value, true
+}
+
+// logStreamer streams the logs of the containers of the pods matching the selector, writing them prefixed
+// to stdout, or into one file per container for a support bundle
+type logStreamer struct {
+	app    *App
+	filter *logFilter
+	color  bool
+
+	wg sync.WaitGroup
+	mu sync.Mutex
+	// streamed holds the id of the last container streamed for every pod/container, so restarted containers
+	// are streamed again while modifications of the pod are not
+	streamed   map[string]string
+	colors     map[string]string
+	containers map[string]*logsContainer
+}
+
+func (a *App) LogsCmdHandler() error {
+	return a.withContext("logs", a.streamLogs)
+}
+
+// streamLogs streams the logs of all pods matching the selector, and with Follow of the pods appearing later,
+// until ctx is done. Streamed to stdout, the lines are the only output and the summary is logged,
+// while a support bundle is reported like the outcome of other commands
+func (a *App) streamLogs(ctx context.Context) error {
+	conf := a.conf.Logs
+
+	filter, err := newLogFilter(conf.Grep, conf.Fields)
+	if err != nil {
+		return err
+	}
+
+	s := &logStreamer{
+		app:        a,
+		filter:     filter,
+		color:      conf.Dir == "" && term.IsTerminal(int(os.Stdout.Fd())),
+		streamed:   map[string]string{},
+		colors:     map[string]string{},
+		containers: map[string]*logsContainer{},
+	}
+
+	report := &logsReport{Selector: conf.Selector, Dir: conf.Dir}
+	if conf.Dir != "" {
+		a.result.Data = report
+	} else {
+		a.result.streamed = true
+	}
+	defer func() {
+		report.Containers = s.report()
+		if conf.Dir == "" {
+			for _, c := range report.Containers {
+				a.log.Info("streamed logs", "pod", c.Pod, "container", c.Container, "lines", c.Lines)
+			}
+		}
+	}()
+
+	if conf.Dir != "" {
+		if err := os.MkdirAll(conf.Dir, 0o755); err != nil {
+			return fmt.Errorf("could not create support bundle dir: %w", err)
+		}
+	}
+
+	if conf.Follow {
+		err = a.core.Pod().WatchBySelector(ctx, a.conf.Namespace, conf.Selector, func(event watch.EventType, po *corev1.Pod) {
+			if event != watch.Deleted {
+				s.start(ctx, po)
+			}
+		})
+		s.wg.Wait()
+
+		return err
+	}
+
+	pods, err := a.core.Pod().ListBySelector(ctx, a.conf.Namespace, conf.Selector)
+	if err != nil {
+		return err
+	}
+	if len(pods) == 0 {
+		return core.Errorf(core.ErrNotFound, "no pod matches the selector %s in namespace %s", conf.Selector, a.conf.Namespace)
+	}
+
+	for i := range pods {
+		s.start(ctx, &pods[i])
+	}
+	s.wg.Wait()
+
+	return nil
+}
+
+// start streams the logs of the containers of the pod which were not streamed yet. While following,
+// containers waiting to start are left to a later modification of the pod
+func (s *logStreamer) start(ctx context.Context, po *corev1.Pod) {
+	conf := s.app.conf.Logs
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.colors[po.Name]; !ok {
+		s.colors[po.Name] = logColors[len(s.colors)%len(logColors)]
+
+		if conf.Dir != "" {
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				s.writePodFiles(ctx, po.DeepCopy())
+			}()
+		}
+	}
+
+	color := s.colors[po.Name]
+	for _, cs := range slices.Concat(po.Status.InitContainerStatuses, po.Status.ContainerStatuses) {
+		key := po.Name + "/" + cs.Name
+		if cs.ContainerID == "" || s.streamed[key] == cs.ContainerID {
+			continue
+		}
+		if conf.Follow && cs.State.Running == nil && cs.State.Terminated == nil {
+			continue
+		}
+		s.streamed[key] = cs.ContainerID
+
+		c, ok := s.containers[key]
+		if !ok {
+			c = &logsContainer{Pod: po.Name, Container: cs.Name}
+			s.containers[key] = c
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.stream(ctx, c, color)
+		}()
+	}
+}
+
+// stream copies the logs of the container, to its file for a support bundle or prefixed to stdout
+func (s *logStreamer) stream(ctx context.Context, c *logsContainer, color string) {
+	conf := s.app.conf.Logs
+
+	logs, err := s.app.core.Pod().Logs(ctx, s.app.conf.Namespace, c.Pod, core.LogOptions{
+		Container: c.Container,
+		Follow:    conf.Follow,
+		Since:     conf.Since,
+	})
+	if err != nil {
+		if ctx.Err() == nil {
+			s.app.log.Warn("could not stream logs", "pod", c.Pod, "container", c.Container, "error", err)
+		}
+		return
+	}
+	defer logs.Close()
+
+	out, prefix := io.Writer(os.Stdout), "["+c.Pod+"/"+c.Container+"] "
+	if s.color {
+		prefix = color + prefix + colorReset
+	}
+
+	if conf.Dir != "" {
+		file := filepath.Join(conf.Dir, c.Pod, c.Container+".log")
+		s.mu.Lock()
+		c.File = file
+		s.mu.Unlock()
+
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			s.app.log.Warn("could not create support bundle dir", "error", err)
+			return
+		}
+
+		// a restarted container is appended to the logs of its previous instance
+		f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			s.app.log.Warn("could not create log file", "error", err)
+			return
+		}
+		defer f.Close()
+
+		out, prefix = f, ""
+	}
+
+	scanner := bufio.NewScanner(logs)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLogLineSize)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !s.filter.match(line) {
+			continue
+		}
+
+		s.mu.Lock()
+		_, err := fmt.Fprintln(out, prefix+line)
+		c.Lines++
+		s.mu.Unlock()
+
+		if err != nil {
+			s.app.log.Warn("could not write logs", "pod", c.Pod, "container", c.Container, "error", err)
+			return
+		}
+	}
+
+	if err := scanner.Err(); err != nil && ctx.Err() == nil {
+		s.app.log.Warn("log stream ended", "pod", c.Pod, "container", c.Container, "error", err)
+	}
+}
+
+// writePodFiles writes the manifest and the latest events of the pod next to its logs in the support bundle
+func (s *logStreamer) writePodFiles(ctx context.Context, po *corev1.Pod) {
+	dir := filepath.Join(s.app.conf.Logs.Dir, po.Name)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		s.app.log.Warn("could not create support bundle dir", "error", err)
+		return
+	}
+
+	po.ManagedFields = nil
+	manifest, err := yaml.Marshal(po)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(dir, "pod.yaml"), manifest, 0o644)
+	}
+	if err != nil {
+		s.app.log.Warn("could not write pod manifest", "pod", po.Name, "error", err)
+	}
+
+	events, err := s.app.core.Events().Recent(ctx, po.Namespace, po.UID, bundleEventLimit)
+	if err != nil {
+		s.app.log.Warn("could not list pod events", "pod", po.Name, "error", err)
+		return
+	}
+
+	var b strings.Builder
+	for _, e := range events {
+		fmt.Fprintf(&b, "%s %s %s: %s\n", e.Time.Format("2006-01-02T15:04:05Z07:00"), e.Type, e.Reason, e.Message)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "events.log"), []byte(b.String()), 0o644); err != nil {
+		s.app.log.Warn("could not write pod events", "pod", po.Name, "error", err)
+	}
+}
+
+// report returns the streamed containers sorted by pod and container
+func (s *logStreamer) report() []logsContainer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	containers := make([]logsContainer, 0, len(s.containers))
+	for _, c := range s.containers {
+		containers = append(containers, *c)
+	}
+	sort.Slice(containers, func(i, j int) bool {
+		if containers[i].Pod != containers[j].Pod {
+			return containers[i].Pod < containers[j].Pod
+		}
+		return containers[i].Container < containers[j].Container
+	})
+
+	return containers
+}
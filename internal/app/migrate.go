@@ -0,0 +1,324 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/zeljkobenovic/kmon/pkg/kube/core"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+const (
+	migrateSourceMountPath = inspectMountPath + "/source"
+	migrateTargetMountPath = inspectMountPath + "/target"
+	// migrateWaitTimeoutSec bounds waiting for consumers to stop and pvcs to be deleted or bound
+	migrateWaitTimeoutSec = 300
+	// migrateRollbackTimeout covers the waits of the undo actions, deleting the new pvc and rebinding the old volume,
+	// plus the default budget for the others
+	migrateRollbackTimeout = 3*migrateWaitTimeoutSec*time.Second + core.CleanupTimeout
+)
+
+// migrationReport is the outcome of the pvc migrate command
+type migrationReport struct {
+	PVC              string          `json:"pvc"`
+	FromStorageClass string          `json:"fromStorageClass"`
+	ToStorageClass   string          `json:"toStorageClass"`
+	OldVolume        string          `json:"oldVolume"`
+	NewVolume        string          `json:"newVolume"`
+	Consumers        []core.Workload `json:"consumers"`
+}
+
+func (r migrationReport) printText(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "  pvc %s moved from storage class %s to %s\n  new volume: %s\n  old volume: %s (retained)\n",
+		r.PVC, r.FromStorageClass, r.ToStorageClass, r.NewVolume, r.OldVolume); err != nil {
+		return err
+	}
+
+	for _, c := range r.Consumers {
+		if _, err := fmt.Fprintf(w, "  restarted %s with %d replicas\n", c, c.Replicas); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (a *App) PVCMigrateCmdHandler() error {
+	return a.withContext("pvc migrate", a.migratePVC)
+}
+
+// migratePVC moves the pvc to another storage class: the consumers are scaled down, the data is copied into a new pvc,
+// and the new volume is bound under the original pvc name before the consumers are scaled back up.
+// Both volumes are retained while the names are swapped, so every step can be rolled back onto the old volume
+func (a *App) migratePVC(ctx context.Context) error {
+	conf := a.conf.PVC.Migrate
+	ns := a.conf.Namespace
+
+	var (
+		source               *corev1.PersistentVolumeClaim
+		targetPVC, copyPod   string
+		oldPolicy, newPolicy corev1.PersistentVolumeReclaimPolicy
+		report               = migrationReport{PVC: conf.PVCName, ToStorageClass: conf.ToStorageClass}
+	)
+
+	targetPVC = kubeName(conf.PVCName, "migrated")
+
+	// applied when a pvc is created, once the source pvc has been inspected
+	likeSource := func(pvc *corev1.PersistentVolumeClaim) {
+		size := source.Spec.Resources.Requests[corev1.ResourceStorage]
+		if capacity, ok := source.Status.Capacity[corev1.ResourceStorage]; ok && capacity.Cmp(size) > 0 {
+			size = capacity
+		}
+
+		core.WithStorageSize(size)(pvc)
+		core.WithAccessModes(source.Spec.AccessModes...)(pvc)
+	}
+
+	return a.newWorkflow("pvc migrate").
+		RollbackTimeout(migrateRollbackTimeout).
+		Step(step{
+			name: "inspect pvc",
+			do: func(ctx context.Context) error {
+				var err error
+				if source, err = a.core.PVC().Get(ctx, ns, conf.PVCName); err != nil {
+					return err
+				}
+
+				if source.Status.Phase != corev1.ClaimBound {
+					return core.Errorf(core.ErrNotReady, "pvc %s is %s, only bound pvcs can be migrated", conf.PVCName, source.Status.Phase)
+				}
+				if source.Spec.VolumeMode != nil && *source.Spec.VolumeMode == corev1.PersistentVolumeBlock {
+					return core.Errorf(core.ErrValidationFailed, "pvc %s is a block volume, only filesystem volumes can be migrated", conf.PVCName)
+				}
+				if source.Spec.StorageClassName != nil {
+					report.FromStorageClass = *source.Spec.StorageClassName
+				}
+				if report.FromStorageClass == conf.ToStorageClass {
+					return core.Errorf(core.ErrValidationFailed, "pvc %s already uses storage class %s", conf.PVCName, conf.ToStorageClass)
+				}
+
+				report.OldVolume = source.Spec.VolumeName
+				report.Consumers, err = a.pvcConsumers(ctx, conf.PVCName)
+
+				return err
+			},
+		}).
+		Step(a.scaleDownStep(conf.PVCName, &report.Consumers)).
+		Step(a.pvcStep("create target pvc", &targetPVC, likeSource, core.WithStorageClassName(conf.ToStorageClass))).
+		Step(a.multiInspectionPodStep(&copyPod,
+			inspectVolume{pvcName: &conf.PVCName, mountPath: migrateSourceMountPath, readOnly: true},
+			inspectVolume{pvcName: &targetPVC, mountPath: migrateTargetMountPath},
+		)).
+		Step(step{
+			name: "copy data",
+			do: func(ctx context.Context) error {
+				var stderr bytes.Buffer
+				err := a.core.Pod().ExecStream(ctx, ns, copyPod, []string{
+					"sh", "-c", `if command -v rsync >/dev/null; then rsync -aH --numeric-ids --delete "$1"/ "$2"/; else cp -a "$1"/. "$2"/; fi`,
+					"sh", migrateSourceMountPath, migrateTargetMountPath,
+				}, nil, nil, &stderr)
+				if err != nil {
+					return remoteError(err, &stderr)
+				}
+
+				// the pvcs can only be deleted once no pod uses them anymore
+				if err = a.core.Pod().Delete(ctx, ns, copyPod); err != nil && !apierrors.IsNotFound(err) {
+					return err
+				}
+				if err = a.core.Pod().WaitDeleted(ctx, ns, copyPod, migrateWaitTimeoutSec); err != nil {
+					return err
+				}
+
+				target, err := a.core.PVC().Get(ctx, ns, targetPVC)
+				if err != nil {
+					return err
+				}
+
+				report.NewVolume = target.Spec.VolumeName
+				a.log.Info("data copied", "source", conf.PVCName, "target", targetPVC, "volume", report.NewVolume)
+
+				return nil
+			},
+		}).
+		Step(step{
+			name: "retain volumes",
+			do: func(ctx context.Context) error {
+				var err error
+				if oldPolicy, err = a.core.PV().SetReclaimPolicy(ctx, report.OldVolume, corev1.PersistentVolumeReclaimRetain); err != nil {
+					return err
+				}
+				if newPolicy, err = a.core.PV().SetReclaimPolicy(ctx, report.NewVolume, corev1.PersistentVolumeReclaimRetain); err != nil {
+					_, rbErr := a.core.PV().SetReclaimPolicy(ctx, report.OldVolume, oldPolicy)
+					return errors.Join(err, rbErr)
+				}
+
+				return nil
+			},
+			undo: func(ctx context.Context) error {
+				_, oldErr := a.core.PV().SetReclaimPolicy(ctx, report.OldVolume, oldPolicy)
+				_, newErr := a.core.PV().SetReclaimPolicy(ctx, report.NewVolume, newPolicy)
+
+				return errors.Join(oldErr, newErr)
+			},
+		}).
+		Step(step{
+			name: "release source pvc",
+			do: func(ctx context.Context) error {
+				return a.deletePVCAndWait(ctx, conf.PVCName)
+			},
+			undo: func(ctx context.Context) error {
+				return a.bindVolume(ctx, report.OldVolume, conf.PVCName, likeSource, core.WithStorageClassName(report.FromStorageClass))
+			},
+		}).
+		Step(step{
+			name: "release target pvc",
+			do: func(ctx context.Context) error {
+				return a.deletePVCAndWait(ctx, targetPVC)
+			},
+		}).
+		Step(step{
+			name: "bind new volume",
+			do: func(ctx context.Context) error {
+				if err := a.bindVolume(ctx, report.NewVolume, conf.PVCName, likeSource, core.WithStorageClassName(conf.ToStorageClass)); err != nil {
+					return err
+				}
+
+				// only the old volume is kept around, the new one is reclaimed as usual once its pvc is deleted
+				_, err := a.core.PV().SetReclaimPolicy(ctx, report.NewVolume, newPolicy)
+
+				return err
+			},
+			undo: func(ctx context.Context) error {
+				return a.deletePVCAndWait(ctx, conf.PVCName)
+			},
+		}).
+		Step(step{
+			name: "scale up consumers",
+			do: func(ctx context.Context) error {
+				for _, w := range report.Consumers {
+					if err := a.core.Workload().Scale(ctx, w, w.Replicas); err != nil {
+						return err
+					}
+				}
+
+				a.result.Data = report
+				a.log.Info("pvc migrated", "pvc", conf.PVCName, "storageClass", conf.ToStorageClass, "volume", report.NewVolume, "retained", report.OldVolume)
+
+				return nil
+			},
+		}).
+		Run(ctx)
+}
+
+// pvcConsumers resolves the workloads of all pods using the pvc, failing on pods which can not be scaled down
+func (a *App) pvcConsumers(ctx context.Context, pvcName string) ([]core.Workload, error) {
+	pods, err := a.core.Pod().ListByPVC(ctx, a.conf.Namespace, pvcName)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		workloads []core.Workload
+		seen      = map[string]bool{}
+	)
+	for i := range pods {
+		w, err := a.core.Workload().ForPod(ctx, &pods[i])
+		if err != nil {
+			return nil, fmt.Errorf("%w, stop it before migrating the pvc", err)
+		}
+
+		if !seen[w.String()] {
+			seen[w.String()] = true
+			workloads = append(workloads, w)
+		}
+	}
+
+	return workloads, nil
+}
+
+// scaleDownStep scales the workloads to zero and waits for all of the pods using the pvc to be gone.
+// Rolling back restores the original replicas, even if the earlier undo actions used up the rollback budget
+func (a *App) scaleDownStep(pvcName string, workloads *[]core.Workload) step {
+	return step{
+		name: "scale down consumers",
+		do: func(ctx context.Context) error {
+			for i, w := range *workloads {
+				if err := a.core.Workload().Scale(ctx, w, 0); err != nil {
+					rbErr := a.scaleUp(ctx, (*workloads)[:i])
+					return errors.Join(err, rbErr)
+				}
+			}
+
+			return nil
+		},
+		undo: func(ctx context.Context) error {
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), core.CleanupTimeout)
+			defer cancel()
+
+			return a.scaleUp(ctx, *workloads)
+		},
+		wait: func(ctx context.Context) error {
+			pods, err := a.core.Pod().ListByPVC(ctx, a.conf.Namespace, pvcName)
+			if err != nil {
+				return err
+			}
+
+			for _, po := range pods {
+				if err = a.core.Pod().WaitDeleted(ctx, a.conf.Namespace, po.Name, migrateWaitTimeoutSec); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+	}
+}
+
+func (a *App) scaleUp(ctx context.Context, workloads []core.Workload) error {
+	var errs []error
+	for _, w := range workloads {
+		errs = append(errs, a.core.Workload().Scale(ctx, w, w.Replicas))
+	}
+
+	return errors.Join(errs...)
+}
+
+// bindVolume creates the pvc pre-bound to the released volume and waits for the binding to complete
+func (a *App) bindVolume(ctx context.Context, volumeName, pvcName string, opts ...core.PVCOptions) error {
+	// the pvc of a partially completed swap might still be terminating
+	if err := a.core.PVC().WaitDeleted(ctx, a.conf.Namespace, pvcName, migrateWaitTimeoutSec); err != nil {
+		return err
+	}
+
+	if err := a.core.PV().Claim(ctx, volumeName, a.conf.Namespace, pvcName); err != nil {
+		return err
+	}
+
+	pvc, _, err := a.core.PVC().Create(ctx, a.conf.Namespace, pvcName, append(opts, core.WithVolumeName(volumeName))...)
+	if err != nil {
+		return err
+	}
+
+	a.result.addResource("pvc", pvc.Namespace, pvc.Name, resourceCreated)
+
+	if _, err = a.core.PVC().WaitBound(ctx, a.conf.Namespace, pvcName, migrateWaitTimeoutSec); err != nil {
+		return err
+	}
+
+	a.log.Info("pvc bound", "pvc", pvcName, "volume", volumeName)
+
+	return nil
+}
+
+func (a *App) deletePVCAndWait(ctx context.Context, pvcName string) error {
+	if err := a.core.PVC().Delete(ctx, a.conf.Namespace, pvcName); err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	return a.core.PVC().WaitDeleted(ctx, a.conf.Namespace, pvcName, migrateWaitTimeoutSec)
+}
@@ -0,0 +1,657 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zeljkobenovic/kmon/pkg/kube/core"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	checkTCP  = "tcp"
+	checkHTTP = "http"
+	checkDNS  = "dns"
+
+	// probeMarker precedes the output of every probe of a probe script, followed by the index of the probe
+	probeMarker = "@@kmon "
+	// netParallel bounds the sources probing at once
+	netParallel = 10
+	// netshootReadyTimeoutSec allows for the netshoot image to be pulled
+	netshootReadyTimeoutSec = 120
+
+	// clusterDNSNamespace and clusterDNSSelector find the CoreDNS pods, which kept the label of kube-dns
+	clusterDNSNamespace = "kube-system"
+	clusterDNSSelector  = "k8s-app=kube-dns"
+	// clusterDNS is the server of the lookups through the nameserver of the pod
+	clusterDNS = "cluster dns"
+)
+
+// netProbe is a check run from a source, against a host and port or, for DNS, resolving a name
+type netProbe struct {
+	check  string
+	target string
+	host   string
+	port   int
+	path   string
+	name   string
+	server string
+	search bool
+}
+
+// command returns the shell command of the probe, printing its outcome in the format parseProbe expects
+func (p netProbe) command(timeout time.Duration) string {
+	secs := strconv.FormatFloat(timeout.Seconds(), 'f', -1, 64)
+	url := "http://" + net.JoinHostPort(p.host, strconv.Itoa(p.port))
+
+	switch p.check {
+	case checkTCP:
+		// any HTTP answer or failure once connected proves the port is reachable, curl only sets time_connect if it connected
+		return fmt.Sprintf("curl -s -o /dev/null --connect-timeout %s -m %s -w '%%{time_connect}\\n' %s", secs, secs, shellQuote(url+"/"))
+	case checkHTTP:
+		return fmt.Sprintf("curl -s -o /dev/null --connect-timeout %s -m %s -w '%%{http_code} %%{time_total}\\n' %s", secs, secs, shellQuote(url+p.path))
+	default:
+		args := []string{"dig", "+tries=1", "+time=" + strconv.Itoa(max(int(timeout.Seconds()), 1)), "+noall", "+comments", "+answer", "+stats"}
+		if p.search {
+			args = append(args, "+search")
+		}
+		if p.server != "" {
+			args = append(args, "@"+p.server)
+		}
+
+		return strings.Join(append(args, shellQuote(p.name)), " ")
+	}
+}
+
+// shellQuote quotes the argument for sh
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// probeScript runs the probes one after the other, the output of each preceded by a marker line.
+// The tools the probes need are checked first, so a missing one fails the whole script
+func probeScript(probes []netProbe, timeout time.Duration) string {
+	tools := map[string]bool{}
+	for _, p := range probes {
+		tools[strings.Fields(p.command(timeout))[0]] = true
+	}
+
+	var b strings.Builder
+	for _, tool := range sortedKeys(tools) {
+		fmt.Fprintf(&b, "command -v %s >/dev/null || { echo '%s is missing, probe from a netshoot container instead' >&2; exit 127; }\n", tool, tool)
+	}
+	for i, p := range probes {
+		fmt.Fprintf(&b, "echo '%s%d'\n%s\n", probeMarker, i, p.command(timeout))
+	}
+
+	return b.String()
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// splitProbeOutput splits the output of a probe script into the outputs of its probes
+func splitProbeOutput(out string, n int) []string {
+	outputs := make([]string, n)
+
+	current := -1
+	for _, line := range strings.Split(out, "\n") {
+		if idx, ok := strings.CutPrefix(line, probeMarker); ok {
+			if i, err := strconv.Atoi(idx); err == nil && i >= 0 && i < n {
+				current = i
+				continue
+			}
+		}
+		if current >= 0 {
+			outputs[current] += line + "\n"
+		}
+	}
+
+	return outputs
+}
+
+// probeResult is the outcome of a probe
+type probeResult struct {
+	Source    string   `json:"source"`
+	Target    string   `json:"target"`
+	Check     string   `json:"check"`
+	OK        bool     `json:"ok"`
+	LatencyMs float64  `json:"latencyMs,omitempty"`
+	Detail    string   `json:"detail,omitempty"`
+	Answers   []string `json:"answers,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// cell is the short form of the result shown in a matrix
+func (r probeResult) cell() string {
+	switch {
+	case r.Error != "":
+		return "error"
+	case !r.OK && r.Detail != "":
+		return "fail " + r.Detail
+	case !r.OK:
+		return "fail"
+	case r.Detail != "":
+		return fmt.Sprintf("%s %.1fms", r.Detail, r.LatencyMs)
+	default:
+		return fmt.Sprintf("ok %.1fms", r.LatencyMs)
+	}
+}
+
+// parseProbe parses the output of a probe
+func parseProbe(p netProbe, out string) probeResult {
+	r := probeResult{Target: p.target, Check: p.check}
+	fields := strings.Fields(out)
+
+	switch p.check {
+	case checkTCP:
+		if len(fields) > 0 {
+			secs, _ := strconv.ParseFloat(fields[0], 64)
+			r.OK, r.LatencyMs = secs > 0, secs*1000
+		}
+	case checkHTTP:
+		if len(fields) > 1 {
+			secs, _ := strconv.ParseFloat(fields[1], 64)
+			r.OK, r.LatencyMs, r.Detail = fields[0] != "000", secs*1000, fields[0]
+		}
+	default:
+		dig := parseDig(out)
+		r.LatencyMs, r.Answers = dig.latencyMs, dig.answers
+
+		switch {
+		case dig.status == "":
+			r.Detail = "no response"
+		case dig.status != "NOERROR":
+			r.Detail = dig.status
+		case len(dig.answers) == 0:
+			r.Detail = "no answer"
+		default:
+			r.OK = true
+		}
+	}
+
+	return r
+}
+
+type digResult struct {
+	status    string
+	answers   []string
+	latencyMs float64
+}
+
+// parseDig parses the output of dig +noall +comments +answer +stats
+func parseDig(out string) digResult {
+	var r digResult
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, ";; ->>HEADER<<-"):
+			if _, status, ok := strings.Cut(line, "status: "); ok {
+				r.status, _, _ = strings.Cut(status, ",")
+			}
+		case strings.HasPrefix(line, ";; Query time:"):
+			if fields := strings.Fields(line); len(fields) >= 4 {
+				r.latencyMs, _ = strconv.ParseFloat(fields[3], 64)
+			}
+		case strings.HasPrefix(line, ";"):
+		default:
+			// answer records read "name ttl class type data"
+			if fields := strings.Fields(line); len(fields) >= 5 {
+				r.answers = append(r.answers, fields[3]+" "+strings.Join(fields[4:], " "))
+			}
+		}
+	}
+
+	return r
+}
+
+// probeSource is a pod and container the probes run from
+type probeSource struct {
+	pod       string
+	container string
+}
+
+// runProbes runs the probes from every source, at most netParallel at once, returning the results by source
+func (a *App) runProbes(ctx context.Context, sources []probeSource, probes []netProbe) [][]probeResult {
+	timeout := a.conf.Net.ProbeTimeout
+	script := probeScript(probes, timeout)
+
+	results := make([][]probeResult, len(sources))
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, netParallel)
+	for i, src := range sources {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			results[i] = a.runProbeScript(ctx, src, probes, script)
+		}()
+	}
+	wg.Wait()
+
+	return results
+}
+
+func (a *App) runProbeScript(ctx context.Context, src probeSource, probes []netProbe, script string) []probeResult {
+	results := make([]probeResult, len(probes))
+
+	res, err := a.core.Pod().ExecCapture(ctx, a.conf.Namespace, src.pod, src.container, []string{"sh", "-c", script})
+	if err == nil && res.ExitCode == 127 {
+		err = errors.New(strings.TrimSpace(res.Stderr))
+	}
+	if err != nil {
+		a.log.Warn("could not run probes", "pod", src.pod, "container", src.container, "error", err)
+		for i, p := range probes {
+			results[i] = probeResult{Source: src.pod, Target: p.target, Check: p.check, Error: err.Error()}
+		}
+
+		return results
+	}
+
+	for i, out := range splitProbeOutput(res.Stdout, len(probes)) {
+		results[i] = parseProbe(probes[i], out)
+		results[i].Source = src.pod
+	}
+
+	return results
+}
+
+// netMatrix is the reachability of every target from every source
+type netMatrix struct {
+	Sources []string      `json:"sources"`
+	Columns []string      `json:"columns"`
+	Results []probeResult `json:"results"`
+}
+
+func (m netMatrix) printText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	if _, err := fmt.Fprintln(tw, "SOURCE\t"+strings.Join(m.Columns, "\t")); err != nil {
+		return err
+	}
+
+	cells := map[[2]string]string{}
+	for _, r := range m.Results {
+		cells[[2]string{r.Source, r.Check + " " + r.Target}] = r.cell()
+	}
+
+	for _, src := range m.Sources {
+		row := []string{src}
+		for _, col := range m.Columns {
+			row = append(row, orDash(cells[[2]string{src, col}]))
+		}
+
+		if _, err := fmt.Fprintln(tw, strings.Join(row, "\t")); err != nil {
+			return err
+		}
+	}
+
+	return tw.Flush()
+}
+
+func (a *App) NetMatrixCmdHandler() error {
+	return a.withContext("net matrix", a.netMatrix)
+}
+
+// netMatrix probes every target from every source: existing pods, netshoot containers added to them,
+// or a netshoot pod launched for the probes
+func (a *App) netMatrix(ctx context.Context) error {
+	conf := a.conf.Net.Matrix
+
+	checks := map[string]bool{}
+	for _, c := range conf.Checks {
+		if c != checkTCP && c != checkHTTP {
+			return core.Errorf(core.ErrValidationFailed, "unknown check %q, expected %s or %s", c, checkTCP, checkHTTP)
+		}
+		checks[c] = true
+	}
+
+	targetNamespace := conf.TargetNamespace
+	if targetNamespace == "" {
+		targetNamespace = a.conf.Namespace
+	}
+
+	var probes []netProbe
+	if conf.TargetSelector != "" {
+		targets, err := a.core.Pod().ListBySelector(ctx, targetNamespace, conf.TargetSelector)
+		if err != nil {
+			return err
+		}
+		sort.Slice(targets, func(i, j int) bool { return targets[i].Name < targets[j].Name })
+
+		for _, t := range targets {
+			if t.Status.PodIP == "" {
+				a.log.Warn("skipping target without an ip", "pod", t.Name, "phase", t.Status.Phase)
+				continue
+			}
+
+			for _, c := range []string{checkTCP, checkHTTP} {
+				if checks[c] {
+					target := fmt.Sprintf("%s:%d", t.Name, conf.Port)
+					probes = append(probes, netProbe{check: c, target: target, host: t.Status.PodIP, port: conf.Port, path: conf.HTTPPath})
+				}
+			}
+		}
+	}
+	for _, name := range conf.DNSNames {
+		probes = append(probes, netProbe{check: checkDNS, target: name, name: name, search: true})
+	}
+	if len(probes) == 0 {
+		return core.Errorf(core.ErrNotFound, "nothing to probe, no running pod matches the target selector and no dns name is given")
+	}
+
+	matrix := &netMatrix{}
+	for _, p := range probes {
+		matrix.Columns = append(matrix.Columns, p.check+" "+p.target)
+	}
+	a.result.Data = matrix
+
+	probePod := "kmon-net-probe"
+	wf := a.newWorkflow("net matrix")
+	if conf.Launch {
+		s := a.podStep("run probe pod", &probePod, netshootReadyTimeoutSec, func() []core.PodOptions {
+			labels := map[string]string{core.ManagedByLabel: core.ManagedBy}
+			for k, v := range conf.ProbeLabels {
+				labels[k] = v
+			}
+
+			// a generated name keeps concurrent runs from sharing the pod one of them deletes once done
+			return []core.PodOptions{core.WithLabels(labels), core.WithGenerateName()}
+		})
+		s.temporary = true
+
+		wf.Step(s)
+	}
+
+	return wf.Step(step{
+		name: "probe targets",
+		do: func(ctx context.Context) error {
+			sources := []probeSource{{pod: probePod}}
+			if !conf.Launch {
+				var err error
+				if sources, err = a.probeSources(ctx); err != nil {
+					return err
+				}
+			}
+
+			for _, src := range sources {
+				matrix.Sources = append(matrix.Sources, src.pod)
+			}
+
+			failed := 0
+			for _, results := range a.runProbes(ctx, sources, probes) {
+				for _, r := range results {
+					if !r.OK {
+						failed++
+					}
+				}
+				matrix.Results = append(matrix.Results, results...)
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d of %d probes failed", failed, len(matrix.Results))
+			}
+
+			return nil
+		},
+	}).Run(ctx)
+}
+
+// probeSources returns the running pods matching the selector to probe from, in their default container
+// or in a netshoot container added to them
+func (a *App) probeSources(ctx context.Context) ([]probeSource, error) {
+	conf := a.conf.Net.Matrix
+
+	pods, err := a.core.Pod().ListBySelector(ctx, a.conf.Namespace, conf.Selector)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(pods, func(i, j int) bool { return pods[i].Name < pods[j].Name })
+
+	var sources []probeSource
+	for _, po := range pods {
+		if po.Status.Phase != corev1.PodRunning {
+			a.log.Warn("skipping source which is not running", "pod", po.Name, "phase", po.Status.Phase)
+			continue
+		}
+
+		src := probeSource{pod: po.Name}
+		if conf.Ephemeral {
+			if src.container, err = a.core.Pod().AddNetshootContainer(ctx, a.conf.Namespace, po.Name, netshootReadyTimeoutSec); err != nil {
+				return nil, err
+			}
+		}
+
+		sources = append(sources, src)
+	}
+	if len(sources) == 0 {
+		return nil, core.Errorf(core.ErrNotFound, "no running pod matches the selector %s in namespace %s", conf.Selector, a.conf.Namespace)
+	}
+
+	return sources, nil
+}
+
+// resolvConf is the resolver configuration of a pod
+type resolvConf struct {
+	Nameservers []string `json:"nameservers"`
+	Search      []string `json:"search"`
+	Options     []string `json:"options,omitempty"`
+}
+
+// parseResolvConf parses /etc/resolv.conf, the last search line winning like in the resolver
+func parseResolvConf(out string) resolvConf {
+	var rc resolvConf
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		switch fields[0] {
+		case "nameserver":
+			rc.Nameservers = append(rc.Nameservers, fields[1])
+		case "search":
+			rc.Search = fields[1:]
+		case "options":
+			rc.Options = append(rc.Options, fields[1:]...)
+		}
+	}
+
+	return rc
+}
+
+// dnsLookup is the outcome of resolving the name against a server
+type dnsLookup struct {
+	Server    string   `json:"server"`
+	Address   string   `json:"address"`
+	OK        bool     `json:"ok"`
+	LatencyMs float64  `json:"latencyMs,omitempty"`
+	Detail    string   `json:"detail,omitempty"`
+	Answers   []string `json:"answers,omitempty"`
+	Error     string   `json:"error,omitempty"`
+
+	result string
+}
+
+func newDNSLookup(address string, r probeResult) dnsLookup {
+	answers := slices.Clone(r.Answers)
+	sort.Strings(answers)
+
+	return dnsLookup{
+		Server:    r.Target,
+		Address:   address,
+		OK:        r.OK,
+		LatencyMs: r.LatencyMs,
+		Detail:    r.Detail,
+		Answers:   answers,
+		Error:     r.Error,
+		result:    r.cell(),
+	}
+}
+
+// outcome describes the answers of the lookup, or why it failed
+func (l dnsLookup) outcome() string {
+	switch {
+	case l.Error != "":
+		return "error: " + l.Error
+	case !l.OK:
+		return orDash(l.Detail)
+	default:
+		return strings.Join(l.Answers, ", ")
+	}
+}
+
+// netDNS is the resolution of a name from a pod, through its nameserver and against every CoreDNS pod
+type netDNS struct {
+	Name          string      `json:"name"`
+	Pod           string      `json:"pod"`
+	Node          string      `json:"node,omitempty"`
+	ResolvConf    resolvConf  `json:"resolvConf"`
+	Lookups       []dnsLookup `json:"lookups"`
+	Discrepancies []string    `json:"discrepancies,omitempty"`
+}
+
+func (r netDNS) printText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	lines := []string{
+		"resolv.conf of pod " + r.Pod,
+		"  nameservers\t" + orDash(strings.Join(r.ResolvConf.Nameservers, " ")),
+		"  search\t" + orDash(strings.Join(r.ResolvConf.Search, " ")),
+		"  options\t" + orDash(strings.Join(r.ResolvConf.Options, " ")),
+		"lookups of " + r.Name,
+		"  SERVER\tADDRESS\tRESULT\tANSWERS",
+	}
+	for _, l := range r.Lookups {
+		answers := strings.Join(l.Answers, ", ")
+		if l.Error != "" {
+			answers = l.Error
+		}
+		lines = append(lines, fmt.Sprintf("  %s\t%s\t%s\t%s", l.Server, orDash(l.Address), l.result, orDash(answers)))
+	}
+
+	if len(r.Discrepancies) > 0 {
+		lines = append(lines, "discrepancies")
+	}
+	for _, d := range r.Discrepancies {
+		lines = append(lines, "  "+d)
+	}
+
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(tw, line); err != nil {
+			return err
+		}
+	}
+
+	return tw.Flush()
+}
+
+func (a *App) NetDNSCmdHandler() error {
+	return a.withContext("net dns", a.netDNS)
+}
+
+// netDNS resolves the name from a netshoot pod through the cluster DNS and against every CoreDNS pod directly,
+// failing if the cluster DNS could not resolve it or a CoreDNS pod answered differently
+func (a *App) netDNS(ctx context.Context) error {
+	conf := a.conf.Net.DNS
+
+	report := &netDNS{Name: conf.Name, Node: conf.Node}
+	a.result.Data = report
+
+	probePod := "kmon-net-dns"
+	s := a.podStep("run dns pod", &probePod, netshootReadyTimeoutSec, func() []core.PodOptions {
+		// a generated name keeps a pod left on another node, or used by a concurrent run, from being reused
+		opts := []core.PodOptions{core.WithLabels(map[string]string{core.ManagedByLabel: core.ManagedBy}), core.WithGenerateName()}
+		if conf.Node != "" {
+			opts = append(opts, core.WithNodeName(conf.Node))
+		}
+
+		return opts
+	})
+	s.temporary = true
+
+	return a.newWorkflow("net dns").Step(s).Step(step{
+		name: "resolve name",
+		do: func(ctx context.Context) error {
+			report.Pod = probePod
+
+			res, err := a.core.Pod().ExecCapture(ctx, a.conf.Namespace, probePod, "", []string{"cat", "/etc/resolv.conf"})
+			if err != nil {
+				return fmt.Errorf("could not read resolv.conf: %w", err)
+			}
+			report.ResolvConf = parseResolvConf(res.Stdout)
+
+			servers, err := a.core.Pod().ListBySelector(ctx, clusterDNSNamespace, clusterDNSSelector)
+			if err != nil {
+				return err
+			}
+			sort.Slice(servers, func(i, j int) bool { return servers[i].Name < servers[j].Name })
+
+			addresses := []string{""}
+			if len(report.ResolvConf.Nameservers) > 0 {
+				addresses[0] = report.ResolvConf.Nameservers[0]
+			}
+
+			// every server searches the name with the search path of the pod, like the pod's own lookups do
+			probes := []netProbe{{check: checkDNS, target: clusterDNS, name: conf.Name, search: true}}
+			for _, po := range servers {
+				if po.Status.PodIP == "" {
+					a.log.Warn("skipping dns pod without an ip", "pod", po.Name, "phase", po.Status.Phase)
+					continue
+				}
+
+				probes = append(probes, netProbe{check: checkDNS, target: po.Name, name: conf.Name, server: po.Status.PodIP, search: true})
+				addresses = append(addresses, po.Status.PodIP)
+			}
+			if len(probes) == 1 {
+				a.log.Warn("no dns pod found, only resolving through the cluster dns", "namespace", clusterDNSNamespace, "selector", clusterDNSSelector)
+			}
+
+			script := probeScript(probes, a.conf.Net.ProbeTimeout)
+			for i, r := range a.runProbeScript(ctx, probeSource{pod: probePod}, probes, script) {
+				report.Lookups = append(report.Lookups, newDNSLookup(addresses[i], r))
+			}
+
+			cluster := report.Lookups[0]
+			for _, l := range report.Lookups[1:] {
+				if l.outcome() != cluster.outcome() {
+					report.Discrepancies = append(report.Discrepancies,
+						fmt.Sprintf("%s answered %s, the cluster dns %s", l.Server, l.outcome(), cluster.outcome()))
+				}
+			}
+
+			switch {
+			case !cluster.OK:
+				return fmt.Errorf("the cluster dns could not resolve %s: %s", conf.Name, cluster.outcome())
+			case len(report.Discrepancies) > 0:
+				return fmt.Errorf("%d of %d dns pods answered differently than the cluster dns", len(report.Discrepancies), len(report.Lookups)-1)
+			}
+
+			a.log.Info("resolved name", "name", conf.Name, "answers", cluster.Answers, "dnsPods", len(report.Lookups)-1)
+
+			return nil
+		},
+	}).Run(ctx)
+}
@@ -0,0 +1,461 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	v3 "github.com/kubernetes-csi/external-snapshotter/client/v8/apis/volumesnapshot/v1"
+	"github.com/zeljkobenovic/kmon/pkg/kube/core"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	kindPVC                   = "PersistentVolumeClaim"
+	kindPV                    = "PersistentVolume"
+	kindVolumeSnapshot        = "VolumeSnapshot"
+	kindVolumeSnapshotContent = "VolumeSnapshotContent"
+)
+
+// orphanKinds maps the values of the --kind flag to the kinds of orphans
+var orphanKinds = map[string]string{
+	"pvc":             kindPVC,
+	"pv":              kindPV,
+	"snapshot":        kindVolumeSnapshot,
+	"snapshotcontent": kindVolumeSnapshotContent,
+}
+
+// Orphan is a storage object nothing uses anymore
+type Orphan struct {
+	Kind      string    `json:"kind"`
+	Namespace string    `json:"namespace,omitempty"`
+	Name      string    `json:"name"`
+	Reason    string    `json:"reason"`
+	Size      string    `json:"size,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	// ManagedByKmon is set for objects created by kmon, e.g. forgotten restores
+	ManagedByKmon bool `json:"managedByKmon,omitempty"`
+	// Retained is set for volumes and snapshot contents whose data outlives them at the storage provider
+	Retained bool `json:"retained,omitempty"`
+	Deleted  bool `json:"deleted,omitempty"`
+	// Kept tells why the object was not deleted along with the others
+	Kept string `json:"kept,omitempty"`
+}
+
+// orphanReport is the outcome of the pvc orphans command
+type orphanReport struct {
+	Orphans []Orphan `json:"orphans"`
+}
+
+func (r orphanReport) printText(w io.Writer) error {
+	if len(r.Orphans) == 0 {
+		_, err := fmt.Fprintln(w, "  no orphaned storage objects found")
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	if _, err := fmt.Fprintln(tw, "KIND\tNAMESPACE\tNAME\tSIZE\tAGE\tKMON\tREASON"); err != nil {
+		return err
+	}
+
+	for _, o := range r.Orphans {
+		namespace, size, kmon := o.Namespace, o.Size, "-"
+		if namespace == "" {
+			namespace = "-"
+		}
+		if size == "" {
+			size = "-"
+		}
+		if o.ManagedByKmon {
+			kmon = "yes"
+		}
+
+		reason := o.Reason
+		switch {
+		case o.Deleted:
+			reason += " (deleted)"
+		case o.Kept != "":
+			reason += " (kept, " + o.Kept + ")"
+		}
+
+		if _, err := fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", o.Kind, namespace, o.Name, size, humanAge(o.CreatedAt), kmon, reason); err != nil {
+			return err
+		}
+	}
+
+	return tw.Flush()
+}
+
+func (a *App) PVCOrphansCmdHandler() error {
+	return a.withContext("pvc orphans", a.findOrphans)
+}
+
+// findOrphans reports the storage objects nothing refers to anymore and optionally deletes them
+func (a *App) findOrphans(ctx context.Context) error {
+	conf := a.conf.PVC.Orphans
+
+	kinds, explicit, err := selectedOrphanKinds(conf.Kinds)
+	if err != nil {
+		return err
+	}
+
+	namespace := a.conf.Namespace
+	if conf.AllNamespaces {
+		namespace = ""
+	}
+
+	var found []Orphan
+
+	pvcOrphans, pvcs, err := a.orphanedPVCs(ctx, namespace)
+	if err != nil {
+		return err
+	}
+	found = append(found, pvcOrphans...)
+
+	if kinds[kindPV] {
+		pvOrphans, err := a.orphanedPVs(ctx, namespace)
+		if err != nil {
+			return err
+		}
+		found = append(found, pvOrphans...)
+	}
+
+	if kinds[kindVolumeSnapshot] || kinds[kindVolumeSnapshotContent] {
+		snapshotOrphans, err := a.orphanedSnapshots(ctx, namespace, pvcs)
+		if err != nil {
+			return err
+		}
+		found = append(found, snapshotOrphans...)
+	}
+
+	var orphans []Orphan
+	for _, o := range found {
+		if kinds[o.Kind] && (!conf.KmonOnly || o.ManagedByKmon) {
+			orphans = append(orphans, o)
+		}
+	}
+
+	sort.SliceStable(orphans, func(i, j int) bool { return orphans[i].CreatedAt.Before(orphans[j].CreatedAt) })
+
+	report := orphanReport{Orphans: orphans}
+	a.result.Data = &report
+	a.log.Info("found orphaned storage objects", "count", len(orphans))
+
+	if !conf.Delete || len(orphans) == 0 {
+		return nil
+	}
+
+	deletable := 0
+	for i := range report.Orphans {
+		o := &report.Orphans[i]
+		if o.Kept = keepReason(*o, explicit, conf.IncludeRetained); o.Kept == "" {
+			deletable++
+		}
+	}
+	if deletable == 0 {
+		a.log.Info("nothing to delete, all orphaned storage objects are kept")
+		return nil
+	}
+
+	if !conf.Yes {
+		ok, err := confirm(fmt.Sprintf("delete %d of the %d orphaned storage objects?", deletable, len(orphans)))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			a.log.Info("deletion cancelled")
+			return nil
+		}
+	}
+
+	return a.deleteOrphans(ctx, report.Orphans)
+}
+
+// selectedOrphanKinds returns the kinds of orphans to report, all of them if none was selected,
+// along with the explicitly selected ones
+func selectedOrphanKinds(names []string) (map[string]bool, map[string]bool, error) {
+	kinds, explicit := map[string]bool{}, map[string]bool{}
+	for _, name := range names {
+		kind, ok := orphanKinds[strings.ToLower(name)]
+		if !ok {
+			return nil, nil, core.Errorf(core.ErrValidationFailed, "unknown kind %q, expected one of: pvc, pv, snapshot, snapshotcontent", name)
+		}
+		explicit[kind] = true
+	}
+
+	for _, kind := range orphanKinds {
+		kinds[kind] = len(explicit) == 0 || explicit[kind]
+	}
+
+	return kinds, explicit, nil
+}
+
+// keepReason tells why the orphan must not be deleted without being asked for explicitly, or is empty if it can be:
+// a snapshot might be the last backup of its deleted pvc, a pvc of the user holds data kmon does not know about,
+// and a retained volume or snapshot content was kept on purpose
+func keepReason(o Orphan, explicit map[string]bool, includeRetained bool) string {
+	switch {
+	case o.Kind == kindVolumeSnapshot && !explicit[kindVolumeSnapshot]:
+		return "delete snapshots with --kind snapshot"
+	case o.Kind == kindPVC && !o.ManagedByKmon && !explicit[kindPVC]:
+		return "not created by kmon, delete it with --kind pvc"
+	case o.Retained && !includeRetained:
+		return "retained, delete it with --include-retained"
+	default:
+		return ""
+	}
+}
+
+// orphanedPVCs reports the pvcs neither mounted by a pod nor referenced by a workload template.
+// All pvcs of the namespace are returned as well, keyed by namespace/name
+func (a *App) orphanedPVCs(ctx context.Context, namespace string) ([]Orphan, map[string]bool, error) {
+	pvcs, err := a.core.PVC().List(ctx, namespace)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pods, err := a.core.Pod().List(ctx, namespace)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	templates, err := a.core.Workload().PodTemplates(ctx, namespace)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	used := map[string]bool{}
+	for _, po := range pods {
+		if po.Status.Phase == corev1.PodSucceeded || po.Status.Phase == corev1.PodFailed {
+			continue
+		}
+
+		for _, name := range core.PVCNames(po.Spec) {
+			used[po.Namespace+"/"+name] = true
+		}
+	}
+
+	existing := map[string]bool{}
+	var orphans []Orphan
+	for _, pvc := range pvcs {
+		key := pvc.Namespace + "/" + pvc.Name
+		existing[key] = true
+
+		if used[key] || usedByTemplate(templates, pvc.Namespace, pvc.Name) {
+			continue
+		}
+
+		size := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+		if capacity, ok := pvc.Status.Capacity[corev1.ResourceStorage]; ok {
+			size = capacity
+		}
+
+		orphans = append(orphans, Orphan{
+			Kind:          kindPVC,
+			Namespace:     pvc.Namespace,
+			Name:          pvc.Name,
+			Reason:        "not mounted by any pod or workload",
+			Size:          size.String(),
+			CreatedAt:     pvc.CreationTimestamp.Time,
+			ManagedByKmon: pvc.Labels[core.ManagedByLabel] == core.ManagedBy || pvc.Annotations[core.SpecHashAnnotation] != "",
+		})
+	}
+
+	return orphans, existing, nil
+}
+
+func usedByTemplate(templates []core.PodTemplate, namespace, pvcName string) bool {
+	for _, t := range templates {
+		if t.Namespace == namespace && t.UsesPVC(pvcName) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// orphanedPVs reports the Released and Available volumes, limited to the ones last claimed in the namespace if it is set
+func (a *App) orphanedPVs(ctx context.Context, namespace string) ([]Orphan, error) {
+	pvs, err := a.core.PV().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var orphans []Orphan
+	for _, pv := range pvs {
+		if namespace != "" && (pv.Spec.ClaimRef == nil || pv.Spec.ClaimRef.Namespace != namespace) {
+			continue
+		}
+
+		var reason string
+		switch pv.Status.Phase {
+		case corev1.VolumeReleased:
+			reason = fmt.Sprintf("released by pvc %s/%s, reclaim policy %s", pv.Spec.ClaimRef.Namespace, pv.Spec.ClaimRef.Name, pv.Spec.PersistentVolumeReclaimPolicy)
+		case corev1.VolumeAvailable:
+			reason = "available, not bound to any pvc"
+		default:
+			continue
+		}
+
+		size := pv.Spec.Capacity[corev1.ResourceStorage]
+
+		orphans = append(orphans, Orphan{
+			Kind:      kindPV,
+			Name:      pv.Name,
+			Reason:    reason,
+			Size:      size.String(),
+			CreatedAt: pv.CreationTimestamp.Time,
+			Retained:  pv.Spec.PersistentVolumeReclaimPolicy == corev1.PersistentVolumeReclaimRetain,
+		})
+	}
+
+	return orphans, nil
+}
+
+// orphanedSnapshots reports the snapshots whose source pvc no longer exists
+// and the snapshot contents whose snapshot no longer exists
+func (a *App) orphanedSnapshots(ctx context.Context, namespace string, pvcs map[string]bool) ([]Orphan, error) {
+	snapshots, err := a.core.PVC().ListVolumeSnapshots(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	contents, err := a.core.PVC().ListVolumeSnapshotContents(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var orphans []Orphan
+
+	snapshotUIDs := map[string]string{}
+	for _, vs := range snapshots {
+		snapshotUIDs[vs.Namespace+"/"+vs.Name] = string(vs.UID)
+
+		source := vs.Spec.Source.PersistentVolumeClaimName
+		if source == nil || pvcs[vs.Namespace+"/"+*source] {
+			continue
+		}
+
+		var size string
+		if vs.Status != nil && vs.Status.RestoreSize != nil {
+			size = vs.Status.RestoreSize.String()
+		}
+
+		orphans = append(orphans, Orphan{
+			Kind:          kindVolumeSnapshot,
+			Namespace:     vs.Namespace,
+			Name:          vs.Name,
+			Reason:        fmt.Sprintf("source pvc %s no longer exists", *source),
+			Size:          size,
+			CreatedAt:     vs.CreationTimestamp.Time,
+			ManagedByKmon: vs.Labels[core.ManagedByLabel] == core.ManagedBy,
+		})
+	}
+
+	for _, vsc := range contents {
+		ref := vsc.Spec.VolumeSnapshotRef
+		if namespace != "" && ref.Namespace != namespace {
+			continue
+		}
+
+		uid, ok := snapshotUIDs[ref.Namespace+"/"+ref.Name]
+		if ok && (ref.UID == "" || string(ref.UID) == uid) {
+			continue
+		}
+
+		var size string
+		if vsc.Status != nil && vsc.Status.RestoreSize != nil {
+			size = resource.NewQuantity(*vsc.Status.RestoreSize, resource.BinarySI).String()
+		}
+
+		orphans = append(orphans, Orphan{
+			Kind:      kindVolumeSnapshotContent,
+			Name:      vsc.Name,
+			Reason:    fmt.Sprintf("snapshot %s/%s no longer exists, deletion policy %s", ref.Namespace, ref.Name, vsc.Spec.DeletionPolicy),
+			Size:      size,
+			CreatedAt: vsc.CreationTimestamp.Time,
+			Retained:  vsc.Spec.DeletionPolicy == v3.VolumeSnapshotContentRetain,
+		})
+	}
+
+	return orphans, nil
+}
+
+// deleteOrphans deletes the snapshots and pvcs before the cluster scoped objects they might still be bound to,
+// carrying on past failures. Kept orphans are left in place
+func (a *App) deleteOrphans(ctx context.Context, orphans []Orphan) error {
+	var errs []error
+	for _, kind := range []string{kindVolumeSnapshot, kindPVC, kindVolumeSnapshotContent, kindPV} {
+		for i := range orphans {
+			o := &orphans[i]
+			if o.Kind != kind || o.Kept != "" {
+				continue
+			}
+
+			var err error
+			switch kind {
+			case kindVolumeSnapshot:
+				err = a.core.PVC().DeleteVolumeSnapshot(ctx, o.Namespace, o.Name)
+			case kindPVC:
+				err = a.core.PVC().Delete(ctx, o.Namespace, o.Name)
+			case kindVolumeSnapshotContent:
+				err = a.core.PVC().DeleteVolumeSnapshotContent(ctx, o.Name)
+			case kindPV:
+				err = a.core.PV().Delete(ctx, o.Name)
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("could not delete %s %s: %w", o.Kind, o.Name, err))
+				continue
+			}
+
+			o.Deleted = true
+			a.result.addResource(strings.ToLower(o.Kind), o.Namespace, o.Name, resourceDeleted)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// confirm asks the question on stderr, keeping stdout for the result, and reads the answer from stdin
+func confirm(question string) (bool, error) {
+	if _, err := fmt.Fprintf(os.Stderr, "%s [y/N] ", question); err != nil {
+		return false, err
+	}
+
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, err
+	}
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+// humanAge formats the time elapsed since t like kubectl does, e.g. 5m, 3h or 12d
+func humanAge(t time.Time) string {
+	d := time.Since(t)
+
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%dd", int(d.Hours()/24))
+	}
+}
@@ -0,0 +1,102 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/zeljkobenovic/kmon/pkg/kube/core"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// rebindTimeoutSec bounds waiting for the PV controller to bind the new pvc
+const rebindTimeoutSec = 120
+
+// rebindReport is the outcome of the pv rebind command
+type rebindReport struct {
+	PV            string `json:"pv"`
+	Namespace     string `json:"namespace"`
+	Claim         string `json:"claim"`
+	PreviousClaim string `json:"previousClaim,omitempty"`
+	Size          string `json:"size"`
+	StorageClass  string `json:"storageClass,omitempty"`
+}
+
+func (r rebindReport) printText(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "  pv %s (%s) bound to pvc %s/%s\n", r.PV, r.Size, r.Namespace, r.Claim)
+	return err
+}
+
+func (a *App) PVRebindCmdHandler() error {
+	return a.withContext("pv rebind", a.rebindPV)
+}
+
+// rebindPV reserves a Released or Available volume for the new claim and creates the claim pre-bound to it,
+// sized, classed and accessed like the volume, so the PV controller binds the two
+func (a *App) rebindPV(ctx context.Context) error {
+	conf := a.conf.PV.Rebind
+	ns := a.conf.Namespace
+	claimName := conf.ClaimName
+
+	var (
+		vol    *corev1.PersistentVolume
+		report = rebindReport{PV: conf.PVName, Namespace: ns, Claim: conf.ClaimName}
+	)
+
+	// applied when the pvc is created, once the volume has been inspected
+	likeVolume := func(pvc *corev1.PersistentVolumeClaim) {
+		core.WithStorageSize(vol.Spec.Capacity[corev1.ResourceStorage])(pvc)
+		core.WithAccessModes(vol.Spec.AccessModes...)(pvc)
+		core.WithStorageClassName(vol.Spec.StorageClassName)(pvc)
+		core.WithVolumeName(vol.Name)(pvc)
+		if vol.Spec.VolumeMode != nil {
+			core.WithVolumeMode(*vol.Spec.VolumeMode)(pvc)
+		}
+	}
+
+	createPVC := a.pvcStep("create pvc", &claimName, likeVolume)
+	createPVC.wait = func(ctx context.Context) error {
+		if _, err := a.core.PVC().WaitBound(ctx, ns, claimName, rebindTimeoutSec); err != nil {
+			return err
+		}
+
+		a.result.Data = report
+		a.log.Info("pv rebound", "pv", conf.PVName, "pvc", ns+"/"+claimName, "previous", report.PreviousClaim)
+
+		return nil
+	}
+
+	return a.newWorkflow("pv rebind").
+		Step(step{
+			name: "inspect pv",
+			do: func(ctx context.Context) error {
+				var err error
+				if vol, err = a.core.PV().Get(ctx, conf.PVName); err != nil {
+					return err
+				}
+
+				if vol.Status.Phase != corev1.VolumeReleased && vol.Status.Phase != corev1.VolumeAvailable {
+					return core.Errorf(core.ErrValidationFailed, "pv %s is %s, only Released and Available volumes can be rebound", vol.Name, vol.Status.Phase)
+				}
+				if ref := vol.Spec.ClaimRef; ref != nil {
+					report.PreviousClaim = ref.Namespace + "/" + ref.Name
+				}
+
+				size := vol.Spec.Capacity[corev1.ResourceStorage]
+				report.Size, report.StorageClass = size.String(), vol.Spec.StorageClassName
+
+				return nil
+			},
+		}).
+		Step(step{
+			name: "claim pv",
+			do: func(ctx context.Context) error {
+				return a.core.PV().Claim(ctx, conf.PVName, ns, claimName)
+			},
+			undo: func(ctx context.Context) error {
+				return a.core.PV().SetClaimRef(ctx, conf.PVName, vol.Spec.ClaimRef)
+			},
+		}).
+		Step(createPVC).
+		Run(ctx)
+}
@@ -0,0 +1,212 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/zeljkobenovic/kmon/pkg/kube/core"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// resizeTimeoutSec bounds waiting for the controller and the kubelet to expand the volume
+	resizeTimeoutSec = 600
+	// resizeOnlineGraceSec is how long the kubelet gets to expand the filesystem of a mounted volume online
+	resizeOnlineGraceSec = 60
+)
+
+// resizeReport is the outcome of the pvc resize command
+type resizeReport struct {
+	PVC           string   `json:"pvc"`
+	From          string   `json:"from"`
+	To            string   `json:"to"`
+	Capacity      string   `json:"capacity"`
+	Conditions    []string `json:"conditions,omitempty"`
+	RestartedPods []string `json:"restartedPods,omitempty"`
+	// FileSystemResizePending is set when the volume is not mounted, the kubelet expands it on the next mount
+	FileSystemResizePending bool `json:"fileSystemResizePending,omitempty"`
+}
+
+func (r resizeReport) printText(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "  pvc %s resized from %s to %s, capacity %s\n", r.PVC, r.From, r.To, r.Capacity); err != nil {
+		return err
+	}
+
+	if len(r.Conditions) > 0 {
+		if _, err := fmt.Fprintf(w, "  conditions: %s\n", strings.Join(r.Conditions, ", ")); err != nil {
+			return err
+		}
+	}
+	if len(r.RestartedPods) > 0 {
+		if _, err := fmt.Fprintf(w, "  restarted pods: %s\n", strings.Join(r.RestartedPods, ", ")); err != nil {
+			return err
+		}
+	}
+	if r.FileSystemResizePending {
+		if _, err := fmt.Fprintln(w, "  the filesystem is expanded once the pvc is mounted by a pod"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (a *App) PVCResizeCmdHandler() error {
+	return a.withContext("pvc resize", a.resizePVC)
+}
+
+// resizePVC requests the new size and follows the resize conditions until the capacity of the pvc reflects it.
+// A mounted volume whose filesystem is not expanded online within resizeOnlineGraceSec needs its pods restarted
+func (a *App) resizePVC(ctx context.Context) error {
+	conf := a.conf.PVC.Resize
+	ns := a.conf.Namespace
+
+	size, err := resource.ParseQuantity(conf.Size)
+	if err != nil {
+		return fmt.Errorf("invalid --size: %w", err)
+	}
+
+	report := resizeReport{PVC: conf.PVCName, To: size.String()}
+	seen := map[corev1.PersistentVolumeClaimConditionType]bool{}
+
+	// observe logs every resize condition once and keeps track of the capacity
+	observe := func(pvc *corev1.PersistentVolumeClaim) {
+		for _, c := range pvc.Status.Conditions {
+			if c.Status == corev1.ConditionTrue && !seen[c.Type] {
+				seen[c.Type] = true
+				report.Conditions = append(report.Conditions, string(c.Type))
+				a.log.Info("resize condition", "pvc", pvc.Name, "type", c.Type, "message", c.Message)
+			}
+		}
+
+		if capacity, ok := pvc.Status.Capacity[corev1.ResourceStorage]; ok {
+			report.Capacity = capacity.String()
+		}
+	}
+
+	resized := func(pvc *corev1.PersistentVolumeClaim) (bool, error) {
+		observe(pvc)
+
+		capacity := pvc.Status.Capacity[corev1.ResourceStorage]
+		return capacity.Cmp(size) >= 0, nil
+	}
+
+	return a.newWorkflow("pvc resize").
+		Step(step{
+			name: "request size",
+			do: func(ctx context.Context) error {
+				pvc, err := a.core.PVC().Get(ctx, ns, conf.PVCName)
+				if err != nil {
+					return err
+				}
+
+				from := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+				report.From = from.String()
+
+				_, err = a.core.PVC().Resize(ctx, ns, conf.PVCName, size)
+
+				return err
+			},
+		}).
+		Step(step{
+			name: "wait for expansion",
+			do: func(ctx context.Context) error {
+				pvc, err := a.core.PVC().WaitFor(ctx, ns, conf.PVCName, resizeTimeoutSec, func(pvc *corev1.PersistentVolumeClaim) (bool, error) {
+					done, err := resized(pvc)
+					return done || fileSystemResizePending(pvc), err
+				})
+				if err != nil {
+					return err
+				}
+
+				if done, _ := resized(pvc); !done {
+					if err = a.finishFileSystemResize(ctx, &report, resized); err != nil {
+						return err
+					}
+				}
+
+				a.result.Data = report
+				a.log.Info("pvc resized", "pvc", conf.PVCName, "capacity", report.Capacity)
+
+				return nil
+			},
+		}).
+		Run(ctx)
+}
+
+// finishFileSystemResize waits for the kubelet to expand the filesystem of a mounted volume online
+// and restarts the consuming pods if it does not, so the filesystem gets expanded when the volume is mounted again
+func (a *App) finishFileSystemResize(ctx context.Context, report *resizeReport, resized func(*corev1.PersistentVolumeClaim) (bool, error)) error {
+	ns, pvcName := a.conf.Namespace, a.conf.PVC.Resize.PVCName
+
+	pods, err := a.core.Pod().ListByPVC(ctx, ns, pvcName)
+	if err != nil {
+		return err
+	}
+
+	var running []corev1.Pod
+	for _, po := range pods {
+		if po.Status.Phase == corev1.PodRunning {
+			running = append(running, po)
+		}
+	}
+
+	if len(running) == 0 {
+		report.FileSystemResizePending = true
+		a.log.Info("filesystem resize pending, the pvc is not mounted", "pvc", pvcName)
+
+		return nil
+	}
+
+	a.log.Info("waiting for the filesystem to be expanded online", "pvc", pvcName, "grace", fmt.Sprintf("%ds", resizeOnlineGraceSec))
+
+	_, err = a.core.PVC().WaitFor(ctx, ns, pvcName, resizeOnlineGraceSec, resized)
+	if err == nil || ctx.Err() != nil || !errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+
+	names := make([]string, 0, len(running))
+	for _, po := range running {
+		names = append(names, po.Name)
+	}
+
+	if !a.conf.PVC.Resize.RestartPod {
+		return core.Errorf(core.ErrNotReady, "the filesystem was not expanded online within %ds, use --restart-pod to restart %s", resizeOnlineGraceSec, strings.Join(names, ", "))
+	}
+
+	for _, po := range running {
+		if metav1.GetControllerOf(&po) == nil {
+			return core.Errorf(core.ErrValidationFailed, "pod %s is not managed by a controller and would not be recreated, restart it manually", po.Name)
+		}
+	}
+
+	for _, po := range running {
+		if err = a.core.Pod().Delete(ctx, ns, po.Name); err != nil {
+			return err
+		}
+		if err = a.core.Pod().WaitDeleted(ctx, ns, po.Name, migrateWaitTimeoutSec); err != nil {
+			return err
+		}
+
+		report.RestartedPods = append(report.RestartedPods, po.Name)
+	}
+
+	_, err = a.core.PVC().WaitFor(ctx, ns, pvcName, resizeTimeoutSec, resized)
+
+	return err
+}
+
+func fileSystemResizePending(pvc *corev1.PersistentVolumeClaim) bool {
+	for _, c := range pvc.Status.Conditions {
+		if c.Type == corev1.PersistentVolumeClaimFileSystemResizePending && c.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+
+	return false
+}
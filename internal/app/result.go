@@ -0,0 +1,183 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/zeljkobenovic/kmon/pkg/config"
+	"sigs.k8s.io/yaml"
+)
+
+type resultStatus string
+
+const (
+	resultSucceeded resultStatus = "succeeded"
+	resultFailed    resultStatus = "failed"
+)
+
+type resourceAction string
+
+const (
+	resourceCreated  resourceAction = "created"
+	resourceReused   resourceAction = "reused"
+	resourceReplaced resourceAction = "replaced"
+	resourceDeleted  resourceAction = "deleted"
+)
+
+// Result is the machine-readable outcome of a command, printed to stdout once the command completes
+type Result struct {
+	Command   string       `json:"command"`
+	Status    resultStatus `json:"status"`
+	Error     string       `json:"error,omitempty"`
+	StartedAt time.Time    `json:"startedAt"`
+	Duration  string       `json:"duration"`
+	Resources []Resource   `json:"resources,omitempty"`
+	Steps     []StepResult `json:"steps,omitempty"`
+	// Data is the command specific outcome, like a directory listing
+	Data any `json:"data,omitempty"`
+
+	// streamed is set by commands writing their output to stdout as it comes, the result is logged instead of printed
+	// so stdout only carries that output
+	streamed bool
+}
+
+// textPrinter is implemented by command specific data which has a human-readable representation
+type textPrinter interface {
+	printText(w io.Writer) error
+}
+
+// Resource is a Kubernetes object created or reused by a command
+type Resource struct {
+	Kind      string         `json:"kind"`
+	Namespace string         `json:"namespace"`
+	Name      string         `json:"name"`
+	Action    resourceAction `json:"action"`
+}
+
+// StepResult is the outcome of a single workflow step
+type StepResult struct {
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	Duration string `json:"duration"`
+	Error    string `json:"error,omitempty"`
+}
+
+func newResult(command string) *Result {
+	return &Result{
+		Command:   command,
+		StartedAt: time.Now(),
+	}
+}
+
+func (r *Result) addResource(kind, namespace, name string, action resourceAction) {
+	r.Resources = append(r.Resources, Resource{Kind: kind, Namespace: namespace, Name: name, Action: action})
+}
+
+// finish records the final status of the command
+func (r *Result) finish(err error) {
+	r.Duration = time.Since(r.StartedAt).Round(time.Millisecond).String()
+	r.Status = resultSucceeded
+
+	if err != nil {
+		r.Status = resultFailed
+		r.Error = err.Error()
+	}
+}
+
+// print writes the result to w in the requested output format
+func (r *Result) print(w io.Writer, format config.OutputFormat) error {
+	switch format {
+	case config.OutputJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+
+		return enc.Encode(r)
+	case config.OutputYAML:
+		out, err := yaml.Marshal(r)
+		if err != nil {
+			return fmt.Errorf("could not marshal result: %w", err)
+		}
+
+		_, err = w.Write(out)
+
+		return err
+	default:
+		return r.printText(w)
+	}
+}
+
+func (r *Result) printText(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "%s %s in %s\n", r.Command, r.Status, r.Duration); err != nil {
+		return err
+	}
+
+	for _, res := range r.Resources {
+		if _, err := fmt.Fprintf(w, "  %s %s %s/%s\n", res.Action, res.Kind, res.Namespace, res.Name); err != nil {
+			return err
+		}
+	}
+
+	if data, ok := r.Data.(textPrinter); ok {
+		return data.printText(w)
+	}
+
+	return nil
+}
+
+// printPanel writes a concise summary for runs from k9s: the outcome, what was created, where and how to access it
+func (r *Result) printPanel(w io.Writer) error {
+	var body bytes.Buffer
+
+	fmt.Fprintf(&body, "%s %s in %s\n", r.Command, r.Status, r.Duration)
+	if r.Error != "" {
+		fmt.Fprintf(&body, "error: %s\n", r.Error)
+	}
+
+	for _, res := range r.Resources {
+		fmt.Fprintf(&body, "%s %s %s/%s\n", res.Action, res.Kind, res.Namespace, res.Name)
+		if hint := accessHint(res); hint != "" {
+			fmt.Fprintf(&body, "  access: %s\n", hint)
+		}
+	}
+
+	if data, ok := r.Data.(textPrinter); ok {
+		if err := data.printText(&body); err != nil {
+			return err
+		}
+	}
+
+	if _, err := fmt.Fprintln(w, "╭─ kmon"); err != nil {
+		return err
+	}
+	for _, line := range strings.Split(strings.TrimRight(body.String(), "\n"), "\n") {
+		if _, err := fmt.Fprintln(w, "│ "+line); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintln(w, "╰─")
+
+	return err
+}
+
+// accessHint tells how to get to a resource the command created or reused
+func accessHint(res Resource) string {
+	if res.Action == resourceDeleted {
+		return ""
+	}
+
+	switch res.Kind {
+	case "pod":
+		return fmt.Sprintf("kubectl exec -it -n %s %s -- sh", res.Namespace, res.Name)
+	case "pvc":
+		return fmt.Sprintf("kmon pvc ls %s -n %s", res.Name, res.Namespace)
+	case "volumesnapshot":
+		return fmt.Sprintf("kmon snapshot ls %s -n %s", res.Name, res.Namespace)
+	default:
+		return ""
+	}
+}
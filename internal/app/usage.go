@@ -0,0 +1,215 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zeljkobenovic/kmon/pkg/kube/core"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// VolumeUsage is the usage of a single pvc
+type VolumeUsage struct {
+	Namespace    string `json:"namespace"`
+	PVC          string `json:"pvc"`
+	StorageClass string `json:"storageClass,omitempty"`
+	Requested    string `json:"requested"`
+	// Capacity, Used and Percent are only known for pvcs mounted by a running pod
+	Capacity       int64      `json:"capacityBytes,omitempty"`
+	Used           int64      `json:"usedBytes,omitempty"`
+	Percent        *float64   `json:"percent,omitempty"`
+	Pods           []string   `json:"pods,omitempty"`
+	LastSnapshot   *time.Time `json:"lastSnapshot,omitempty"`
+	RecentSnapshot bool       `json:"recentSnapshot"`
+
+	requestedBytes int64
+}
+
+// usageReport is the outcome of the pvc usage command
+type usageReport struct {
+	Volumes []VolumeUsage `json:"volumes"`
+}
+
+func (r usageReport) printText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	if _, err := fmt.Fprintln(tw, "NAMESPACE\tPVC\tREQUESTED\tSIZE\tUSED\tUSE%\tSNAPSHOT\tPODS"); err != nil {
+		return err
+	}
+
+	for _, v := range r.Volumes {
+		size, used, percent := "-", "-", "-"
+		if v.Percent != nil {
+			size, used, percent = humanBytes(v.Capacity), humanBytes(v.Used), fmt.Sprintf("%.1f%%", *v.Percent)
+		}
+
+		snapshot := "-"
+		if v.LastSnapshot != nil {
+			snapshot = time.Since(*v.LastSnapshot).Round(time.Minute).String() + " ago"
+			if !v.RecentSnapshot {
+				snapshot += " (stale)"
+			}
+		}
+
+		pods := "-"
+		if len(v.Pods) > 0 {
+			pods = strings.Join(v.Pods, ",")
+		}
+
+		if _, err := fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", v.Namespace, v.PVC, v.Requested, size, used, percent, snapshot, pods); err != nil {
+			return err
+		}
+	}
+
+	return tw.Flush()
+}
+
+func (a *App) PVCUsageCmdHandler() error {
+	return a.withContext("pvc usage", a.pvcUsage)
+}
+
+// pvcUsage joins the pvcs with their consuming pods, the volume stats of the nodes running those pods
+// and the snapshots kmon took of them
+func (a *App) pvcUsage(ctx context.Context) error {
+	conf := a.conf.PVC.Usage
+
+	switch conf.Sort {
+	case "percent", "used", "capacity", "name":
+	default:
+		return core.Errorf(core.ErrValidationFailed, "invalid --sort %q, expected one of: percent, used, capacity, name", conf.Sort)
+	}
+
+	namespace := a.conf.Namespace
+	if conf.AllNamespaces {
+		namespace = ""
+	}
+
+	pvcs, err := a.core.PVC().List(ctx, namespace)
+	if err != nil {
+		return err
+	}
+
+	pods, err := a.core.Pod().List(ctx, namespace)
+	if err != nil {
+		return err
+	}
+
+	snapshots, err := a.core.PVC().ListVolumeSnapshots(ctx, namespace)
+	if err != nil {
+		return err
+	}
+
+	consumers := map[string][]string{}
+	nodes := map[string]bool{}
+	for _, po := range pods {
+		if po.Status.Phase != corev1.PodRunning {
+			continue
+		}
+
+		for _, name := range core.PVCNames(po.Spec) {
+			key := po.Namespace + "/" + name
+			consumers[key] = append(consumers[key], po.Name)
+			nodes[po.Spec.NodeName] = true
+		}
+	}
+
+	stats := map[string]core.VolumeStats{}
+	for nodeName := range nodes {
+		nodeStats, err := a.core.Node().VolumeStats(ctx, nodeName)
+		if err != nil {
+			a.log.Warn("skipping node without stats", "node", nodeName, "err", err)
+			continue
+		}
+
+		for _, s := range nodeStats {
+			stats[s.Namespace+"/"+s.PVCName] = s
+		}
+	}
+
+	lastSnapshots := map[string]time.Time{}
+	for _, vs := range snapshots {
+		if vs.Labels[core.ManagedByLabel] != core.ManagedBy || vs.Spec.Source.PersistentVolumeClaimName == nil {
+			continue
+		}
+		if vs.Status == nil || vs.Status.ReadyToUse == nil || !*vs.Status.ReadyToUse {
+			continue
+		}
+
+		key := vs.Namespace + "/" + *vs.Spec.Source.PersistentVolumeClaimName
+		if created := vs.CreationTimestamp.Time; created.After(lastSnapshots[key]) {
+			lastSnapshots[key] = created
+		}
+	}
+
+	var volumes []VolumeUsage
+	for _, pvc := range pvcs {
+		key := pvc.Namespace + "/" + pvc.Name
+		requested := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+
+		v := VolumeUsage{
+			Namespace: pvc.Namespace,
+			PVC:       pvc.Name,
+			Requested: requested.String(),
+			Pods:      consumers[key],
+
+			requestedBytes: requested.Value(),
+		}
+		if pvc.Spec.StorageClassName != nil {
+			v.StorageClass = *pvc.Spec.StorageClassName
+		}
+		if s, ok := stats[key]; ok && s.CapacityBytes > 0 {
+			percent := float64(s.UsedBytes) / float64(s.CapacityBytes) * 100
+			v.Capacity, v.Used, v.Percent = s.CapacityBytes, s.UsedBytes, &percent
+		}
+		if last, ok := lastSnapshots[key]; ok {
+			v.LastSnapshot = &last
+			v.RecentSnapshot = time.Since(last) <= conf.SnapshotMaxAge
+		}
+
+		if conf.Threshold > 0 && (v.Percent == nil || *v.Percent < conf.Threshold) {
+			continue
+		}
+
+		volumes = append(volumes, v)
+	}
+
+	sortUsage(volumes, conf.Sort)
+
+	a.result.Data = usageReport{Volumes: volumes}
+	a.log.Info("collected pvc usage", "pvcs", len(volumes), "nodes", len(nodes))
+
+	return nil
+}
+
+// sortUsage sorts the fullest volumes first, volumes without stats last, and by name otherwise
+func sortUsage(volumes []VolumeUsage, by string) {
+	percent := func(v VolumeUsage) float64 {
+		if v.Percent == nil {
+			return -1
+		}
+
+		return *v.Percent
+	}
+
+	sort.SliceStable(volumes, func(i, j int) bool {
+		vi, vj := volumes[i], volumes[j]
+
+		switch {
+		case by == "percent" && percent(vi) != percent(vj):
+			return percent(vi) > percent(vj)
+		case by == "used" && vi.Used != vj.Used:
+			return vi.Used > vj.Used
+		case by == "capacity" && vi.requestedBytes != vj.requestedBytes:
+			return vi.requestedBytes > vj.requestedBytes
+		case vi.Namespace != vj.Namespace:
+			return vi.Namespace < vj.Namespace
+		default:
+			return vi.PVC < vj.PVC
+		}
+	})
+}
@@ -0,0 +1,195 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/zeljkobenovic/kmon/pkg/kube/core"
+)
+
+// step is a single unit of work within a workflow.
+// Undo and wait are optional, undo is registered as soon as do succeeds,
+// so a failing wait condition rolls back the step itself as well.
+// Temporary steps, like inspection pods, are undone once the workflow completes, even if it succeeded
+type step struct {
+	name      string
+	do        func(ctx context.Context) error
+	undo      func(ctx context.Context) error
+	wait      func(ctx context.Context) error
+	temporary bool
+}
+
+type stepStatus string
+
+const (
+	stepPending        stepStatus = "pending"
+	stepDone           stepStatus = "done"
+	stepFailed         stepStatus = "failed"
+	stepRolledBack     stepStatus = "rolled back"
+	stepRollbackFailed stepStatus = "rollback failed"
+	stepCleanedUp      stepStatus = "cleaned up"
+)
+
+type stepResult struct {
+	name     string
+	status   stepStatus
+	duration time.Duration
+	err      error
+}
+
+// workflow runs steps in order and, if any of them fails or the context gets cancelled,
+// undoes the already completed steps in reverse order
+type workflow struct {
+	name    string
+	log     *slog.Logger
+	cleanup core.CleanupManager
+	result  *Result
+	steps   []step
+	results []*stepResult
+	// rollbackTimeout bounds the rollback, if the undo actions wait longer than core.CleanupTimeout
+	rollbackTimeout time.Duration
+}
+
+func (a *App) newWorkflow(name string) *workflow {
+	return &workflow{
+		name:    name,
+		log:     a.log.With("workflow", name),
+		cleanup: a.core.Cleanup(),
+		result:  a.result,
+	}
+}
+
+// Step appends a step to the workflow
+func (w *workflow) Step(s step) *workflow {
+	w.steps = append(w.steps, s)
+	return w
+}
+
+// RollbackTimeout allows the rollback to run for the timeout, for workflows whose undo actions wait on the cluster
+func (w *workflow) RollbackTimeout(timeout time.Duration) *workflow {
+	w.rollbackTimeout = timeout
+	return w
+}
+
+// Run executes all steps and logs a step by step summary once finished
+func (w *workflow) Run(ctx context.Context) error {
+	if w.rollbackTimeout > 0 {
+		w.cleanup.SetTimeout(w.rollbackTimeout)
+	}
+
+	w.results = make([]*stepResult, len(w.steps))
+	for i, s := range w.steps {
+		w.results[i] = &stepResult{name: s.name, status: stepPending}
+	}
+
+	defer w.summary()
+
+	for i, s := range w.steps {
+		if err := w.runStep(ctx, s, w.results[i]); err != nil {
+			w.log.Error("step failed, rolling back", "step", s.name, "err", err)
+
+			if rbErr := w.cleanup.Run(); rbErr != nil {
+				return fmt.Errorf("%s failed: %w, rollback failed: %w", s.name, err, rbErr)
+			}
+
+			return fmt.Errorf("%s failed: %w", s.name, err)
+		}
+	}
+
+	w.cleanup.Discard()
+
+	return w.teardown(ctx)
+}
+
+// teardown undoes the temporary steps of a successfully completed workflow in reverse order
+func (w *workflow) teardown(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), core.CleanupTimeout)
+	defer cancel()
+
+	var errs []error
+	for i := len(w.steps) - 1; i >= 0; i-- {
+		s, res := w.steps[i], w.results[i]
+		if !s.temporary || s.undo == nil {
+			continue
+		}
+
+		w.log.Info("cleaning up", "step", s.name)
+
+		if err := s.undo(ctx); err != nil {
+			res.status, res.err = stepRollbackFailed, err
+			errs = append(errs, fmt.Errorf("%s cleanup failed: %w", s.name, err))
+			continue
+		}
+
+		res.status = stepCleanedUp
+	}
+
+	return errors.Join(errs...)
+}
+
+func (w *workflow) runStep(ctx context.Context, s step, res *stepResult) error {
+	start := time.Now()
+	defer func() { res.duration = time.Since(start) }()
+
+	w.log.Info("running step", "step", s.name)
+
+	if err := ctx.Err(); err != nil {
+		res.status, res.err = stepFailed, context.Cause(ctx)
+		return res.err
+	}
+
+	if err := s.do(ctx); err != nil {
+		res.status, res.err = stepFailed, err
+		return err
+	}
+
+	if s.undo != nil {
+		w.cleanup.Register(s.name, func(ctx context.Context) error {
+			if err := s.undo(ctx); err != nil {
+				res.status, res.err = stepRollbackFailed, err
+				return err
+			}
+
+			res.status = stepRolledBack
+			return nil
+		})
+	}
+
+	if s.wait != nil {
+		if err := s.wait(ctx); err != nil {
+			res.status, res.err = stepFailed, err
+			return err
+		}
+	}
+
+	res.status = stepDone
+
+	return nil
+}
+
+func (w *workflow) summary() {
+	for i, res := range w.results {
+		attrs := []any{
+			"step", fmt.Sprintf("%d/%d %s", i+1, len(w.results), res.name),
+			"status", res.status,
+			"duration", res.duration.Round(time.Millisecond).String(),
+		}
+		if res.err != nil {
+			attrs = append(attrs, "err", res.err)
+		}
+
+		w.log.Info("workflow summary", attrs...)
+
+		if w.result != nil {
+			sr := StepResult{Name: res.name, Status: string(res.status), Duration: res.duration.Round(time.Millisecond).String()}
+			if res.err != nil {
+				sr.Error = res.err.Error()
+			}
+
+			w.result.Steps = append(w.result.Steps, sr)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package archive
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// A backup is a zstd compressed tar stream, holding the volume content below DataDir
+// and a ManifestName entry describing it. The stream is written as a sequence of independent zstd frames,
+// one per chunk, so an interrupted backup can be resumed by truncating the file to the end of the last complete frame
+const (
+	ManifestName = "kmon-manifest.json"
+	DataDir      = "data"
+)
+
+// AppendFrame writes the tar entries added by fn as a single, complete zstd frame.
+// The tar stream is left open, so further frames continue it
+func AppendFrame(w io.Writer, fn func(tw *tar.Writer) error) error {
+	enc, err := zstd.NewWriter(w)
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(enc)
+	if err = fn(tw); err != nil {
+		_ = enc.Close()
+		return err
+	}
+
+	if err = tw.Flush(); err != nil {
+		_ = enc.Close()
+		return err
+	}
+
+	return enc.Close()
+}
+
+// FinishBackup writes the manifest and closes the tar stream in the final zstd frame
+func FinishBackup(w io.Writer, manifest []byte) error {
+	enc, err := zstd.NewWriter(w)
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(enc)
+	err = tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     ManifestName,
+		Mode:     0o644,
+		Size:     int64(len(manifest)),
+	})
+	if err == nil {
+		_, err = tw.Write(manifest)
+	}
+	if err == nil {
+		err = tw.Close()
+	}
+	if err != nil {
+		_ = enc.Close()
+		return err
+	}
+
+	return enc.Close()
+}
+
+// CopyEntries copies the entries of the tar stream r into tw below DataDir,
+// feeding the content of regular files into h, used to checksum the backup
+func CopyEntries(tw *tar.Writer, r io.Reader, h hash.Hash) (Stats, error) {
+	var stats Stats
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return stats, nil
+		}
+		if err != nil {
+			return stats, fmt.Errorf("could not read archive: %w", err)
+		}
+
+		rel, ok := relativeTo(".", hdr.Name)
+		if !ok || rel == "." {
+			stats.Skipped++
+			continue
+		}
+
+		hdr.Name = path.Join(DataDir, rel)
+		if hdr.Typeflag == tar.TypeDir {
+			hdr.Name += "/"
+		}
+		if hdr.Typeflag == tar.TypeLink {
+			target, ok := relativeTo(".", hdr.Linkname)
+			if !ok {
+				stats.Skipped++
+				continue
+			}
+
+			hdr.Linkname = path.Join(DataDir, target)
+		}
+
+		if err = tw.WriteHeader(hdr); err != nil {
+			return stats, err
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			stats.Dirs++
+		case tar.TypeReg:
+			n, err := io.Copy(io.MultiWriter(tw, h), tr)
+			if err != nil {
+				return stats, err
+			}
+
+			stats.Files++
+			stats.Bytes += n
+		}
+	}
+}
+
+// ReadBackup decompresses the backup, calling fn with every data entry, named relative to DataDir,
+// and returns the content of the manifest
+func ReadBackup(r io.Reader, fn func(hdr *tar.Header, content io.Reader) error) ([]byte, error) {
+	dec, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer dec.Close()
+
+	var manifest []byte
+
+	tr := tar.NewReader(dec)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read backup: %w", err)
+		}
+
+		if hdr.Name == ManifestName {
+			if manifest, err = io.ReadAll(tr); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		rel, ok := relativeTo(DataDir, hdr.Name)
+		if !ok || rel == "." || strings.HasPrefix(rel, "/") {
+			continue
+		}
+
+		hdr.Name = rel
+		if hdr.Typeflag == tar.TypeLink {
+			if hdr.Linkname, ok = relativeTo(DataDir, hdr.Linkname); !ok {
+				continue
+			}
+		}
+
+		if err = fn(hdr, tr); err != nil {
+			return nil, err
+		}
+	}
+
+	if manifest == nil {
+		return nil, fmt.Errorf("backup has no manifest, it might be incomplete")
+	}
+
+	return manifest, nil
+}
@@ -0,0 +1,184 @@
+package archive
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Stats summarizes the content of an archive written or extracted
+type Stats struct {
+	Files   int   `json:"files"`
+	Dirs    int   `json:"dirs"`
+	Bytes   int64 `json:"bytes"`
+	Skipped int   `json:"skipped,omitempty"`
+}
+
+// Write archives the file or the directory at src into w as a tar stream,
+// naming the archive root entry root. A root of "." stores the directory content without a parent entry
+func Write(w io.Writer, src, root string) (Stats, error) {
+	var stats Stats
+
+	tw := tar.NewWriter(w)
+
+	err := filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		var link string
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		}
+
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+
+		hdr.Name = path.Join(root, filepath.ToSlash(rel))
+		if d.IsDir() {
+			hdr.Name += "/"
+		}
+
+		switch {
+		case d.IsDir():
+			stats.Dirs++
+		case info.Mode().IsRegular():
+			stats.Files++
+			stats.Bytes += info.Size()
+		case info.Mode()&fs.ModeSymlink != 0:
+		default:
+			stats.Skipped++
+			return nil
+		}
+
+		if err = tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(tw, f)
+
+		return err
+	})
+	if err != nil {
+		return stats, fmt.Errorf("could not archive %s: %w", src, err)
+	}
+
+	return stats, tw.Close()
+}
+
+// Extract unpacks a tar stream into dest, placing the archive root entry root at dest itself.
+// Entries outside root are ignored, while symlinks and special files are skipped,
+// so the archive content can never be written outside dest
+func Extract(r io.Reader, root, dest string) (Stats, error) {
+	var stats Stats
+
+	root = path.Clean(strings.TrimPrefix(root, "/"))
+	tr := tar.NewReader(r)
+
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return stats, nil
+		}
+		if err != nil {
+			return stats, fmt.Errorf("could not read archive: %w", err)
+		}
+
+		rel, ok := relativeTo(root, hdr.Name)
+		if !ok {
+			continue
+		}
+
+		target := filepath.Join(dest, filepath.FromSlash(rel))
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err = os.MkdirAll(target, hdr.FileInfo().Mode().Perm()|0o700); err != nil {
+				return stats, err
+			}
+
+			stats.Dirs++
+		case tar.TypeReg:
+			if err = extractFile(tr, target, hdr); err != nil {
+				return stats, err
+			}
+
+			stats.Files++
+			stats.Bytes += hdr.Size
+		default:
+			stats.Skipped++
+		}
+	}
+}
+
+// relativeTo returns the path of the entry name relative to root,
+// or false if the entry is outside root or would escape the destination directory
+func relativeTo(root, name string) (string, bool) {
+	name = path.Clean(strings.TrimPrefix(name, "/"))
+
+	var rel string
+	switch {
+	case root == ".":
+		rel = name
+	case name == root:
+		rel = "."
+	case strings.HasPrefix(name, root+"/"):
+		rel = strings.TrimPrefix(name, root+"/")
+	default:
+		return "", false
+	}
+
+	if rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", false
+	}
+
+	return rel, true
+}
+
+func extractFile(r io.Reader, target string, hdr *tar.Header) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, hdr.FileInfo().Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(f, r); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("could not write %s: %w", target, err)
+	}
+
+	return f.Close()
+}
@@ -2,14 +2,39 @@ package config
 
 import (
 	"log/slog"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
 type Runner interface {
+	// PreRun is called once the flags and the config file are parsed, before any of the handlers
+	PreRun() error
 	PodCmdHandler() error
+	PodForwardCmdHandler() error
 	PVCCmdHandler() error
+	PVCCopyCmdHandler() error
+	PVCListCmdHandler() error
+	SnapshotListCmdHandler() error
+	SnapshotDiffCmdHandler() error
+	PVCBackupCmdHandler() error
+	PVCRestoreArchiveCmdHandler() error
+	PVCMigrateCmdHandler() error
+	PVCResizeCmdHandler() error
+	PVCUsageCmdHandler() error
+	PVCOrphansCmdHandler() error
+	PVCDescribeCmdHandler() error
+	PVRebindCmdHandler() error
+	K9sInstallCmdHandler() error
+	AuthCheckCmdHandler() error
+	AuthRBACCmdHandler() error
+	DoctorCmdHandler() error
+	ExecCmdHandler() error
+	LogsCmdHandler() error
+	NetMatrixCmdHandler() error
+	NetDNSCmdHandler() error
 }
 
 type Config struct {
@@ -17,13 +42,69 @@ type Config struct {
 	podCmd  *cobra.Command
 	pvcCmd  *cobra.Command
 
+	snapshotCmd *cobra.Command
+	pvCmd       *cobra.Command
+	k9sCmd      *cobra.Command
+	authCmd     *cobra.Command
+	doctorCmd   *cobra.Command
+	execCmd     *cobra.Command
+	logsCmd     *cobra.Command
+	netCmd      *cobra.Command
+
+	podForwardCmd   *cobra.Command
+	netMatrixCmd    *cobra.Command
+	netDNSCmd       *cobra.Command
+	pvcCpCmd        *cobra.Command
+	pvcLsCmd        *cobra.Command
+	snapshotLsCmd   *cobra.Command
+	snapshotDiffCmd *cobra.Command
+
+	pvcBackupCmd         *cobra.Command
+	pvcRestoreArchiveCmd *cobra.Command
+	pvcMigrateCmd        *cobra.Command
+	pvcResizeCmd         *cobra.Command
+	pvcUsageCmd          *cobra.Command
+	pvcOrphansCmd        *cobra.Command
+	pvcDescribeCmd       *cobra.Command
+	pvRebindCmd          *cobra.Command
+	k9sInstallCmd        *cobra.Command
+	authCheckCmd         *cobra.Command
+	authRBACCmd          *cobra.Command
+
 	log        *slog.Logger
 	configPath string
 
-	Context   string `mapstructure:"context"`
-	Namespace string `mapstructure:"namespace"`
-	Pod       Pod    `mapstructure:"pod"`
-	PVC       PVC    `mapstructure:"pvc"`
+	runner Runner
+
+	Context      string        `mapstructure:"context"`
+	Namespace    string        `mapstructure:"namespace"`
+	Timeout      time.Duration `mapstructure:"timeout"`
+	Force        bool          `mapstructure:"force"`
+	GenerateName bool          `mapstructure:"generate_name"`
+	Output       OutputFormat  `mapstructure:"output"`
+	LogFormat    string        `mapstructure:"log_format"`
+	LogLevel     string        `mapstructure:"log_level"`
+	Pod          Pod           `mapstructure:"pod"`
+	PVC          PVC           `mapstructure:"pvc"`
+	Snapshot     Snapshot      `mapstructure:"snapshot"`
+	PV           PV            `mapstructure:"pv"`
+	K9s          K9s           `mapstructure:"k9s"`
+	Auth         Auth          `mapstructure:"auth"`
+	Exec         Exec          `mapstructure:"exec"`
+	Logs         Logs          `mapstructure:"logs"`
+	Net          Net           `mapstructure:"net"`
+}
+
+type OutputFormat string
+
+var (
+	OutputText OutputFormat = "text"
+	OutputJSON OutputFormat = "json"
+	OutputYAML OutputFormat = "yaml"
+)
+
+func (o *OutputFormat) stringPtr() *string {
+	return (*string)(o)
 }
 
 type PodOperationMode string
@@ -54,6 +135,16 @@ type Pod struct {
 	VolumeName   string           `mapstructure:"volume_name"`
 	PVCName      string           `mapstructure:"pvc_name"`
 	SnapshotName string           `mapstructure:"snapshot_name"`
+	Forward      PodForward       `mapstructure:"forward"`
+}
+
+// PodForward configures forwarding the local Ports on Address to a ready pod matching Selector,
+// or to all of them in turn with RoundRobin
+type PodForward struct {
+	Selector   string   `mapstructure:"selector"`
+	Ports      []string `mapstructure:"ports"`
+	Address    string   `mapstructure:"address"`
+	RoundRobin bool     `mapstructure:"round_robin"`
 }
 
 type PVC struct {
@@ -62,6 +153,169 @@ type PVC struct {
 	SnapshotClassName string           `mapstructure:"snapshot_class_name"`
 	SourcePVCName     string           `mapstructure:"source_pvc_name"`
 	SnapshotName      string           `mapstructure:"snapshot_name"`
+	Copy              PVCCopy          `mapstructure:"copy"`
+	List              List             `mapstructure:"list"`
+	Archive           PVCArchive       `mapstructure:"archive"`
+	Migrate           PVCMigrate       `mapstructure:"migrate"`
+	Resize            PVCResize        `mapstructure:"resize"`
+	Usage             PVCUsage         `mapstructure:"usage"`
+	Orphans           PVCOrphans       `mapstructure:"orphans"`
+	Describe          PVCDescribe      `mapstructure:"describe"`
+}
+
+type PV struct {
+	Rebind PVRebind `mapstructure:"rebind"`
+}
+
+// PVRebind configures binding the PersistentVolume PVName to a new PVC ClaimName
+type PVRebind struct {
+	PVName    string `mapstructure:"pv_name"`
+	ClaimName string `mapstructure:"claim_name"`
+}
+
+type Snapshot struct {
+	List List         `mapstructure:"list"`
+	Diff SnapshotDiff `mapstructure:"diff"`
+}
+
+// SnapshotDiff configures the comparison of the Old snapshot against either the New snapshot or a live PVC
+type SnapshotDiff struct {
+	Old        string `mapstructure:"old"`
+	New        string `mapstructure:"new"`
+	AgainstPVC string `mapstructure:"against_pvc"`
+	Path       string `mapstructure:"path"`
+}
+
+// List configures the listing of a volume content, Target being the name of a PVC or a VolumeSnapshot
+type List struct {
+	Target    string `mapstructure:"target"`
+	Path      string `mapstructure:"path"`
+	Recursive bool   `mapstructure:"recursive"`
+	DiskUsage bool   `mapstructure:"disk_usage"`
+}
+
+type PVCCopy struct {
+	Source       string `mapstructure:"source"`
+	Destination  string `mapstructure:"destination"`
+	FromSnapshot bool   `mapstructure:"from_snapshot"`
+}
+
+// PVCArchive configures the backup of a PVC into a local archive File and its restore into a new PVC called Name
+type PVCArchive struct {
+	PVCName          string `mapstructure:"pvc_name"`
+	Name             string `mapstructure:"name"`
+	File             string `mapstructure:"file"`
+	ChunkSize        string `mapstructure:"chunk_size"`
+	StorageClassName string `mapstructure:"storage_class_name"`
+}
+
+// PVCMigrate configures the move of a PVC and its data to the ToStorageClass
+type PVCMigrate struct {
+	PVCName        string `mapstructure:"pvc_name"`
+	ToStorageClass string `mapstructure:"to_storage_class"`
+}
+
+// PVCResize configures the expansion of a PVC to Size, RestartPod allowing to restart its consumers
+// when the filesystem can only be expanded offline
+type PVCResize struct {
+	PVCName    string `mapstructure:"pvc_name"`
+	Size       string `mapstructure:"size"`
+	RestartPod bool   `mapstructure:"restart_pod"`
+}
+
+// PVCUsage configures the usage report, limited to the volumes at least Threshold percent full
+type PVCUsage struct {
+	AllNamespaces  bool          `mapstructure:"all_namespaces"`
+	Sort           string        `mapstructure:"sort"`
+	Threshold      float64       `mapstructure:"threshold"`
+	SnapshotMaxAge time.Duration `mapstructure:"snapshot_max_age"`
+}
+
+// PVCOrphans configures the search for unused storage objects of the Kinds, all of them if empty, and only the ones
+// created by kmon with KmonOnly. They are deleted if Delete is set once confirmed, or right away with Yes.
+// Snapshots and pvcs not created by kmon are only deleted if their kind is listed in Kinds,
+// retained volumes and snapshot contents only with IncludeRetained
+type PVCOrphans struct {
+	AllNamespaces   bool     `mapstructure:"all_namespaces"`
+	Kinds           []string `mapstructure:"kinds"`
+	KmonOnly        bool     `mapstructure:"kmon_only"`
+	Delete          bool     `mapstructure:"delete"`
+	IncludeRetained bool     `mapstructure:"include_retained"`
+	Yes             bool     `mapstructure:"yes"`
+}
+
+type K9s struct {
+	// Plugin is set for runs from a k9s plugin, which show a result panel and wait for a keypress on failure
+	Plugin  bool       `mapstructure:"plugin"`
+	Install K9sInstall `mapstructure:"install"`
+}
+
+// K9sInstall configures generating the k9s plugins, merged into File or printed if Print is set
+type K9sInstall struct {
+	File  string `mapstructure:"file"`
+	Shell string `mapstructure:"shell"`
+	Print bool   `mapstructure:"print"`
+}
+
+// Auth configures reviewing the permissions of Command, written as shown in the result, e.g. "pvc migrate"
+type Auth struct {
+	Command string `mapstructure:"command"`
+}
+
+// Exec configures running Command in the containers of the pods matching Selector, at most Parallel at once.
+// The default container of every pod is used, unless Container or AllContainers is set
+type Exec struct {
+	Selector      string   `mapstructure:"selector"`
+	Container     string   `mapstructure:"container"`
+	AllContainers bool     `mapstructure:"all_containers"`
+	Parallel      int      `mapstructure:"parallel"`
+	Command       []string `mapstructure:"command"`
+}
+
+// Logs configures streaming the logs of the pods matching Selector, newer than Since if it is set.
+// Lines are kept if they match Grep and, for JSON lines, the key=value Fields. Dir writes a support bundle
+// instead of printing the logs
+type Logs struct {
+	Selector string        `mapstructure:"selector"`
+	Follow   bool          `mapstructure:"follow"`
+	Since    time.Duration `mapstructure:"since"`
+	Grep     string        `mapstructure:"grep"`
+	Fields   []string      `mapstructure:"fields"`
+	Dir      string        `mapstructure:"dir"`
+}
+
+// Net configures the network checks, every probe giving up after ProbeTimeout
+type Net struct {
+	ProbeTimeout time.Duration `mapstructure:"probe_timeout"`
+	Matrix       NetMatrix     `mapstructure:"matrix"`
+	DNS          NetDNS        `mapstructure:"dns"`
+}
+
+// NetMatrix configures probing the pods matching TargetSelector on Port with the Checks, and resolving the DNSNames,
+// from the pods matching Selector, from netshoot containers added to them with Ephemeral,
+// or from a netshoot pod with the ProbeLabels with Launch
+type NetMatrix struct {
+	Selector        string            `mapstructure:"selector"`
+	Ephemeral       bool              `mapstructure:"ephemeral"`
+	Launch          bool              `mapstructure:"launch"`
+	ProbeLabels     map[string]string `mapstructure:"probe_labels"`
+	TargetSelector  string            `mapstructure:"target_selector"`
+	TargetNamespace string            `mapstructure:"target_namespace"`
+	Port            int               `mapstructure:"port"`
+	Checks          []string          `mapstructure:"checks"`
+	HTTPPath        string            `mapstructure:"http_path"`
+	DNSNames        []string          `mapstructure:"dns_names"`
+}
+
+// NetDNS configures resolving Name from a netshoot pod, scheduled on Node if set
+type NetDNS struct {
+	Name string `mapstructure:"name"`
+	Node string `mapstructure:"node"`
+}
+
+// PVCDescribe configures printing the storage lineage of the PVC PVCName
+type PVCDescribe struct {
+	PVCName string `mapstructure:"pvc_name"`
 }
 
 func NewConfig(log *slog.Logger) (*Config, error) {
@@ -79,19 +333,16 @@ Some examples:
 and the list goes on...
 `,
 		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
-			if c.rootCmd.PersistentFlags().Changed("config") {
-				if err := viper.BindPFlag("config", c.rootCmd.PersistentFlags().Lookup("config")); err != nil {
-					return err
-				}
-
-				viper.SetConfigFile(viper.GetString("config"))
-				if err := viper.ReadInConfig(); err != nil {
-					return err
-				}
+			if err := c.readConfigFile(); err != nil {
+				return err
+			}
 
-				c.log.Info("using config file", "file", viper.ConfigFileUsed())
+			if err := c.runner.PreRun(); err != nil {
+				return err
+			}
 
-				return viper.Unmarshal(&c)
+			if file := viper.ConfigFileUsed(); file != "" {
+				c.log.Info("using config file", "file", file)
 			}
 
 			return nil
@@ -111,15 +362,292 @@ and the list goes on...
 	}
 
 	c.rootCmd.AddCommand(c.podCmd)
+
+	c.podForwardCmd = &cobra.Command{
+		Use:   "forward",
+		Short: "Forward local ports to the pods matching a selector",
+		Long: "Forward local ports to a ready pod matching the selector through the port-forward API, " +
+			"or to all ready pods in turn with --round-robin. Lost pods are replaced by the next ready pod while " +
+			"the local ports stay bound. Runs until interrupted, unless --timeout is set",
+		Example: `kmon pod forward --selector app=web --port 8080
+kmon pod forward --selector app=db --port 15432:5432 --round-robin -n db`,
+		Args: cobra.NoArgs,
+	}
+	c.podCmd.AddCommand(c.podForwardCmd)
+	c.pvcCpCmd = &cobra.Command{
+		Use:   "cp <src> <dst>",
+		Short: "Copy files in and out of a PVC",
+		Long:  "Copy files in and out of a PVC through a temporary inspection pod. Volume paths are written as <pvc>:<path>",
+		Example: `kmon pvc cp data-pvc:/etc/app/config.yaml ./config.yaml
+kmon pvc cp ./fixed-config data-pvc:/etc/app
+kmon pvc cp --from-snapshot nightly-snap:/var/lib/db/corrupt.db ./corrupt.db`,
+		Args: cobra.ExactArgs(2),
+	}
+
+	c.pvcLsCmd = &cobra.Command{
+		Use:     "ls <pvc> [path]",
+		Short:   "List the content of a PVC",
+		Long:    "List the content of a PVC through a temporary inspection pod",
+		Example: "kmon pvc ls data-pvc /var/lib -R --du",
+		Args:    cobra.RangeArgs(1, 2),
+	}
+
+	c.snapshotCmd = &cobra.Command{
+		Use:  "snapshot",
+		Long: "Kubernetes operations on VolumeSnapshots",
+	}
+
+	c.snapshotLsCmd = &cobra.Command{
+		Use:     "ls <snapshot> [path]",
+		Short:   "List the content of a VolumeSnapshot",
+		Long:    "List the content of a VolumeSnapshot, restored into a temporary PVC, through a temporary inspection pod",
+		Example: "kmon snapshot ls nightly-snap /var/lib -o json",
+		Args:    cobra.RangeArgs(1, 2),
+	}
+
+	c.snapshotDiffCmd = &cobra.Command{
+		Use:   "diff <snapshot> [snapshot]",
+		Short: "Show the files added, removed and changed between two VolumeSnapshots",
+		Long: "Restore both VolumeSnapshots into temporary PVCs, mount them read-only into a single inspection pod " +
+			"and report the files added, removed and changed with their sizes and checksums",
+		Example: `kmon snapshot diff nightly-snap-1 nightly-snap-2
+kmon snapshot diff nightly-snap-2 --against-pvc data-pvc --path /var/lib/db`,
+		Args: cobra.RangeArgs(1, 2),
+	}
+
+	c.pvcBackupCmd = &cobra.Command{
+		Use:   "backup <pvc>",
+		Short: "Back up the content of a PVC into a local archive",
+		Long: "Stream the content of a PVC through a temporary inspection pod into a zstd compressed tar archive, " +
+			"along with a manifest holding the source PVC spec, size and checksum. " +
+			"The archive is written in chunks, so an interrupted backup is resumed when run again with the same file",
+		Example: "kmon pvc backup data-pvc -f data-pvc.tar.zst --chunk-size 1Gi",
+		Args:    cobra.ExactArgs(1),
+	}
+
+	c.pvcRestoreArchiveCmd = &cobra.Command{
+		Use:   "restore-archive",
+		Short: "Restore a local archive into a new PVC",
+		Long: "Verify the checksum of an archive written by pvc backup, create a new PVC sized after the source PVC " +
+			"and extract the archive into it through a temporary inspection pod",
+		Example: "kmon pvc restore-archive -f data-pvc.tar.zst --name data-pvc-restored",
+		Args:    cobra.NoArgs,
+	}
+
+	c.pvcMigrateCmd = &cobra.Command{
+		Use:   "migrate <pvc>",
+		Short: "Move a PVC and its data to a different StorageClass",
+		Long: "Scale down the Deployments and StatefulSets using the PVC, copy its data into a new PVC of the target StorageClass, " +
+			"swap the PVC names so the consumers pick up the new volume and scale them back up. " +
+			"The old PersistentVolume is retained, and any failure rolls the PVC back onto it",
+		Example: "kmon pvc migrate data-pvc --to-storage-class fast-ssd --timeout 1h",
+		Args:    cobra.ExactArgs(1),
+	}
+
+	c.pvcResizeCmd = &cobra.Command{
+		Use:   "resize <pvc>",
+		Short: "Expand a PVC and wait for the new size to become visible",
+		Long: "Check that the StorageClass allows volume expansion, request the new size and follow the resize conditions " +
+			"until the new capacity is reported. Volumes which can only be expanded offline are finished by restarting " +
+			"the consuming pods, if --restart-pod is set",
+		Example: "kmon pvc resize data-pvc --size 50Gi --restart-pod",
+		Args:    cobra.ExactArgs(1),
+	}
+
+	c.pvcUsageCmd = &cobra.Command{
+		Use:   "usage",
+		Short: "Report the filesystem usage of PVCs",
+		Long: "Report the requested capacity, the filesystem usage read from the kubelet stats summary, the consuming pods " +
+			"and whether a recent kmon snapshot exists for every PVC. Only mounted PVCs report their usage",
+		Example: `kmon pvc usage -A --threshold 80
+kmon pvc usage -n db --sort used -o json`,
+		Args: cobra.NoArgs,
+	}
+
+	c.pvcOrphansCmd = &cobra.Command{
+		Use:   "orphans",
+		Short: "Find unused PVCs, PersistentVolumes, VolumeSnapshots and VolumeSnapshotContents",
+		Long: "Report PVCs not mounted by any pod or workload template, Released and Available PersistentVolumes, " +
+			"VolumeSnapshots whose source PVC no longer exists and VolumeSnapshotContents without a bound VolumeSnapshot, " +
+			"with their size and age. Cluster scoped objects are limited to the ones referring to the namespace, unless -A is set",
+		Example: `kmon pvc orphans -A
+kmon pvc orphans -n staging --kmon-only --delete
+kmon pvc orphans -n staging --kind pv --include-retained --delete`,
+		Args: cobra.NoArgs,
+	}
+
+	c.pvcDescribeCmd = &cobra.Command{
+		Use:   "describe <pvc>",
+		Short: "Show the storage lineage of a PVC with binding, attachment status and recent events",
+		Long: "Print a tree of the PVC, its PersistentVolume, the CSI driver and volume handle, the VolumeAttachments to nodes, " +
+			"the consuming pods, the VolumeSnapshots taken from the PVC and the PVCs restored from those snapshots, " +
+			"with their status and recent events",
+		Example: "kmon pvc describe data-pvc -n db",
+		Args:    cobra.ExactArgs(1),
+	}
+
+	c.pvCmd = &cobra.Command{
+		Use:  "pv",
+		Long: "Kubernetes operations on PersistentVolumes",
+	}
+
+	c.pvRebindCmd = &cobra.Command{
+		Use:   "rebind <pv>",
+		Short: "Bind a Released PersistentVolume to a new PVC",
+		Long: "Clear the reference to the deleted claim of a Released PersistentVolume, create a PVC pre-bound to it " +
+			"with a matching size, storage class and access modes, and wait for the PVC to be bound",
+		Example: "kmon pv rebind pvc-0b5e4a3c-5d3a-4ad8-9d8e-1f4e1e2b6a7c --claim-name data-pvc -n db",
+		Args:    cobra.ExactArgs(1),
+	}
+
+	c.k9sCmd = &cobra.Command{
+		Use:  "k9s",
+		Long: "Integration of kmon into k9s",
+	}
+
+	c.k9sInstallCmd = &cobra.Command{
+		Use:   "install",
+		Short: "Install the kmon commands as k9s plugins",
+		Long: "Generate a k9s plugin for every kmon command usable on a PVC, VolumeSnapshot or namespace, run through the shell " +
+			"of the current OS, and merge them into the k9s plugins file. Plugins of other tools sharing a shortcut in the " +
+			"same scope are reported and left untouched, the conflicting kmon plugins are skipped",
+		Example: `kmon k9s install
+kmon k9s install --print --shell pwsh`,
+		Args: cobra.NoArgs,
+	}
+
+	c.authCmd = &cobra.Command{
+		Use:  "auth",
+		Long: "Review the permissions kmon commands need",
+	}
+
+	c.authCheckCmd = &cobra.Command{
+		Use:   "check <command>",
+		Short: "Check the permissions a kmon command needs in the namespace",
+		Long: "Review every permission the command needs with a SelfSubjectAccessReview and print them in a table. " +
+			"The same check runs before every command, so it fails before changing anything. " +
+			"Flags which make a command need more permissions are part of its name, e.g. \"pvc orphans --delete\"",
+		Example: `kmon auth check pvc migrate -n db
+kmon auth check "pvc orphans --delete" -n staging`,
+		Args: cobra.MinimumNArgs(1),
+	}
+
+	c.authRBACCmd = &cobra.Command{
+		Use:   "rbac <command>",
+		Short: "Print the minimal Role a kmon command needs",
+		Long: "Print the Role granting the namespaced permissions the command needs, " +
+			"followed by a ClusterRole for the cluster scoped ones, if any",
+		Example: "kmon auth rbac pvc backup -n db | kubectl apply -f -",
+		Args:    cobra.MinimumNArgs(1),
+	}
+
+	c.doctorCmd = &cobra.Command{
+		Use:   "doctor",
+		Short: "Check the snapshot and CSI support of the cluster",
+		Long: "Check that the snapshot.storage.k8s.io CRDs are served in the version kmon needs and a snapshot controller is running, " +
+			"list the CSI drivers with the StorageClasses and VolumeSnapshotClasses using them, the default snapshot class of every driver, " +
+			"and whether the StorageClasses support expansion and use a registered CSI driver, which snapshots and cloning need. Fails if volume snapshots can not work at all",
+		Example: "kmon doctor -o json",
+		Args:    cobra.NoArgs,
+	}
+
 	c.rootCmd.AddCommand(c.pvcCmd)
+	c.rootCmd.AddCommand(c.doctorCmd)
+
+	c.execCmd = &cobra.Command{
+		Use:   "exec -- <command> [args...]",
+		Short: "Run a command in every pod matching a selector",
+		Long: "Run a non-interactive command in the containers of all pods matching the selector concurrently, " +
+			"capturing the output and exit code of each. Identical outputs are printed once along with the containers " +
+			"producing them. Fails if the command could not run or exited non-zero in any container",
+		Example: `kmon exec --selector app=web -- cat /etc/app/version
+kmon exec -l app=cache --all-containers -o json -- sh -c 'ls /var/cache | wc -l'`,
+		Args: cobra.MinimumNArgs(1),
+	}
+	c.rootCmd.AddCommand(c.execCmd)
+
+	c.logsCmd = &cobra.Command{
+		Use:   "logs",
+		Short: "Print the logs of all pods matching a selector",
+		Long: "Print the logs of all containers of the pods matching the selector, prefixed with the pod and container. " +
+			"With --follow the logs are streamed, including the ones of pods appearing and containers restarting later, " +
+			"until interrupted, unless --timeout is set. With --dir the logs are written into one file per container, " +
+			"along with the manifest and the events of every pod, as a support bundle",
+		Example: `kmon logs --selector app=web -f --since 10m --grep 'timeout|refused'
+kmon logs -l app=api --field level=error --field http.status=500
+kmon logs -l app=api --since 1h --dir ./incident-bundle`,
+		Args: cobra.NoArgs,
+	}
+	c.rootCmd.AddCommand(c.logsCmd)
+
+	c.netCmd = &cobra.Command{
+		Use:  "net",
+		Long: "Network checks from inside the cluster, run with the netshoot image",
+	}
+
+	c.netMatrixCmd = &cobra.Command{
+		Use:   "matrix",
+		Short: "Probe the reachability of pods from other pods",
+		Long: "Probe the pods matching the target selector with TCP connections and HTTP requests, and resolve DNS names, " +
+			"from every pod matching the selector, and print a matrix of the outcomes and latencies. " +
+			"The probes need curl and dig in the source pods, --ephemeral runs them from a netshoot container added to " +
+			"every source pod, sharing its network and NetworkPolicies, while --launch runs them from a temporary netshoot pod " +
+			"with the --probe-labels",
+		Example: `kmon net matrix --selector app=api --target-selector app=db --port 5432 --ephemeral
+kmon net matrix --launch --probe-labels app=api --target-selector app=web --port 8080 --check tcp,http --dns-name web`,
+		Args: cobra.NoArgs,
+	}
+
+	c.netDNSCmd = &cobra.Command{
+		Use:   "dns <name>",
+		Short: "Debug the resolution of a DNS name from inside the cluster",
+		Long: "Resolve the name from a temporary netshoot pod in the namespace, through the cluster DNS and against every " +
+			"CoreDNS pod directly, both with the search path of the pod, and report the answers, latencies and discrepancies " +
+			"along with the search path and nameservers of the pod. Use --node to resolve from a specific node",
+		Example: `kmon net dns kubernetes.default
+kmon net dns db.prod.svc.cluster.local -n staging --node worker-2`,
+		Args: cobra.ExactArgs(1),
+	}
+
+	c.rootCmd.AddCommand(c.netCmd)
+	c.netCmd.AddCommand(c.netMatrixCmd)
+	c.netCmd.AddCommand(c.netDNSCmd)
+	c.rootCmd.AddCommand(c.authCmd)
+	c.authCmd.AddCommand(c.authCheckCmd)
+	c.authCmd.AddCommand(c.authRBACCmd)
+	c.rootCmd.AddCommand(c.k9sCmd)
+	c.k9sCmd.AddCommand(c.k9sInstallCmd)
+	c.rootCmd.AddCommand(c.pvCmd)
+	c.pvCmd.AddCommand(c.pvRebindCmd)
+	c.rootCmd.AddCommand(c.snapshotCmd)
+	c.pvcCmd.AddCommand(c.pvcCpCmd)
+	c.pvcCmd.AddCommand(c.pvcLsCmd)
+	c.pvcCmd.AddCommand(c.pvcBackupCmd)
+	c.pvcCmd.AddCommand(c.pvcRestoreArchiveCmd)
+	c.pvcCmd.AddCommand(c.pvcMigrateCmd)
+	c.pvcCmd.AddCommand(c.pvcResizeCmd)
+	c.pvcCmd.AddCommand(c.pvcUsageCmd)
+	c.pvcCmd.AddCommand(c.pvcOrphansCmd)
+	c.pvcCmd.AddCommand(c.pvcDescribeCmd)
+	c.snapshotCmd.AddCommand(c.snapshotLsCmd)
+	c.snapshotCmd.AddCommand(c.snapshotDiffCmd)
 
 	return &c, nil
 }
 
 func (c *Config) Execute(handlers Runner) error {
+	c.runner = handlers
+
 	c.rootCmd.PersistentFlags().StringVarP(&c.configPath, "config", "c", "", "path to config file")
 	c.rootCmd.PersistentFlags().StringVarP(&c.Namespace, "namespace", "n", "default", "namespace to run in")
-	c.rootCmd.PersistentFlags().StringVar(&c.Context, "context", "", "context to run in")
+	c.rootCmd.PersistentFlags().StringVar(&c.Context, "context", "", "kubeconfig context to run in, defaults to the current context")
+	c.rootCmd.PersistentFlags().BoolVar(&c.Force, "force", false, "replace existing pods and pvcs created by kmon whose spec differs from the requested one")
+	c.rootCmd.PersistentFlags().BoolVar(&c.GenerateName, "generate-name", false, "use the pod and pvc names as prefixes for generated unique names")
+	c.rootCmd.PersistentFlags().StringVarP(c.Output.stringPtr(), "output", "o", string(OutputText), "result output format: text, json or yaml")
+	c.rootCmd.PersistentFlags().StringVar(&c.LogFormat, "log-format", "text", "log format: text or json")
+	c.rootCmd.PersistentFlags().StringVar(&c.LogLevel, "log-level", "info", "log level: debug, info, warn or error")
+	c.rootCmd.PersistentFlags().BoolVar(&c.K9s.Plugin, "k9s", false, "show a result panel and wait for a keypress on failure, detected automatically when started by k9s")
+	c.rootCmd.PersistentFlags().DurationVar(&c.Timeout, "timeout", 5*time.Minute, "maximum duration of the whole operation, 0 disables it. pvc cp, backup, restore-archive, migrate, resize, pod forward and logs -f only time out when set")
 
 	pf := c.podCmd.Flags()
 	pf.StringVar(c.Pod.Mode.stringPtr(), "mode", "", "pod operation mode")
@@ -135,10 +663,55 @@ func (c *Config) Execute(handlers Runner) error {
 	_ = viper.BindPFlag("pod.pvc-name", pf.Lookup("pvc-name"))
 	_ = viper.BindPFlag("pod.snapshot-name", pf.Lookup("snapshot-name"))
 
+	pff := c.podForwardCmd.Flags()
+	pff.StringVarP(&c.Pod.Forward.Selector, "selector", "l", "", "label selector of the pods, e.g. app=web")
+	pff.StringSliceVar(&c.Pod.Forward.Ports, "port", nil, "port to forward, written as remote or local:remote, an empty local port is picked at random")
+	pff.StringVar(&c.Pod.Forward.Address, "address", "127.0.0.1", "local address to listen on")
+	pff.BoolVar(&c.Pod.Forward.RoundRobin, "round-robin", false, "forward the connections to all ready pods in turn")
+	_ = c.podForwardCmd.MarkFlagRequired("selector")
+	_ = c.podForwardCmd.MarkFlagRequired("port")
+
+	ef := c.execCmd.Flags()
+	ef.StringVarP(&c.Exec.Selector, "selector", "l", "", "label selector of the pods, e.g. app=web")
+	ef.StringVar(&c.Exec.Container, "container", "", "container to run the command in, defaults to the default container of every pod")
+	ef.BoolVar(&c.Exec.AllContainers, "all-containers", false, "run the command in every container of the pods")
+	ef.IntVar(&c.Exec.Parallel, "parallel", 10, "maximum number of containers running the command at once")
+	c.execCmd.MarkFlagsMutuallyExclusive("container", "all-containers")
+	_ = c.execCmd.MarkFlagRequired("selector")
+
+	lgf := c.logsCmd.Flags()
+	lgf.StringVarP(&c.Logs.Selector, "selector", "l", "", "label selector of the pods, e.g. app=web")
+	lgf.BoolVarP(&c.Logs.Follow, "follow", "f", false, "stream the logs, including the ones of pods appearing later")
+	lgf.DurationVar(&c.Logs.Since, "since", 0, "only print the logs newer than this duration, e.g. 10m")
+	lgf.StringVar(&c.Logs.Grep, "grep", "", "only print the lines matching this regular expression")
+	lgf.StringSliceVar(&c.Logs.Fields, "field", nil, "only print the JSON lines with this field value, written as key=value, nested keys joined by dots")
+	lgf.StringVar(&c.Logs.Dir, "dir", "", "write the logs, manifests and events of the pods into this directory instead of printing them")
+	_ = c.logsCmd.MarkFlagRequired("selector")
+
+	c.netCmd.PersistentFlags().DurationVar(&c.Net.ProbeTimeout, "probe-timeout", 3*time.Second, "maximum duration of every probe")
+
+	nmf := c.netMatrixCmd.Flags()
+	nmf.StringVarP(&c.Net.Matrix.Selector, "selector", "l", "", "label selector of the pods to probe from, e.g. app=api")
+	nmf.BoolVar(&c.Net.Matrix.Ephemeral, "ephemeral", false, "probe from a netshoot container added to every source pod")
+	nmf.BoolVar(&c.Net.Matrix.Launch, "launch", false, "probe from a temporary netshoot pod instead of existing pods")
+	nmf.StringToStringVar(&c.Net.Matrix.ProbeLabels, "probe-labels", nil, "labels of the launched pod, so the NetworkPolicies of the pods with these labels apply")
+	nmf.StringVar(&c.Net.Matrix.TargetSelector, "target-selector", "", "label selector of the pods to probe, e.g. app=db")
+	nmf.StringVar(&c.Net.Matrix.TargetNamespace, "target-namespace", "", "namespace of the pods to probe, defaults to the namespace")
+	nmf.IntVar(&c.Net.Matrix.Port, "port", 0, "port of the pods to probe")
+	nmf.StringSliceVar(&c.Net.Matrix.Checks, "check", []string{"tcp"}, "checks of the pods to probe: tcp, http")
+	nmf.StringVar(&c.Net.Matrix.HTTPPath, "http-path", "/", "path of the http requests")
+	nmf.StringSliceVar(&c.Net.Matrix.DNSNames, "dns-name", nil, "dns name to resolve from every source, with the search path of the source")
+	c.netMatrixCmd.MarkFlagsOneRequired("selector", "launch")
+	c.netMatrixCmd.MarkFlagsMutuallyExclusive("selector", "launch")
+	c.netMatrixCmd.MarkFlagsMutuallyExclusive("ephemeral", "launch")
+	c.netMatrixCmd.MarkFlagsRequiredTogether("target-selector", "port")
+
+	c.netDNSCmd.Flags().StringVar(&c.Net.DNS.Node, "node", "", "node to schedule the netshoot pod on")
+
 	pvf := c.pvcCmd.Flags()
 	pvf.StringVar(c.PVC.Mode.stringPtr(), "mode", "", "pod operation mode")
 	pvf.StringVar(&c.PVC.Name, "name", "kmon-pvc", "pvc name")
-	pvf.StringVar(&c.PVC.SnapshotClassName, "snapshot-class-name", "", "snapshot class name")
+	pvf.StringVar(&c.PVC.SnapshotClassName, "snapshot-class-name", "", "snapshot class name, defaults to the snapshot class of the csi driver of the source pvc")
 	pvf.StringVar(&c.PVC.SourcePVCName, "source-pvc-name", "", "source pvc name")
 	pvf.StringVar(&c.PVC.SnapshotName, "snapshot-name", "kmon-snap", "snapshot name")
 	_ = viper.BindPFlag("pvc.mode", pf.Lookup("mode"))
@@ -147,9 +720,193 @@ func (c *Config) Execute(handlers Runner) error {
 	_ = viper.BindPFlag("pvc.source-pvc-name", pvf.Lookup("source-pvc-name"))
 	_ = viper.BindPFlag("pvc.snapshot-name", pvf.Lookup("snapshot-name"))
 
+	pcf := c.pvcCpCmd.Flags()
+	pcf.BoolVar(&c.PVC.Copy.FromSnapshot, "from-snapshot", false, "the source is a snapshot, restored into a temporary pvc first")
+
+	pbf := c.pvcBackupCmd.Flags()
+	pbf.StringVarP(&c.PVC.Archive.File, "file", "f", "", "path of the archive to write")
+	pbf.StringVar(&c.PVC.Archive.ChunkSize, "chunk-size", "256Mi", "amount of file content written per resumable chunk")
+	_ = c.pvcBackupCmd.MarkFlagRequired("file")
+
+	praf := c.pvcRestoreArchiveCmd.Flags()
+	praf.StringVarP(&c.PVC.Archive.File, "file", "f", "", "path of the archive to restore")
+	praf.StringVar(&c.PVC.Archive.Name, "name", "", "name of the pvc to create")
+	praf.StringVar(&c.PVC.Archive.StorageClassName, "storage-class", "", "storage class of the new pvc, defaults to the one of the source pvc")
+	_ = c.pvcRestoreArchiveCmd.MarkFlagRequired("file")
+	_ = c.pvcRestoreArchiveCmd.MarkFlagRequired("name")
+
+	pmf := c.pvcMigrateCmd.Flags()
+	pmf.StringVar(&c.PVC.Migrate.ToStorageClass, "to-storage-class", "", "storage class to move the pvc to")
+	_ = c.pvcMigrateCmd.MarkFlagRequired("to-storage-class")
+
+	prf := c.pvcResizeCmd.Flags()
+	prf.StringVar(&c.PVC.Resize.Size, "size", "", "new size of the pvc, e.g. 50Gi")
+	prf.BoolVar(&c.PVC.Resize.RestartPod, "restart-pod", false, "restart the consuming pods if the filesystem can only be expanded offline")
+	_ = c.pvcResizeCmd.MarkFlagRequired("size")
+
+	puf := c.pvcUsageCmd.Flags()
+	puf.BoolVarP(&c.PVC.Usage.AllNamespaces, "all-namespaces", "A", false, "report the pvcs of all namespaces")
+	puf.StringVar(&c.PVC.Usage.Sort, "sort", "percent", "sort by: percent, used, capacity or name")
+	puf.Float64Var(&c.PVC.Usage.Threshold, "threshold", 0, "only report pvcs at least this percent full")
+	puf.DurationVar(&c.PVC.Usage.SnapshotMaxAge, "snapshot-max-age", 24*time.Hour, "maximum age of a kmon snapshot to count as recent")
+
+	pof := c.pvcOrphansCmd.Flags()
+	pof.BoolVarP(&c.PVC.Orphans.AllNamespaces, "all-namespaces", "A", false, "search all namespaces and all cluster scoped objects")
+	pof.StringSliceVar(&c.PVC.Orphans.Kinds, "kind", nil, "only report these kinds: pvc, pv, snapshot, snapshotcontent, defaults to all of them")
+	pof.BoolVar(&c.PVC.Orphans.KmonOnly, "kmon-only", false, "only report the objects created by kmon")
+	pof.BoolVar(&c.PVC.Orphans.Delete, "delete", false, "delete the reported objects once confirmed, snapshots and pvcs not created by kmon only if selected with --kind")
+	pof.BoolVar(&c.PVC.Orphans.IncludeRetained, "include-retained", false, "also delete the volumes and snapshot contents with the Retain policy")
+	pof.BoolVarP(&c.PVC.Orphans.Yes, "yes", "y", false, "delete without asking for confirmation")
+
+	prbf := c.pvRebindCmd.Flags()
+	prbf.StringVar(&c.PV.Rebind.ClaimName, "claim-name", "", "name of the pvc to bind the pv to")
+	_ = c.pvRebindCmd.MarkFlagRequired("claim-name")
+
+	kif := c.k9sInstallCmd.Flags()
+	kif.StringVarP(&c.K9s.Install.File, "file", "f", "", "k9s plugins file to merge the plugins into, defaults to the one k9s reads")
+	kif.StringVar(&c.K9s.Install.Shell, "shell", "", "shell running the plugins, e.g. sh, bash, cmd or pwsh, defaults to the one of the current OS and user")
+	kif.BoolVar(&c.K9s.Install.Print, "print", false, "print the generated plugins instead of installing them")
+
+	for _, lc := range []struct {
+		cmd  *cobra.Command
+		list *List
+	}{
+		{cmd: c.pvcLsCmd, list: &c.PVC.List},
+		{cmd: c.snapshotLsCmd, list: &c.Snapshot.List},
+	} {
+		lf := lc.cmd.Flags()
+		lf.BoolVarP(&lc.list.Recursive, "recursive", "R", false, "list subdirectories recursively")
+		lf.BoolVar(&lc.list.DiskUsage, "du", false, "show the total size of the files within each directory")
+	}
+
+	sdf := c.snapshotDiffCmd.Flags()
+	sdf.StringVar(&c.Snapshot.Diff.AgainstPVC, "against-pvc", "", "compare the snapshot against this live pvc instead of another snapshot")
+	sdf.StringVar(&c.Snapshot.Diff.Path, "path", "/", "only compare the files below this path")
+
 	c.rootCmd.RunE = func(_ *cobra.Command, _ []string) error { return c.rootCmd.Help() }
+	c.snapshotCmd.RunE = func(_ *cobra.Command, _ []string) error { return c.snapshotCmd.Help() }
+	c.pvCmd.RunE = func(_ *cobra.Command, _ []string) error { return c.pvCmd.Help() }
+	c.k9sCmd.RunE = func(_ *cobra.Command, _ []string) error { return c.k9sCmd.Help() }
+	c.authCmd.RunE = func(_ *cobra.Command, _ []string) error { return c.authCmd.Help() }
+	c.authCheckCmd.RunE = func(_ *cobra.Command, args []string) error {
+		c.Auth.Command = strings.Join(args, " ")
+		return handlers.AuthCheckCmdHandler()
+	}
+	c.authRBACCmd.RunE = func(_ *cobra.Command, args []string) error {
+		c.Auth.Command = strings.Join(args, " ")
+		return handlers.AuthRBACCmdHandler()
+	}
+	c.execCmd.RunE = func(_ *cobra.Command, args []string) error {
+		c.Exec.Command = args
+		return handlers.ExecCmdHandler()
+	}
+	c.logsCmd.RunE = func(cmd *cobra.Command, _ []string) error {
+		// following runs until interrupted
+		if c.Logs.Follow {
+			c.dropDefaultTimeout(cmd)
+		}
+		return handlers.LogsCmdHandler()
+	}
+	c.netCmd.RunE = func(_ *cobra.Command, _ []string) error { return c.netCmd.Help() }
+	c.netMatrixCmd.RunE = func(_ *cobra.Command, _ []string) error { return handlers.NetMatrixCmdHandler() }
+	c.netDNSCmd.RunE = func(_ *cobra.Command, args []string) error {
+		c.Net.DNS.Name = args[0]
+		return handlers.NetDNSCmdHandler()
+	}
+	c.doctorCmd.RunE = func(_ *cobra.Command, _ []string) error { return handlers.DoctorCmdHandler() }
+	c.k9sInstallCmd.RunE = func(_ *cobra.Command, _ []string) error { return handlers.K9sInstallCmdHandler() }
+	c.pvRebindCmd.RunE = func(_ *cobra.Command, args []string) error {
+		c.PV.Rebind.PVName = args[0]
+		return handlers.PVRebindCmdHandler()
+	}
 	c.podCmd.RunE = func(_ *cobra.Command, _ []string) error { return handlers.PodCmdHandler() }
+	c.podForwardCmd.RunE = func(cmd *cobra.Command, _ []string) error {
+		// forwarding runs until interrupted
+		c.dropDefaultTimeout(cmd)
+		return handlers.PodForwardCmdHandler()
+	}
 	c.pvcCmd.RunE = func(_ *cobra.Command, _ []string) error { return handlers.PVCCmdHandler() }
+	c.pvcCpCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		c.dropDefaultTimeout(cmd)
+		c.PVC.Copy.Source, c.PVC.Copy.Destination = args[0], args[1]
+		return handlers.PVCCopyCmdHandler()
+	}
+	c.pvcBackupCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		c.dropDefaultTimeout(cmd)
+		c.PVC.Archive.PVCName = args[0]
+		return handlers.PVCBackupCmdHandler()
+	}
+	c.pvcRestoreArchiveCmd.RunE = func(cmd *cobra.Command, _ []string) error {
+		c.dropDefaultTimeout(cmd)
+		return handlers.PVCRestoreArchiveCmdHandler()
+	}
+	c.pvcMigrateCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		// aborting the copy midway only causes a rollback
+		c.dropDefaultTimeout(cmd)
+		c.PVC.Migrate.PVCName = args[0]
+		return handlers.PVCMigrateCmdHandler()
+	}
+	c.pvcResizeCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		// the resize is bounded by its own timeout, waiting for the file system to grow
+		c.dropDefaultTimeout(cmd)
+		c.PVC.Resize.PVCName = args[0]
+		return handlers.PVCResizeCmdHandler()
+	}
+	c.pvcUsageCmd.RunE = func(_ *cobra.Command, _ []string) error { return handlers.PVCUsageCmdHandler() }
+	c.pvcOrphansCmd.RunE = func(_ *cobra.Command, _ []string) error { return handlers.PVCOrphansCmdHandler() }
+	c.pvcDescribeCmd.RunE = func(_ *cobra.Command, args []string) error {
+		c.PVC.Describe.PVCName = args[0]
+		return handlers.PVCDescribeCmdHandler()
+	}
+	c.pvcLsCmd.RunE = func(_ *cobra.Command, args []string) error {
+		c.PVC.List.setArgs(args)
+		return handlers.PVCListCmdHandler()
+	}
+	c.snapshotLsCmd.RunE = func(_ *cobra.Command, args []string) error {
+		c.Snapshot.List.setArgs(args)
+		return handlers.SnapshotListCmdHandler()
+	}
+	c.snapshotDiffCmd.RunE = func(_ *cobra.Command, args []string) error {
+		c.Snapshot.Diff.Old = args[0]
+		if len(args) > 1 {
+			c.Snapshot.Diff.New = args[1]
+		}
+
+		return handlers.SnapshotDiffCmdHandler()
+	}
 
 	return c.rootCmd.Execute()
 }
+
+// dropDefaultTimeout disables the default --timeout for commands which run as long as their data or the user needs,
+// a timeout set by the flag or in the config file still applies
+func (c *Config) dropDefaultTimeout(cmd *cobra.Command) {
+	if !cmd.Flags().Changed("timeout") && !viper.IsSet("timeout") {
+		c.Timeout = 0
+	}
+}
+
+// readConfigFile loads the config file set with the --config flag, if any
+func (c *Config) readConfigFile() error {
+	if !c.rootCmd.PersistentFlags().Changed("config") {
+		return nil
+	}
+
+	if err := viper.BindPFlag("config", c.rootCmd.PersistentFlags().Lookup("config")); err != nil {
+		return err
+	}
+
+	viper.SetConfigFile(viper.GetString("config"))
+	if err := viper.ReadInConfig(); err != nil {
+		return err
+	}
+
+	return viper.Unmarshal(c)
+}
+
+func (l *List) setArgs(args []string) {
+	l.Target, l.Path = args[0], "/"
+	if len(args) > 1 {
+		l.Path = args[1]
+	}
+}
@@ -0,0 +1,144 @@
+package k9s
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// PluginsFilePath returns the plugins file k9s reads, honouring K9S_CONFIG_DIR and XDG_CONFIG_HOME like k9s does
+func PluginsFilePath() (string, error) {
+	if dir := os.Getenv("K9S_CONFIG_DIR"); dir != "" {
+		return filepath.Join(dir, "plugins.yaml"), nil
+	}
+
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "k9s", "plugins.yaml"), nil
+	}
+
+	if dir := os.Getenv("LOCALAPPDATA"); runtime.GOOS == "windows" && dir != "" {
+		return filepath.Join(dir, "k9s", "plugins.yaml"), nil
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("could not find the k9s config dir: %w", err)
+	}
+
+	return filepath.Join(dir, "k9s", "plugins.yaml"), nil
+}
+
+// PluginsFile is the content of a k9s plugins file. Fields and plugins kmon does not know about are kept untouched
+type PluginsFile struct {
+	doc     map[string]any
+	plugins map[string]any
+}
+
+// ReadPluginsFile reads the plugins file, a missing file being an empty one
+func ReadPluginsFile(path string) (*PluginsFile, error) {
+	f := &PluginsFile{doc: map[string]any{}, plugins: map[string]any{}}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read k9s plugins file: %w", err)
+	}
+
+	if err := yaml.Unmarshal(data, &f.doc); err != nil {
+		return nil, fmt.Errorf("could not parse k9s plugins file %s: %w", path, err)
+	}
+	if f.doc == nil {
+		f.doc = map[string]any{}
+	}
+
+	if plugins, ok := f.doc["plugins"]; ok && plugins != nil {
+		if f.plugins, ok = plugins.(map[string]any); !ok {
+			return nil, fmt.Errorf("could not parse k9s plugins file %s: plugins is not a map", path)
+		}
+	}
+
+	return f, nil
+}
+
+// Plugins returns the plugins of the file, skipping the ones which are not valid plugin definitions
+func (f *PluginsFile) Plugins() map[string]Plugin {
+	plugins := make(map[string]Plugin, len(f.plugins))
+	for name, raw := range f.plugins {
+		data, err := json.Marshal(raw)
+		if err != nil {
+			continue
+		}
+
+		var p Plugin
+		if err := json.Unmarshal(data, &p); err != nil {
+			continue
+		}
+
+		plugins[name] = p
+	}
+
+	return plugins
+}
+
+// Set adds or replaces the plugin
+func (f *PluginsFile) Set(name string, p Plugin) {
+	f.plugins[name] = p
+}
+
+// Prune removes the plugins generated by kmon which are not in keep, e.g. left over from a previous version,
+// and returns their names
+func (f *PluginsFile) Prune(keep map[string]Plugin) []string {
+	var removed []string
+	for name := range f.plugins {
+		if _, ok := keep[name]; !ok && strings.HasPrefix(name, PluginPrefix) {
+			delete(f.plugins, name)
+			removed = append(removed, name)
+		}
+	}
+
+	sort.Strings(removed)
+
+	return removed
+}
+
+// Write replaces the plugins file atomically, keeping a copy of the previous one next to it.
+// Comments of the previous file are not preserved
+func (f *PluginsFile) Write(path string) error {
+	f.doc["plugins"] = f.plugins
+
+	data, err := yaml.Marshal(f.doc)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("could not create k9s config dir: %w", err)
+	}
+
+	if previous, err := os.ReadFile(path); err == nil {
+		if err := os.WriteFile(path+".bak", previous, 0o644); err != nil {
+			return fmt.Errorf("could not back up k9s plugins file: %w", err)
+		}
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("could not write k9s plugins file: %w", err)
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// Marshal renders the plugins as a k9s plugins file
+func Marshal(plugins map[string]Plugin) ([]byte, error) {
+	return yaml.Marshal(map[string]any{"plugins": plugins})
+}
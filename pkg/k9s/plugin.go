@@ -0,0 +1,232 @@
+package k9s
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// PluginPrefix marks the plugins generated by kmon, which are replaced on every install
+const PluginPrefix = "kmon-"
+
+// Plugin is a k9s plugin definition, as found under the plugins key of the k9s plugins file
+type Plugin struct {
+	ShortCut    string   `json:"shortCut"`
+	Description string   `json:"description"`
+	Scopes      []string `json:"scopes"`
+	Command     string   `json:"command"`
+	Background  bool     `json:"background"`
+	Confirm     bool     `json:"confirm,omitempty"`
+	Args        []string `json:"args"`
+}
+
+// Command is a kmon command exposed as a k9s plugin
+type Command struct {
+	Name        string
+	Description string
+	ShortCut    string
+	Scopes      []string
+	// Args are the kmon arguments, referring to the selected resource with the k9s variables $NAMESPACE, $NAME and $CONTEXT
+	Args []string
+	// Confirm asks for a confirmation in k9s before running commands which create objects
+	Confirm bool
+}
+
+// Commands is the registry of the kmon commands available from k9s
+var Commands = []Command{
+	{
+		Name:        "pod-from-pvc",
+		Description: "Start a pod mounting the PVC",
+		ShortCut:    "Shift-P",
+		Scopes:      []string{"persistentvolumeclaims"},
+		Args:        []string{"pod", "--mode", "run-from-pvc", "--context", "$CONTEXT", "-n", "$NAMESPACE", "--pvc-name", "$NAME"},
+		Confirm:     true,
+	},
+	{
+		Name:        "snapshot-from-pvc",
+		Description: "Create a VolumeSnapshot of the PVC",
+		ShortCut:    "Shift-F",
+		Scopes:      []string{"persistentvolumeclaims"},
+		Args:        []string{"pvc", "--mode", "snapshot-from-pvc", "--context", "$CONTEXT", "-n", "$NAMESPACE", "--source-pvc-name", "$NAME", "--snapshot-name", "$NAME"},
+		Confirm:     true,
+	},
+	{
+		Name:        "pvc-describe",
+		Description: "Show the storage lineage of the PVC",
+		ShortCut:    "Shift-I",
+		Scopes:      []string{"persistentvolumeclaims"},
+		Args:        []string{"pvc", "describe", "$NAME", "--context", "$CONTEXT", "-n", "$NAMESPACE"},
+	},
+	{
+		Name:        "pvc-ls",
+		Description: "List the content of the PVC",
+		ShortCut:    "Shift-L",
+		Scopes:      []string{"persistentvolumeclaims"},
+		Args:        []string{"pvc", "ls", "$NAME", "--context", "$CONTEXT", "-n", "$NAMESPACE"},
+	},
+	{
+		Name:        "pod-from-snapshot",
+		Description: "Start a pod mounting a PVC restored from the VolumeSnapshot",
+		ShortCut:    "Shift-O",
+		Scopes:      []string{"volumesnapshots"},
+		Args:        []string{"pod", "--mode", "run-from-snapshot", "--context", "$CONTEXT", "-n", "$NAMESPACE", "--snapshot-name", "$NAME"},
+		Confirm:     true,
+	},
+	{
+		Name:        "pvc-from-snapshot",
+		Description: "Restore the VolumeSnapshot into a new PVC",
+		ShortCut:    "Shift-R",
+		Scopes:      []string{"volumesnapshots"},
+		Args:        []string{"pvc", "--mode", "pvc-from-snapshot", "--context", "$CONTEXT", "-n", "$NAMESPACE", "--snapshot-name", "$NAME", "--name", "$NAME-restore", "--generate-name"},
+		Confirm:     true,
+	},
+	{
+		Name:        "snapshot-ls",
+		Description: "List the content of the VolumeSnapshot",
+		ShortCut:    "Shift-T",
+		Scopes:      []string{"volumesnapshots"},
+		Args:        []string{"snapshot", "ls", "$NAME", "--context", "$CONTEXT", "-n", "$NAMESPACE"},
+	},
+	{
+		Name:        "pvc-usage",
+		Description: "Report the filesystem usage of the PVCs in the namespace",
+		ShortCut:    "Shift-U",
+		Scopes:      []string{"namespaces"},
+		Args:        []string{"pvc", "usage", "--context", "$CONTEXT", "-n", "$NAME"},
+	},
+}
+
+// Shell runs the kmon command line of a plugin
+type Shell struct {
+	Command string
+	// Flag makes the shell run the command line passed as the next argument
+	Flag string
+}
+
+// DetectShell returns the named shell, cmd on Windows or the user shell elsewhere if the name is empty
+func DetectShell(name, userShell string) Shell {
+	if name == "" {
+		switch {
+		case runtime.GOOS == "windows":
+			name = "cmd"
+		case userShell != "":
+			name = userShell
+		default:
+			name = "sh"
+		}
+	}
+
+	switch strings.TrimSuffix(filepath.Base(name), ".exe") {
+	case "cmd":
+		return Shell{Command: name, Flag: "/C"}
+	case "powershell", "pwsh":
+		return Shell{Command: name, Flag: "-Command"}
+	default:
+		return Shell{Command: name, Flag: "-c"}
+	}
+}
+
+// Generate builds the plugins of the registry, named with the PluginPrefix, running the kmon executable through the shell
+func Generate(kmon string, sh Shell, extraArgs ...string) map[string]Plugin {
+	plugins := make(map[string]Plugin, len(Commands))
+	for _, cmd := range Commands {
+		line := append([]string{quote(kmon)}, cmd.Args...)
+		line = append(line, extraArgs...)
+
+		plugins[PluginPrefix+cmd.Name] = Plugin{
+			ShortCut:    cmd.ShortCut,
+			Description: "kmon: " + cmd.Description,
+			Scopes:      cmd.Scopes,
+			Command:     sh.Command,
+			Confirm:     cmd.Confirm,
+			Args:        []string{sh.Flag, strings.Join(line, " ")},
+		}
+	}
+
+	return plugins
+}
+
+// quote guards an executable path containing spaces, like the ones below Program Files
+func quote(s string) string {
+	if strings.ContainsAny(s, " \t") {
+		return `"` + s + `"`
+	}
+
+	return s
+}
+
+// Conflict is a plugin sharing its shortcut with another plugin in an overlapping scope
+type Conflict struct {
+	Plugin   string `json:"plugin"`
+	With     string `json:"with"`
+	ShortCut string `json:"shortCut"`
+}
+
+func (c Conflict) String() string {
+	return fmt.Sprintf("%s conflicts with %s on %s", c.Plugin, c.With, c.ShortCut)
+}
+
+// Conflicts reports the generated plugins clashing with a plugin which was not generated by kmon
+func Conflicts(existing, generated map[string]Plugin) []Conflict {
+	var conflicts []Conflict
+	for name, p := range generated {
+		for otherName, other := range existing {
+			if strings.HasPrefix(otherName, PluginPrefix) {
+				continue
+			}
+
+			if strings.EqualFold(p.ShortCut, other.ShortCut) && scopesOverlap(p.Scopes, other.Scopes) {
+				conflicts = append(conflicts, Conflict{Plugin: name, With: otherName, ShortCut: p.ShortCut})
+			}
+		}
+	}
+
+	sort.Slice(conflicts, func(i, j int) bool {
+		if conflicts[i].Plugin != conflicts[j].Plugin {
+			return conflicts[i].Plugin < conflicts[j].Plugin
+		}
+		return conflicts[i].With < conflicts[j].With
+	})
+
+	return conflicts
+}
+
+// scopeAliases maps the short and singular resource names k9s accepts as plugin scopes to the plural resource name
+var scopeAliases = map[string]string{
+	"pvc":                   "persistentvolumeclaims",
+	"persistentvolumeclaim": "persistentvolumeclaims",
+	"pv":                    "persistentvolumes",
+	"persistentvolume":      "persistentvolumes",
+	"vs":                    "volumesnapshots",
+	"volumesnapshot":        "volumesnapshots",
+	"ns":                    "namespaces",
+	"namespace":             "namespaces",
+	"po":                    "pods",
+	"pod":                   "pods",
+}
+
+func scopesOverlap(a, b []string) bool {
+	canonical := func(scopes []string) []string {
+		out := make([]string, 0, len(scopes))
+		for _, s := range scopes {
+			s = strings.ToLower(s)
+			if alias, ok := scopeAliases[s]; ok {
+				s = alias
+			}
+			out = append(out, s)
+		}
+		return out
+	}
+
+	a, b = canonical(a), canonical(b)
+	for _, s := range a {
+		if s == "all" || slices.Contains(b, s) || slices.Contains(b, "all") {
+			return true
+		}
+	}
+
+	return false
+}
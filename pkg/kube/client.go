@@ -8,18 +8,44 @@ import (
 
 	vol "github.com/kubernetes-csi/external-snapshotter/client/v8/clientset/versioned"
 	v2 "github.com/kubernetes-csi/external-snapshotter/client/v8/clientset/versioned/typed/volumesnapshot/v1"
+	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/kubernetes"
+	appsv1 "k8s.io/client-go/kubernetes/typed/apps/v1"
+	authorizationv1 "k8s.io/client-go/kubernetes/typed/authorization/v1"
+	batchv1 "k8s.io/client-go/kubernetes/typed/batch/v1"
 	v1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	storagev1 "k8s.io/client-go/kubernetes/typed/storage/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
-	"k8s.io/client-go/util/homedir"
 )
 
 type Client struct {
 	v1.CoreV1Interface
 	v2.VolumeSnapshotsGetter
+	v2.VolumeSnapshotContentsGetter
+	v2.VolumeSnapshotClassesGetter
+	appsv1.DeploymentsGetter
+	appsv1.StatefulSetsGetter
+	appsv1.ReplicaSetsGetter
+	appsv1.DaemonSetsGetter
+	batchv1.JobsGetter
+	batchv1.CronJobsGetter
+	storagev1.StorageClassesGetter
+	storagev1.VolumeAttachmentsGetter
+	storagev1.CSIDriversGetter
+	authorizationv1.SelfSubjectAccessReviewsGetter
+	discovery.ServerGroupsInterface
+	discovery.ServerResourcesInterface
+
+	config *rest.Config
+}
+
+// RESTConfig returns the config the client was built with, used for streaming connections like exec
+func (c *Client) RESTConfig() *rest.Config {
+	return c.config
 }
 
+// NewKubeClient builds a client for the context of the kubeconfig, its current context if empty
 func NewKubeClient(kubeContext string) (*Client, error) {
 	var kubeConf *rest.Config
 
@@ -44,11 +70,6 @@ func NewKubeClient(kubeContext string) (*Client, error) {
 		if err != nil {
 			return nil, fmt.Errorf("could not create new kubeConf: %w", err)
 		}
-
-		kubeConf, err = clientcmd.BuildConfigFromFlags("", filepath.Join(homedir.HomeDir(), ".kube", "config"))
-		if err != nil {
-			return nil, fmt.Errorf("could not build kubeConf from flags: %w", err)
-		}
 	}
 	if err != nil {
 		return nil, fmt.Errorf("could not build kube config: %w", err)
@@ -65,7 +86,22 @@ func NewKubeClient(kubeContext string) (*Client, error) {
 	}
 
 	return &Client{
-		CoreV1Interface:       kcl.CoreV1(),
-		VolumeSnapshotsGetter: vcl.SnapshotV1(),
+		CoreV1Interface:                kcl.CoreV1(),
+		VolumeSnapshotsGetter:          vcl.SnapshotV1(),
+		VolumeSnapshotContentsGetter:   vcl.SnapshotV1(),
+		VolumeSnapshotClassesGetter:    vcl.SnapshotV1(),
+		DeploymentsGetter:              kcl.AppsV1(),
+		StatefulSetsGetter:             kcl.AppsV1(),
+		ReplicaSetsGetter:              kcl.AppsV1(),
+		DaemonSetsGetter:               kcl.AppsV1(),
+		JobsGetter:                     kcl.BatchV1(),
+		CronJobsGetter:                 kcl.BatchV1(),
+		StorageClassesGetter:           kcl.StorageV1(),
+		VolumeAttachmentsGetter:        kcl.StorageV1(),
+		CSIDriversGetter:               kcl.StorageV1(),
+		SelfSubjectAccessReviewsGetter: kcl.AuthorizationV1(),
+		ServerGroupsInterface:          kcl.Discovery(),
+		ServerResourcesInterface:       kcl.Discovery(),
+		config:                         kubeConf,
 	}, nil
 }
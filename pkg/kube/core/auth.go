@@ -0,0 +1,80 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	authv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	authorizationv1 "k8s.io/client-go/kubernetes/typed/authorization/v1"
+)
+
+type AuthManager interface {
+	// Check reviews every permission for the current user with a SelfSubjectAccessReview
+	Check(ctx context.Context, permissions []Permission) ([]PermissionCheck, error)
+}
+
+// Permission is a verb on a resource, in the namespace or cluster wide if it is empty
+type Permission struct {
+	Verb        string `json:"verb"`
+	Group       string `json:"group,omitempty"`
+	Resource    string `json:"resource"`
+	Subresource string `json:"subresource,omitempty"`
+	Namespace   string `json:"namespace,omitempty"`
+}
+
+func (p Permission) String() string {
+	resource := p.Resource
+	if p.Subresource != "" {
+		resource += "/" + p.Subresource
+	}
+	if p.Group != "" {
+		resource += "." + p.Group
+	}
+
+	return p.Verb + " " + resource
+}
+
+// PermissionCheck is the outcome of the review of a Permission
+type PermissionCheck struct {
+	Permission
+	Allowed bool   `json:"allowed"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+type auth struct {
+	log     *slog.Logger
+	reviews authorizationv1.SelfSubjectAccessReviewsGetter
+}
+
+func (a *auth) Check(ctx context.Context, permissions []Permission) ([]PermissionCheck, error) {
+	a.log.Debug("reviewing permissions", "count", len(permissions))
+
+	checks := make([]PermissionCheck, 0, len(permissions))
+	for _, p := range permissions {
+		review, err := a.reviews.SelfSubjectAccessReviews().Create(ctx, &authv1.SelfSubjectAccessReview{
+			Spec: authv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authv1.ResourceAttributes{
+					Namespace:   p.Namespace,
+					Verb:        p.Verb,
+					Group:       p.Group,
+					Resource:    p.Resource,
+					Subresource: p.Subresource,
+				},
+			},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("could not review permission to %s: %w", p, apiError(err))
+		}
+
+		reason := review.Status.Reason
+		if review.Status.EvaluationError != "" {
+			reason = review.Status.EvaluationError
+		}
+
+		checks = append(checks, PermissionCheck{Permission: p, Allowed: review.Status.Allowed, Reason: reason})
+	}
+
+	return checks, nil
+}
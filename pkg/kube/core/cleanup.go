@@ -0,0 +1,87 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// CleanupTimeout bounds the time compensating actions are allowed to run for
+// once the operation they belong to has been interrupted
+const CleanupTimeout = 60 * time.Second
+
+type CleanupManager interface {
+	// Register adds a compensating action which reverts a change made to the cluster.
+	// Actions are run in the reverse order of registration
+	Register(description string, action CleanupAction)
+	// Run executes all registered actions, even if the context passed to NewCore is already cancelled
+	Run() error
+	// Discard forgets all registered actions, once the operation they belong to has completed
+	Discard()
+	// SetTimeout raises the time the actions are allowed to run for, for operations whose
+	// compensating actions wait on the cluster longer than the default cleanup timeout
+	SetTimeout(timeout time.Duration)
+}
+
+// CleanupAction is a compensating action, run with a context that outlives the interrupted operation
+type CleanupAction func(ctx context.Context) error
+
+type cleanup struct {
+	ctx context.Context
+	log *slog.Logger
+
+	mu      sync.Mutex
+	actions []cleanupEntry
+	timeout time.Duration
+}
+
+type cleanupEntry struct {
+	description string
+	action      CleanupAction
+}
+
+func (c *cleanup) Register(description string, action CleanupAction) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.actions = append(c.actions, cleanupEntry{description: description, action: action})
+}
+
+func (c *cleanup) Run() error {
+	c.mu.Lock()
+	actions := c.actions
+	timeout := max(c.timeout, CleanupTimeout)
+	c.actions = nil
+	c.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(c.ctx), timeout)
+	defer cancel()
+
+	var errs []error
+	for i := len(actions) - 1; i >= 0; i-- {
+		c.log.Info("running cleanup", "action", actions[i].description)
+
+		if err := actions[i].action(ctx); err != nil {
+			c.log.Error("cleanup failed", "action", actions[i].description, "err", err)
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (c *cleanup) SetTimeout(timeout time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.timeout = timeout
+}
+
+func (c *cleanup) Discard() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.actions = nil
+}
@@ -5,32 +5,98 @@ import (
 	"log/slog"
 
 	v2 "github.com/kubernetes-csi/external-snapshotter/client/v8/clientset/versioned/typed/volumesnapshot/v1"
+	"k8s.io/client-go/discovery"
+	appsv1 "k8s.io/client-go/kubernetes/typed/apps/v1"
+	authorizationv1 "k8s.io/client-go/kubernetes/typed/authorization/v1"
+	batchv1 "k8s.io/client-go/kubernetes/typed/batch/v1"
 	v1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	storagev1 "k8s.io/client-go/kubernetes/typed/storage/v1"
+	"k8s.io/client-go/rest"
 )
 
 type KubeCore interface {
 	v1.CoreV1Interface
 	v2.VolumeSnapshotsGetter
+	v2.VolumeSnapshotContentsGetter
+	v2.VolumeSnapshotClassesGetter
+	appsv1.DeploymentsGetter
+	appsv1.StatefulSetsGetter
+	appsv1.ReplicaSetsGetter
+	appsv1.DaemonSetsGetter
+	batchv1.JobsGetter
+	batchv1.CronJobsGetter
+	storagev1.StorageClassesGetter
+	storagev1.VolumeAttachmentsGetter
+	storagev1.CSIDriversGetter
+	authorizationv1.SelfSubjectAccessReviewsGetter
+	discovery.ServerGroupsInterface
+	discovery.ServerResourcesInterface
+	RESTConfig() *rest.Config
 }
 type Core struct {
-	pod *pod
-	pvc *pvc
+	pod      *pod
+	pvc      *pvc
+	pv       *pv
+	node     *node
+	events   *events
+	workload *workload
+	auth     *auth
+	storage  *storage
+	cleanup  *cleanup
 }
 
 func NewCore(log *slog.Logger, ctx context.Context, cl KubeCore) *Core {
 	var c Core
 
 	c.pod = &pod{
-		ctx:  ctx,
-		log:  log.WithGroup("pod"),
-		core: cl,
+		log:        log.WithGroup("pod"),
+		core:       cl,
+		restConfig: cl.RESTConfig(),
 	}
 
 	c.pvc = &pvc{
-		ctx:  ctx,
-		log:  log.WithGroup("pvc"),
+		log:     log.WithGroup("pvc"),
+		core:    cl,
+		snap:    cl,
+		storage: cl,
+	}
+
+	c.pv = &pv{
+		log:     log.WithGroup("pv"),
+		core:    cl,
+		storage: cl,
+	}
+
+	c.node = &node{
+		log:  log.WithGroup("node"),
+		core: cl,
+	}
+
+	c.events = &events{
 		core: cl,
-		snap: cl,
+	}
+
+	c.workload = &workload{
+		log:  log.WithGroup("workload"),
+		apps: cl,
+	}
+
+	c.auth = &auth{
+		log:     log.WithGroup("auth"),
+		reviews: cl,
+	}
+
+	c.storage = &storage{
+		log:       log.WithGroup("storage"),
+		discovery: cl,
+		apps:      cl,
+		classes:   cl,
+		snap:      cl,
+	}
+
+	c.cleanup = &cleanup{
+		ctx: ctx,
+		log: log.WithGroup("cleanup"),
 	}
 
 	return &c
@@ -43,3 +109,31 @@ func (c *Core) Pod() PodManager {
 func (c *Core) PVC() PVCManager {
 	return c.pvc
 }
+
+func (c *Core) PV() PVManager {
+	return c.pv
+}
+
+func (c *Core) Node() NodeManager {
+	return c.node
+}
+
+func (c *Core) Events() EventManager {
+	return c.events
+}
+
+func (c *Core) Workload() WorkloadManager {
+	return c.workload
+}
+
+func (c *Core) Auth() AuthManager {
+	return c.auth
+}
+
+func (c *Core) Storage() StorageManager {
+	return c.storage
+}
+
+func (c *Core) Cleanup() CleanupManager {
+	return c.cleanup
+}
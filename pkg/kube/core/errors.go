@@ -0,0 +1,86 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// Kinds of the errors returned by the managers, matched with errors.Is.
+// API status errors are wrapped into the matching kind, so errors.As still finds the original *apierrors.StatusError
+var (
+	ErrNotFound         = errors.New("not found")
+	ErrAlreadyExists    = errors.New("already exists")
+	ErrTimeout          = errors.New("timeout")
+	ErrNotReady         = errors.New("not ready")
+	ErrForbidden        = errors.New("forbidden")
+	ErrValidationFailed = errors.New("validation failed")
+)
+
+// errorKinds is the order kinds are looked up in, for errors joining failures of different kinds
+var errorKinds = []error{ErrForbidden, ErrNotFound, ErrAlreadyExists, ErrValidationFailed, ErrTimeout, ErrNotReady}
+
+// Error is a failure of a known kind. Its message is the one of the wrapped error
+type Error struct {
+	Kind error
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+// Errorf formats an error of the given kind, wrapping the %w arguments like fmt.Errorf
+func Errorf(kind error, format string, args ...any) error {
+	return &Error{Kind: kind, Err: fmt.Errorf(format, args...)}
+}
+
+// KindOf returns the kind of the error, classifying the API status errors and deadlines which were not wrapped yet,
+// or nil if the kind is unknown
+func KindOf(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	for _, kind := range errorKinds {
+		if errors.Is(err, kind) {
+			return kind
+		}
+	}
+
+	switch {
+	case apierrors.IsForbidden(err), apierrors.IsUnauthorized(err):
+		return ErrForbidden
+	case apierrors.IsNotFound(err):
+		return ErrNotFound
+	case apierrors.IsAlreadyExists(err), apierrors.IsConflict(err):
+		return ErrAlreadyExists
+	case apierrors.IsInvalid(err), apierrors.IsBadRequest(err):
+		return ErrValidationFailed
+	case apierrors.IsTimeout(err), apierrors.IsServerTimeout(err), errors.Is(err, context.DeadlineExceeded):
+		return ErrTimeout
+	default:
+		return nil
+	}
+}
+
+// apiError wraps an API status error into an *Error of the matching kind, other errors are returned as is
+func apiError(err error) error {
+	var typed *Error
+	if err == nil || errors.As(err, &typed) {
+		return err
+	}
+
+	kind := KindOf(err)
+	if kind == nil {
+		return err
+	}
+
+	return &Error{Kind: kind, Err: err}
+}
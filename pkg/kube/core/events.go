@@ -0,0 +1,97 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	v1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+type EventManager interface {
+	// Recent returns up to limit latest events recorded for the object with the given uid, oldest first.
+	// Events of cluster scoped objects are found with an empty namespace
+	Recent(ctx context.Context, namespace string, uid types.UID, limit int) ([]Event, error)
+}
+
+// Event is an event recorded for an object
+type Event struct {
+	Time    time.Time `json:"time"`
+	Type    string    `json:"type"`
+	Reason  string    `json:"reason"`
+	Message string    `json:"message"`
+	Count   int32     `json:"count,omitempty"`
+}
+
+type events struct {
+	core v1.EventsGetter
+}
+
+func (ev *events) Recent(ctx context.Context, namespace string, uid types.UID, limit int) ([]Event, error) {
+	list, err := recentEvents(ctx, ev.core, namespace, uid, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]Event, 0, len(list))
+	for _, e := range list {
+		out = append(out, Event{Time: eventTime(e), Type: e.Type, Reason: e.Reason, Message: strings.TrimSpace(e.Message), Count: e.Count})
+	}
+
+	return out, nil
+}
+
+// recentEvents returns up to limit latest events recorded for the object with the given uid,
+// ordered from the oldest to the newest
+func recentEvents(ctx context.Context, cl v1.EventsGetter, namespace string, uid types.UID, limit int) ([]corev1.Event, error) {
+	list, err := cl.Events(namespace).List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("involvedObject.uid", string(uid)).String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not list events: %w", apiError(err))
+	}
+
+	events := list.Items
+	sort.Slice(events, func(i, j int) bool {
+		return eventTime(events[i]).Before(eventTime(events[j]))
+	})
+
+	if limit > 0 && len(events) > limit {
+		events = events[len(events)-limit:]
+	}
+
+	return events, nil
+}
+
+// eventTime returns the most accurate time an event was last observed at
+func eventTime(e corev1.Event) time.Time {
+	switch {
+	case !e.LastTimestamp.IsZero():
+		return e.LastTimestamp.Time
+	case !e.EventTime.IsZero():
+		return e.EventTime.Time
+	default:
+		return e.CreationTimestamp.Time
+	}
+}
+
+// formatEvents renders events as an indented list, suitable for appending to an error message
+func formatEvents(events []corev1.Event) string {
+	if len(events) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteString("\nrecent events:")
+	for _, e := range events {
+		sb.WriteString(fmt.Sprintf("\n  %s %s %s: %s", eventTime(e).Format(time.RFC3339), e.Type, e.Reason, strings.TrimSpace(e.Message)))
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,98 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	v1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+type NodeManager interface {
+	// VolumeStats fetches the usage of the pvcs mounted on the node from the kubelet stats summary,
+	// read through the API server node proxy
+	VolumeStats(ctx context.Context, nodeName string) ([]VolumeStats, error)
+}
+
+// VolumeStats is the filesystem usage of a pvc mounted by a pod, as reported by the kubelet
+type VolumeStats struct {
+	Namespace      string
+	PVCName        string
+	Pod            string
+	CapacityBytes  int64
+	UsedBytes      int64
+	AvailableBytes int64
+}
+
+type node struct {
+	log  *slog.Logger
+	core v1.CoreV1Interface
+}
+
+// statsSummary is the subset of the kubelet stats summary describing pod volumes
+type statsSummary struct {
+	Pods []struct {
+		PodRef struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"podRef"`
+		VolumeStats []struct {
+			Name   string `json:"name"`
+			PVCRef *struct {
+				Name      string `json:"name"`
+				Namespace string `json:"namespace"`
+			} `json:"pvcRef,omitempty"`
+			CapacityBytes  *int64 `json:"capacityBytes,omitempty"`
+			UsedBytes      *int64 `json:"usedBytes,omitempty"`
+			AvailableBytes *int64 `json:"availableBytes,omitempty"`
+		} `json:"volume,omitempty"`
+	} `json:"pods"`
+}
+
+func (n *node) VolumeStats(ctx context.Context, nodeName string) ([]VolumeStats, error) {
+	n.log.Debug("fetching node stats summary", "node", nodeName)
+
+	raw, err := n.core.RESTClient().Get().
+		Resource("nodes").
+		Name(nodeName).
+		SubResource("proxy").
+		Suffix("stats", "summary").
+		DoRaw(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not get stats summary of node %s: %w", nodeName, apiError(err))
+	}
+
+	var summary statsSummary
+	if err = json.Unmarshal(raw, &summary); err != nil {
+		return nil, fmt.Errorf("invalid stats summary of node %s: %w", nodeName, err)
+	}
+
+	var stats []VolumeStats
+	for _, po := range summary.Pods {
+		for _, vol := range po.VolumeStats {
+			if vol.PVCRef == nil {
+				continue
+			}
+
+			stats = append(stats, VolumeStats{
+				Namespace:      vol.PVCRef.Namespace,
+				PVCName:        vol.PVCRef.Name,
+				Pod:            po.PodRef.Name,
+				CapacityBytes:  valueOf(vol.CapacityBytes),
+				UsedBytes:      valueOf(vol.UsedBytes),
+				AvailableBytes: valueOf(vol.AvailableBytes),
+			})
+		}
+	}
+
+	return stats, nil
+}
+
+func valueOf(v *int64) int64 {
+	if v == nil {
+		return 0
+	}
+
+	return *v
+}
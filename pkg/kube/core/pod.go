@@ -2,42 +2,115 @@ package core
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"net/http"
+	"net/url"
 	"os"
-	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/httpstream"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes/scheme"
 	v1 "k8s.io/client-go/kubernetes/typed/core/v1"
-	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/portforward"
 	"k8s.io/client-go/tools/remotecommand"
-	"k8s.io/client-go/util/homedir"
+	watchtools "k8s.io/client-go/tools/watch"
+	"k8s.io/client-go/transport/spdy"
+	utilexec "k8s.io/client-go/util/exec"
+)
+
+const (
+	// NetshootImage is the image of the pods kmon runs, bundling the common network and storage tools
+	NetshootImage = "ghcr.io/nicolaka/netshoot:v0.14"
+	// netshootContainerName is the name of the ephemeral containers kmon adds to pods, suffixed by a number
+	// once a previous one has terminated, as ephemeral containers can not be removed
+	netshootContainerName = "kmon-netshoot"
+	// netshootLifetime bounds how long an ephemeral netshoot container keeps running
+	netshootLifetime = time.Hour
 )
 
 type PodManager interface {
 	// Create will create a pod in a specified name in a specified namespace.
-	// PodOptions are not specified, a default nicolaka/netshoot contianer will be created
-	Create(namespace string, name string, ops ...PodOptions) (*corev1.Pod, error)
+	// PodOptions are not specified, a default nicolaka/netshoot contianer will be created.
+	// An existing pod created by kmon with the same spec is reused, in which case the returned bool is false,
+	// while a pod with a different spec results in a *SpecMismatchError
+	Create(ctx context.Context, namespace string, name string, ops ...PodOptions) (*corev1.Pod, bool, error)
+	// Replace deletes the existing pod, if any, and creates it again.
+	// A pod not created by kmon is never deleted, resulting in an unmanaged *SpecMismatchError instead
+	Replace(ctx context.Context, namespace string, name string, ops ...PodOptions) (*corev1.Pod, error)
 	// Delete deletes a pod in specified namespace with a specified name
-	Delete(namespace, name string) error
+	Delete(ctx context.Context, namespace, name string) error
 	// Exec runs a specified command within a pod in a specified namespace with a specified name
 	// and outputs it onto stdout
-	Exec(namespace string, name string, cmd []string) error
-	// WaitReady waits for the pod the become ready before proceeding
-	WaitReady(namespace string, name string, timeoutSeconds int) error
+	Exec(ctx context.Context, namespace string, name string, cmd []string) error
+	// WaitReady waits for the pod and all of its containers to become ready before proceeding.
+	// It fails fast on states the pod will not recover from and includes the latest pod events in the error
+	WaitReady(ctx context.Context, namespace string, name string, timeoutSeconds int) error
 	// WaitDeleted waits for the pod to get deleted before proceeding
-	WaitDeleted(namespace string, name string, timeoutSeconds int) error
+	WaitDeleted(ctx context.Context, namespace string, name string, timeoutSeconds int) error
+	// ExecStream runs a non-interactive command within a pod, connecting the provided streams to it.
+	// A nil stream is not attached
+	ExecStream(ctx context.Context, namespace string, name string, cmd []string, stdin io.Reader, stdout, stderr io.Writer) error
+	// ExecCapture runs a non-interactive command within a container of a pod, the default one if container is empty,
+	// and captures its output. A non-zero exit code is part of the result, errors are failures to run the command
+	ExecCapture(ctx context.Context, namespace, name, container string, cmd []string) (ExecResult, error)
+	// ListByPVC lists the pods in the namespace which mount the specified pvc
+	ListByPVC(ctx context.Context, namespace, pvcName string) ([]corev1.Pod, error)
+	// List lists the pods in the namespace, or in all namespaces if it is empty
+	List(ctx context.Context, namespace string) ([]corev1.Pod, error)
+	// ListBySelector lists the pods in the namespace matching the label selector, e.g. app=web
+	ListBySelector(ctx context.Context, namespace, selector string) ([]corev1.Pod, error)
+	// AddNetshootContainer adds an ephemeral netshoot container to the pod, sharing its network namespace,
+	// and waits for it to run. A running netshoot container added before is reused. Returns the container name
+	AddNetshootContainer(ctx context.Context, namespace, name string, timeoutSeconds int) (string, error)
+	// WatchBySelector calls handle with every pod matching the label selector as it gets added, modified or deleted,
+	// starting with the existing pods. It blocks until ctx is done
+	WatchBySelector(ctx context.Context, namespace, selector string, handle func(watch.EventType, *corev1.Pod)) error
+	// Logs streams the logs of a container of the pod
+	Logs(ctx context.Context, namespace, name string, opts LogOptions) (io.ReadCloser, error)
+	// Forward forwards local ports to ports of a pod, written as [local]:remote like for kubectl port-forward,
+	// listening on the addresses. ready is called with the bound ports once they listen.
+	// It blocks until ctx is done or the connection to the pod is lost, which is an ErrNotReady error
+	Forward(ctx context.Context, namespace, name string, addresses, ports []string, ready func([]ForwardedPort)) error
+}
+
+// LogOptions selects the logs of a container. Follow keeps streaming until the container stops,
+// a positive Since limits the logs to the ones newer than it
+type LogOptions struct {
+	Container string
+	Follow    bool
+	Since     time.Duration
+}
+
+// ExecResult is the output and exit code of a command run within a container
+type ExecResult struct {
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+	ExitCode int    `json:"exitCode"`
+}
+
+// ForwardedPort is a local port forwarded to a port of a pod
+type ForwardedPort struct {
+	Local  uint16 `json:"local"`
+	Remote uint16 `json:"remote"`
 }
 
 type pod struct {
-	ctx  context.Context
-	log  *slog.Logger
-	core v1.CoreV1Interface
+	log        *slog.Logger
+	core       v1.CoreV1Interface
+	restConfig *rest.Config
 }
 
 type PodOptions func(*corev1.Pod)
@@ -53,6 +126,41 @@ func WithAnnotations(annotations map[string]string) PodOptions {
 	}
 }
 
+// WithGenerateName lets the API server generate a unique pod name, using the requested name as a prefix
+func WithGenerateName() PodOptions {
+	return func(pod *corev1.Pod) {
+		pod.GenerateName = pod.Name + "-"
+		pod.Name = ""
+	}
+}
+
+// WithNodeName schedules the pod onto the specified node, e.g. next to the pod already using a ReadWriteOnce volume
+func WithNodeName(nodeName string) PodOptions {
+	return func(pod *corev1.Pod) {
+		pod.Spec.NodeName = nodeName
+	}
+}
+
+// WithVolume adds a pvc volume mounted into the first container, keeping the already configured volumes
+func WithVolume(volumeName, mountPath, pvcName string, readOnly bool) PodOptions {
+	return func(pod *corev1.Pod) {
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+			Name: volumeName,
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+					ClaimName: pvcName,
+				},
+			},
+		})
+
+		pod.Spec.Containers[0].VolumeMounts = append(pod.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+			Name:      volumeName,
+			MountPath: mountPath,
+			ReadOnly:  readOnly,
+		})
+	}
+}
+
 func WithPVC(volumeName, mountPath, pvcName string) PodOptions {
 	return func(pod *corev1.Pod) {
 		pod.Spec.Volumes = []corev1.Volume{
@@ -75,9 +183,66 @@ func WithPVC(volumeName, mountPath, pvcName string) PodOptions {
 	}
 }
 
-func (p *pod) Create(namespace string, name string, opts ...PodOptions) (*corev1.Pod, error) {
+func (p *pod) Create(ctx context.Context, namespace string, name string, opts ...PodOptions) (*corev1.Pod, bool, error) {
 	p.log.Info("creating pod", "namespace", namespace, "name", name)
 
+	def := p.definition(namespace, name, opts...)
+
+	po, created, err := getOrCreate(ctx, "pod", def, comparedSpec(def), p.client(namespace))
+	if err == nil && !created {
+		p.log.Info("reusing existing pod", "namespace", namespace, "name", name)
+	}
+
+	return po, created, apiError(err)
+}
+
+func (p *pod) Replace(ctx context.Context, namespace string, name string, opts ...PodOptions) (*corev1.Pod, error) {
+	p.log.Info("replacing pod", "namespace", namespace, "name", name)
+
+	existing, err := p.core.Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+	switch {
+	case err == nil && !isManaged(existing):
+		return nil, &SpecMismatchError{Kind: "pod", Namespace: namespace, Name: name, Unmanaged: true}
+	case err != nil && !apierrors.IsNotFound(err):
+		return nil, apiError(err)
+	}
+
+	if err := p.Delete(ctx, namespace, name); err != nil && !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+
+	if err := p.WaitDeleted(ctx, namespace, name, 60); err != nil {
+		return nil, err
+	}
+
+	def := p.definition(namespace, name, opts...)
+	po, _, err := getOrCreate(ctx, "pod", def, comparedSpec(def), p.client(namespace))
+
+	return po, apiError(err)
+}
+
+// comparedSpec is the part of the pod spec an existing pod has to match to be reused. The node is left out,
+// as it follows the consumers of the volumes, which move between nodes
+func comparedSpec(def *corev1.Pod) corev1.PodSpec {
+	spec := *def.Spec.DeepCopy()
+	spec.NodeName = ""
+
+	return spec
+}
+
+func (p *pod) client(namespace string) objectClient[*corev1.Pod] {
+	return objectClient[*corev1.Pod]{
+		get: func(ctx context.Context, name string) (*corev1.Pod, error) {
+			return p.core.Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+		},
+		create: func(ctx context.Context, obj *corev1.Pod) (*corev1.Pod, error) {
+			return p.core.Pods(namespace).Create(ctx, obj, metav1.CreateOptions{})
+		},
+	}
+}
+
+// definition builds the pod kmon creates by default, with the options applied
+func (p *pod) definition(namespace string, name string, opts ...PodOptions) *corev1.Pod {
 	podDefinition := &corev1.Pod{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      name,
@@ -87,7 +252,7 @@ func (p *pod) Create(namespace string, name string, opts ...PodOptions) (*corev1
 			Containers: []corev1.Container{
 				{
 					Name:    "netshoot",
-					Image:   "ghcr.io/nicolaka/netshoot:v0.14",
+					Image:   NetshootImage,
 					Command: []string{"tail", "-f", "/dev/null"},
 				},
 			},
@@ -98,82 +263,198 @@ func (p *pod) Create(namespace string, name string, opts ...PodOptions) (*corev1
 		opt(podDefinition)
 	}
 
-	return p.core.Pods(namespace).Create(p.ctx, podDefinition, metav1.CreateOptions{})
+	return podDefinition
 }
 
-func (p *pod) WaitReady(namespace string, name string, timeoutSec int) error {
-	p.log.Info("waiting for pod to become ready", "namespace", namespace, "name", name)
+// failFastReasons are container, scheduling and event reasons which will not resolve on their own,
+// so there is no point in waiting for the pod to become ready once any of them is observed
+var failFastReasons = map[string]bool{
+	"ImagePullBackOff":            true,
+	"CrashLoopBackOff":            true,
+	corev1.PodReasonUnschedulable: true,
+	"FailedMount":                 true,
+}
 
-	podSelector := fields.SelectorFromSet(fields.Set{
-		"metadata.name":      name,
-		"metadata.namespace": namespace,
-	})
+func (p *pod) WaitReady(ctx context.Context, namespace string, name string, timeoutSec int) error {
+	p.log.Info("waiting for pod to become ready", "namespace", namespace, "name", name)
 
-	watcher, err := p.core.Pods(namespace).Watch(p.ctx, metav1.ListOptions{
-		FieldSelector: podSelector.String(),
-	})
+	po, err := p.core.Pods(namespace).Get(ctx, name, metav1.GetOptions{})
 	if err != nil {
-		return fmt.Errorf("could not watch pod: %w", err)
+		return fmt.Errorf("could not get pod: %w", apiError(err))
 	}
 
-	for {
-		select {
-		case event := <-watcher.ResultChan():
-			if event.Type == watch.Added || event.Type == watch.Modified {
-				if event.Object.(*corev1.Pod).Status.Phase == corev1.PodRunning {
-					return nil
-				}
+	timeoutCtx, cancelTimeout := context.WithTimeoutCause(
+		ctx,
+		time.Second*time.Duration(timeoutSec),
+		Errorf(ErrTimeout, "timeout waiting for pod to become ready after %ds", timeoutSec),
+	)
+	defer cancelTimeout()
+
+	waitCtx, cancel := context.WithCancelCause(timeoutCtx)
+	defer cancel(nil)
+
+	go p.watchFailFastEvents(waitCtx, cancel, po)
+
+	_, err = watchtools.UntilWithSync(waitCtx, nameListWatch(p.core.Pods(namespace), name), &corev1.Pod{}, nil, func(event watch.Event) (bool, error) {
+		switch event.Type {
+		case watch.Deleted:
+			return false, fmt.Errorf("pod was deleted while waiting for it to become ready")
+		case watch.Added, watch.Modified:
+			po, ok := event.Object.(*corev1.Pod)
+			if !ok {
+				return false, nil
 			}
-		case <-time.After(time.Second * time.Duration(timeoutSec)):
-			return fmt.Errorf("timeout waiting for pod to become running")
+
+			return podReady(po)
+		default:
+			return false, nil
 		}
+	})
+	if err != nil {
+		if waitCtx.Err() != nil {
+			err = context.Cause(waitCtx)
+		}
+
+		events, evErr := recentEvents(context.WithoutCancel(ctx), p.core, namespace, po.UID, 5)
+		if evErr != nil {
+			p.log.Warn("could not fetch pod events", "namespace", namespace, "name", name, "err", evErr)
+		}
+
+		kind := ErrNotReady
+		if errors.Is(err, ErrTimeout) {
+			kind = ErrTimeout
+		}
+
+		return Errorf(kind, "pod %s/%s is not ready: %w%s", namespace, name, err, formatEvents(events))
 	}
+
+	return nil
 }
 
-func (p *pod) WaitDeleted(namespace string, name string, timeoutSec int) error {
-	p.log.Info("waiting for pod to be deleted", "namespace", namespace, "name", name)
+// watchFailFastEvents cancels the wait as soon as a warning event with one of the failFastReasons
+// is recorded for the pod, as some failures, like FailedMount, are not visible in the pod status
+func (p *pod) watchFailFastEvents(ctx context.Context, cancel context.CancelCauseFunc, po *corev1.Pod) {
+	lw := fieldSelectorListWatch(p.core.Events(po.Namespace), fields.OneTermEqualSelector("involvedObject.uid", string(po.UID)))
 
-	podSelector := fields.SelectorFromSet(fields.Set{
-		"metadata.name":      name,
-		"metadata.namespace": namespace,
-	})
+	_, err := watchtools.UntilWithSync(ctx, lw, &corev1.Event{}, nil, func(event watch.Event) (bool, error) {
+		e, ok := event.Object.(*corev1.Event)
+		if !ok || event.Type == watch.Deleted {
+			return false, nil
+		}
 
-	watcher, err := p.core.Pods(namespace).Watch(p.ctx, metav1.ListOptions{
-		FieldSelector: podSelector.String(),
+		if e.Type == corev1.EventTypeWarning && failFastReasons[e.Reason] {
+			return true, fmt.Errorf("%s: %s", e.Reason, e.Message)
+		}
+
+		return false, nil
 	})
-	if err != nil {
-		return fmt.Errorf("could not watch pod: %w", err)
+	if err != nil && ctx.Err() == nil {
+		cancel(err)
 	}
+}
 
-	for {
-		select {
-		case event := <-watcher.ResultChan():
-			if event.Type == watch.Deleted {
-				return nil
-			}
-		case <-time.After(time.Second * time.Duration(timeoutSec)):
-			return fmt.Errorf("timeout waiting for pod to be deleted")
+// IsReady reports whether the pod is running with all of its containers ready
+func IsReady(po *corev1.Pod) bool {
+	ready, _ := podReady(po)
+	return ready
+}
+
+// podReady reports whether the pod is running with all of its containers ready,
+// or returns an error if the pod is in a state it will not recover from
+func podReady(po *corev1.Pod) (bool, error) {
+	switch po.Status.Phase {
+	case corev1.PodFailed, corev1.PodSucceeded:
+		return false, fmt.Errorf("pod terminated with phase %s: %s", po.Status.Phase, po.Status.Message)
+	}
+
+	for _, cond := range po.Status.Conditions {
+		if cond.Type == corev1.PodScheduled && cond.Status == corev1.ConditionFalse && failFastReasons[cond.Reason] {
+			return false, fmt.Errorf("%s: %s", cond.Reason, cond.Message)
+		}
+	}
+
+	for _, cs := range slices.Concat(po.Status.InitContainerStatuses, po.Status.ContainerStatuses) {
+		if w := cs.State.Waiting; w != nil && failFastReasons[w.Reason] {
+			return false, fmt.Errorf("container %s: %s: %s", cs.Name, w.Reason, w.Message)
 		}
 	}
+
+	if po.Status.Phase != corev1.PodRunning {
+		return false, nil
+	}
+
+	for _, cond := range po.Status.Conditions {
+		if cond.Type == corev1.PodReady {
+			return cond.Status == corev1.ConditionTrue, nil
+		}
+	}
+
+	return false, nil
 }
 
-func (p *pod) Delete(namespace, name string) error {
+func (p *pod) WaitDeleted(ctx context.Context, namespace string, name string, timeoutSec int) error {
+	p.log.Info("waiting for pod to be deleted", "namespace", namespace, "name", name)
+
+	ctx, cancel := context.WithTimeoutCause(
+		ctx,
+		time.Second*time.Duration(timeoutSec),
+		Errorf(ErrTimeout, "timeout waiting for pod to be deleted after %ds", timeoutSec),
+	)
+	defer cancel()
+
+	err := waitDeleted(ctx, nameListWatch(p.core.Pods(namespace), name), &corev1.Pod{}, namespace, name)
+	if err != nil {
+		return fmt.Errorf("pod %s/%s was not deleted: %w", namespace, name, err)
+	}
+
+	return nil
+}
+
+func (p *pod) Delete(ctx context.Context, namespace, name string) error {
 	p.log.Info("deleting pod", "namespace", namespace, "name", name)
-	return p.core.Pods(namespace).Delete(p.ctx, name, metav1.DeleteOptions{})
+	return apiError(p.core.Pods(namespace).Delete(ctx, name, metav1.DeleteOptions{}))
 }
 
-func (p *pod) Exec(namespace string, name string, cmd []string) error {
+func (p *pod) Exec(ctx context.Context, namespace string, name string, cmd []string) error {
 	p.log.Info("executing pod", "namespace", namespace, "name", name)
 
+	return p.stream(ctx, namespace, name, "", cmd, true, os.Stdin, os.Stdout, os.Stderr)
+}
+
+func (p *pod) ExecStream(ctx context.Context, namespace string, name string, cmd []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	p.log.Debug("streaming pod exec", "namespace", namespace, "name", name, "cmd", cmd)
+
+	return p.stream(ctx, namespace, name, "", cmd, false, stdin, stdout, stderr)
+}
+
+func (p *pod) ExecCapture(ctx context.Context, namespace, name, container string, cmd []string) (ExecResult, error) {
+	p.log.Debug("capturing pod exec", "namespace", namespace, "name", name, "container", container, "cmd", cmd)
+
+	var stdout, stderr strings.Builder
+	err := p.stream(ctx, namespace, name, container, cmd, false, nil, &stdout, &stderr)
+
+	result := ExecResult{Stdout: stdout.String(), Stderr: stderr.String()}
+
+	var exitErr utilexec.ExitError
+	if errors.As(err, &exitErr) && exitErr.Exited() {
+		result.ExitCode = exitErr.ExitStatus()
+		return result, nil
+	}
+
+	return result, err
+}
+
+func (p *pod) stream(ctx context.Context, namespace string, name string, container string, cmd []string, tty bool, stdin io.Reader, stdout, stderr io.Writer) error {
 	req := p.core.RESTClient().Post().Resource("pods").
 		Name(name).Namespace(namespace).SubResource("exec")
 
 	option := &corev1.PodExecOptions{
-		Command: cmd,
-		Stdin:   true,
-		Stdout:  true,
-		Stderr:  true,
-		TTY:     true,
+		Container: container,
+		Command:   cmd,
+		Stdin:     stdin != nil,
+		Stdout:    stdout != nil,
+		Stderr:    stderr != nil,
+		TTY:       tty,
 	}
 
 	req.VersionedParams(
@@ -181,24 +462,294 @@ func (p *pod) Exec(namespace string, name string, cmd []string) error {
 		scheme.ParameterCodec,
 	)
 
-	// TODO: should work from within the cluster
-	client, err := clientcmd.BuildConfigFromFlags("", filepath.Join(homedir.HomeDir(), ".kube", "config"))
+	exec, err := remotecommand.NewSPDYExecutor(p.restConfig, "POST", req.URL())
 	if err != nil {
-		return fmt.Errorf("could not build kube client config: %w", err)
+		return fmt.Errorf("spdy executor failed: %w", err)
+	}
+	err = exec.StreamWithContext(ctx, remotecommand.StreamOptions{
+		Stdin:  stdin,
+		Stdout: stdout,
+		Stderr: stderr,
+		Tty:    tty,
+	})
+	if err != nil {
+		return fmt.Errorf("streaming failed: %w", apiError(err))
 	}
 
-	exec, err := remotecommand.NewSPDYExecutor(client, "POST", req.URL())
+	return nil
+}
+
+func (p *pod) Forward(ctx context.Context, namespace, name string, addresses, ports []string, ready func([]ForwardedPort)) error {
+	p.log.Info("forwarding pod ports", "namespace", namespace, "name", name, "ports", ports)
+
+	req := p.core.RESTClient().Post().Resource("pods").
+		Name(name).Namespace(namespace).SubResource("portforward")
+
+	dialer, err := p.portForwardDialer(req.URL())
 	if err != nil {
-		return fmt.Errorf("spdy executor failed: %w", err)
+		return err
+	}
+
+	stop, readyCh := make(chan struct{}), make(chan struct{})
+	fw, err := portforward.NewOnAddresses(dialer, addresses, ports, stop, readyCh, io.Discard, logWriter{log: p.log})
+	if err != nil {
+		return Errorf(ErrValidationFailed, "invalid port forwarding: %w", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- fw.ForwardPorts() }()
+
+	select {
+	case <-readyCh:
+	case err := <-done:
+		if dialer.err != nil {
+			return Errorf(ErrNotReady, "could not connect to pod %s/%s: %w", namespace, name, apiError(dialer.err))
+		}
+		return fmt.Errorf("port forwarding to pod %s/%s failed: %w", namespace, name, err)
+	case <-ctx.Done():
+		close(stop)
+		return <-done
+	}
+
+	forwarded, err := fw.GetPorts()
+	if err != nil {
+		close(stop)
+		return errors.Join(err, <-done)
+	}
+
+	bound := make([]ForwardedPort, 0, len(forwarded))
+	for _, port := range forwarded {
+		bound = append(bound, ForwardedPort{Local: port.Local, Remote: port.Remote})
+	}
+	ready(bound)
+
+	select {
+	case <-ctx.Done():
+		close(stop)
+		return <-done
+	case err := <-done:
+		if err == nil {
+			return nil
+		}
+		return Errorf(ErrNotReady, "port forwarding to pod %s/%s stopped: %w", namespace, name, err)
+	}
+}
+
+// portForwardDialer tunnels the port forwarding through a websocket, falling back to SPDY
+// for API servers which do not support it, like kubectl does
+func (p *pod) portForwardDialer(u *url.URL) (*recordingDialer, error) {
+	transport, upgrader, err := spdy.RoundTripperFor(p.restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("spdy round tripper failed: %w", err)
+	}
+	spdyDialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, u)
+
+	websocketDialer, err := portforward.NewSPDYOverWebsocketDialer(u, p.restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("websocket dialer failed: %w", err)
+	}
+
+	return &recordingDialer{Dialer: portforward.NewFallbackDialer(websocketDialer, spdyDialer, func(err error) bool {
+		return httpstream.IsUpgradeFailure(err) || httpstream.IsHTTPSProxyError(err)
+	})}, nil
+}
+
+// recordingDialer keeps the error of the dial, which the port forwarder only reports as text,
+// to tell a pod which can not be reached from ports which can not be listened on
+type recordingDialer struct {
+	httpstream.Dialer
+	err error
+}
+
+func (d *recordingDialer) Dial(protocols ...string) (httpstream.Connection, string, error) {
+	conn, protocol, err := d.Dialer.Dial(protocols...)
+	d.err = err
+
+	return conn, protocol, err
+}
+
+// logWriter logs the lines written to it as warnings, e.g. the failures of single forwarded connections
+type logWriter struct {
+	log *slog.Logger
+}
+
+func (w logWriter) Write(b []byte) (int, error) {
+	w.log.Warn(strings.TrimSpace(string(b)))
+
+	return len(b), nil
+}
+
+func (p *pod) List(ctx context.Context, namespace string) ([]corev1.Pod, error) {
+	list, err := p.core.Pods(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not list pods: %w", apiError(err))
+	}
+
+	return list.Items, nil
+}
+
+func (p *pod) ListBySelector(ctx context.Context, namespace, selector string) ([]corev1.Pod, error) {
+	if _, err := labels.Parse(selector); err != nil {
+		return nil, Errorf(ErrValidationFailed, "invalid selector %q: %w", selector, err)
+	}
+
+	list, err := p.core.Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("could not list pods: %w", apiError(err))
+	}
+
+	return list.Items, nil
+}
+
+func (p *pod) AddNetshootContainer(ctx context.Context, namespace, name string, timeoutSec int) (string, error) {
+	po, err := p.core.Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("could not get pod: %w", apiError(err))
+	}
+
+	statuses := map[string]corev1.ContainerStatus{}
+	for _, cs := range po.Status.EphemeralContainerStatuses {
+		statuses[cs.Name] = cs
+	}
+
+	container := netshootContainerName
+	for i := 1; ; i++ {
+		if !slices.ContainsFunc(po.Spec.EphemeralContainers, func(c corev1.EphemeralContainer) bool { return c.Name == container }) {
+			break
+		}
+		if cs, ok := statuses[container]; !ok || cs.State.Terminated == nil {
+			p.log.Info("reusing ephemeral container", "namespace", namespace, "name", name, "container", container)
+			return container, p.waitContainerRunning(ctx, namespace, name, container, timeoutSec)
+		}
+
+		container = fmt.Sprintf("%s-%d", netshootContainerName, i)
 	}
-	err = exec.StreamWithContext(p.ctx, remotecommand.StreamOptions{
-		Stdin:  os.Stdin,
-		Stdout: os.Stdout,
-		Stderr: os.Stderr,
+
+	p.log.Info("adding ephemeral container", "namespace", namespace, "name", name, "container", container)
+
+	po.Spec.EphemeralContainers = append(po.Spec.EphemeralContainers, corev1.EphemeralContainer{
+		EphemeralContainerCommon: corev1.EphemeralContainerCommon{
+			Name:    container,
+			Image:   NetshootImage,
+			Command: []string{"sleep", strconv.Itoa(int(netshootLifetime.Seconds()))},
+		},
 	})
+	if _, err := p.core.Pods(namespace).UpdateEphemeralContainers(ctx, name, po, metav1.UpdateOptions{}); err != nil {
+		return "", fmt.Errorf("could not add ephemeral container: %w", apiError(err))
+	}
+
+	return container, p.waitContainerRunning(ctx, namespace, name, container, timeoutSec)
+}
+
+// waitContainerRunning waits for the ephemeral container of the pod to run
+func (p *pod) waitContainerRunning(ctx context.Context, namespace, name, container string, timeoutSec int) error {
+	ctx, cancel := context.WithTimeoutCause(
+		ctx,
+		time.Second*time.Duration(timeoutSec),
+		Errorf(ErrTimeout, "timeout waiting for container %s of pod %s/%s after %ds", container, namespace, name, timeoutSec),
+	)
+	defer cancel()
+
+	_, err := watchtools.UntilWithSync(ctx, nameListWatch(p.core.Pods(namespace), name), &corev1.Pod{}, nil, func(event watch.Event) (bool, error) {
+		if event.Type == watch.Deleted {
+			return false, Errorf(ErrNotFound, "pod %s/%s was deleted", namespace, name)
+		}
+
+		po, ok := event.Object.(*corev1.Pod)
+		if !ok {
+			return false, nil
+		}
+
+		for _, cs := range po.Status.EphemeralContainerStatuses {
+			if cs.Name != container {
+				continue
+			}
+			if w := cs.State.Waiting; w != nil && failFastReasons[w.Reason] {
+				return false, Errorf(ErrNotReady, "container %s: %s: %s", container, w.Reason, w.Message)
+			}
+			if cs.State.Terminated != nil {
+				return false, Errorf(ErrNotReady, "container %s terminated: %s", container, cs.State.Terminated.Reason)
+			}
+
+			return cs.State.Running != nil, nil
+		}
+
+		return false, nil
+	})
+	if err != nil && ctx.Err() != nil {
+		err = context.Cause(ctx)
+	}
+
+	return apiError(err)
+}
+
+func (p *pod) WatchBySelector(ctx context.Context, namespace, selector string, handle func(watch.EventType, *corev1.Pod)) error {
+	if _, err := labels.Parse(selector); err != nil {
+		return Errorf(ErrValidationFailed, "invalid selector %q: %w", selector, err)
+	}
+
+	p.log.Info("watching pods", "namespace", namespace, "selector", selector)
+
+	_, err := watchtools.UntilWithSync(ctx, labelSelectorListWatch(p.core.Pods(namespace), selector), &corev1.Pod{}, nil, func(event watch.Event) (bool, error) {
+		if po, ok := event.Object.(*corev1.Pod); ok {
+			handle(event.Type, po)
+		}
+
+		return false, nil
+	})
+	if err != nil && ctx.Err() != nil {
+		return nil
+	}
+
+	return apiError(err)
+}
+
+func (p *pod) Logs(ctx context.Context, namespace, name string, opts LogOptions) (io.ReadCloser, error) {
+	p.log.Debug("streaming pod logs", "namespace", namespace, "name", name, "container", opts.Container, "follow", opts.Follow)
+
+	logOpts := &corev1.PodLogOptions{Container: opts.Container, Follow: opts.Follow}
+	if opts.Since > 0 {
+		since := max(int64(opts.Since.Seconds()), 1)
+		logOpts.SinceSeconds = &since
+	}
+
+	stream, err := p.core.Pods(namespace).GetLogs(name, logOpts).Stream(ctx)
 	if err != nil {
-		return fmt.Errorf("streaming failed: %w", err)
+		return nil, fmt.Errorf("could not stream logs of pod %s/%s container %s: %w", namespace, name, opts.Container, apiError(err))
 	}
 
-	return nil
+	return stream, nil
+}
+
+func (p *pod) ListByPVC(ctx context.Context, namespace, pvcName string) ([]corev1.Pod, error) {
+	list, err := p.core.Pods(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not list pods: %w", apiError(err))
+	}
+
+	var pods []corev1.Pod
+	for _, po := range list.Items {
+		if podUsesPVC(&po, pvcName) {
+			pods = append(pods, po)
+		}
+	}
+
+	return pods, nil
+}
+
+// podUsesPVC reports whether the pod mounts the named pvc
+func podUsesPVC(po *corev1.Pod, pvcName string) bool {
+	return slices.Contains(PVCNames(po.Spec), pvcName)
+}
+
+// PVCNames lists the names of the pvcs mounted by the pod spec
+func PVCNames(spec corev1.PodSpec) []string {
+	var names []string
+	for _, vol := range spec.Volumes {
+		if vol.PersistentVolumeClaim != nil {
+			names = append(names, vol.PersistentVolumeClaim.ClaimName)
+		}
+	}
+
+	return names
 }
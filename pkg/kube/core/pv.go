@@ -0,0 +1,140 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	v1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	storagev1client "k8s.io/client-go/kubernetes/typed/storage/v1"
+)
+
+type PVManager interface {
+	// Get fetches a PersistentVolume
+	Get(ctx context.Context, name string) (*corev1.PersistentVolume, error)
+	// List lists all PersistentVolumes
+	List(ctx context.Context) ([]corev1.PersistentVolume, error)
+	// Delete deletes a PersistentVolume
+	Delete(ctx context.Context, name string) error
+	// SetReclaimPolicy changes the reclaim policy of the PersistentVolume and returns the previous one
+	SetReclaimPolicy(ctx context.Context, name string, policy corev1.PersistentVolumeReclaimPolicy) (corev1.PersistentVolumeReclaimPolicy, error)
+	// Claim reserves a PersistentVolume for the named PVC, dropping the reference to the uid of a previous claim,
+	// so a Released volume becomes Available to a PVC created with the same name
+	Claim(ctx context.Context, name, namespace, claimName string) error
+	// Attachments lists the VolumeAttachments of the PersistentVolume
+	Attachments(ctx context.Context, name string) ([]storagev1.VolumeAttachment, error)
+	// SetClaimRef replaces the claim reference of a PersistentVolume, e.g. to restore it after a failed Claim
+	SetClaimRef(ctx context.Context, name string, ref *corev1.ObjectReference) error
+}
+
+type pv struct {
+	log     *slog.Logger
+	core    v1.CoreV1Interface
+	storage storagev1client.VolumeAttachmentsGetter
+}
+
+func (p *pv) Get(ctx context.Context, name string) (*corev1.PersistentVolume, error) {
+	p.log.Info("getting pv", "name", name)
+
+	vol, err := p.core.PersistentVolumes().Get(ctx, name, metav1.GetOptions{})
+
+	return vol, apiError(err)
+}
+
+func (p *pv) List(ctx context.Context) ([]corev1.PersistentVolume, error) {
+	list, err := p.core.PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not list pvs: %w", apiError(err))
+	}
+
+	return list.Items, nil
+}
+
+func (p *pv) Delete(ctx context.Context, name string) error {
+	p.log.Info("deleting pv", "name", name)
+
+	return apiError(p.core.PersistentVolumes().Delete(ctx, name, metav1.DeleteOptions{}))
+}
+
+func (p *pv) SetReclaimPolicy(ctx context.Context, name string, policy corev1.PersistentVolumeReclaimPolicy) (corev1.PersistentVolumeReclaimPolicy, error) {
+	p.log.Info("setting pv reclaim policy", "name", name, "policy", policy)
+
+	vol, err := p.Get(ctx, name)
+	if err != nil {
+		return "", err
+	}
+
+	previous := vol.Spec.PersistentVolumeReclaimPolicy
+	if previous == policy {
+		return previous, nil
+	}
+
+	err = p.patch(ctx, name, map[string]any{
+		"spec": map[string]any{"persistentVolumeReclaimPolicy": policy},
+	})
+
+	return previous, err
+}
+
+func (p *pv) Claim(ctx context.Context, name, namespace, claimName string) error {
+	p.log.Info("claiming pv", "name", name, "namespace", namespace, "claim", claimName)
+
+	return p.patch(ctx, name, map[string]any{
+		"spec": map[string]any{
+			"claimRef": map[string]any{
+				"kind":            "PersistentVolumeClaim",
+				"apiVersion":      "v1",
+				"namespace":       namespace,
+				"name":            claimName,
+				"uid":             nil,
+				"resourceVersion": nil,
+			},
+		},
+	})
+}
+
+func (p *pv) Attachments(ctx context.Context, name string) ([]storagev1.VolumeAttachment, error) {
+	list, err := p.storage.VolumeAttachments().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not list volume attachments: %w", apiError(err))
+	}
+
+	var attachments []storagev1.VolumeAttachment
+	for _, va := range list.Items {
+		if pvName := va.Spec.Source.PersistentVolumeName; pvName != nil && *pvName == name {
+			attachments = append(attachments, va)
+		}
+	}
+
+	return attachments, nil
+}
+
+func (p *pv) SetClaimRef(ctx context.Context, name string, ref *corev1.ObjectReference) error {
+	p.log.Info("setting pv claim reference", "name", name)
+
+	vol, err := p.Get(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	vol.Spec.ClaimRef = ref
+	_, err = p.core.PersistentVolumes().Update(ctx, vol, metav1.UpdateOptions{})
+
+	return apiError(err)
+}
+
+func (p *pv) patch(ctx context.Context, name string, patch map[string]any) error {
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+
+	_, err = p.core.PersistentVolumes().Patch(ctx, name, types.MergePatchType, data, metav1.PatchOptions{})
+
+	return apiError(err)
+}
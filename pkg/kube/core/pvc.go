@@ -2,33 +2,76 @@ package core
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log/slog"
+	"time"
 
 	v3 "github.com/kubernetes-csi/external-snapshotter/client/v8/apis/volumesnapshot/v1"
 	v2 "github.com/kubernetes-csi/external-snapshotter/client/v8/clientset/versioned/typed/volumesnapshot/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
 	v1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	storagev1 "k8s.io/client-go/kubernetes/typed/storage/v1"
+	"k8s.io/client-go/tools/cache"
+	watchtools "k8s.io/client-go/tools/watch"
 )
 
 type PVCManager interface {
 	// Get fetches a PVC
-	Get(namespace, name string) (*corev1.PersistentVolumeClaim, error)
-	// Create creates a PVC
-	Create(namespace, name string, opts ...PVCOptions) (*corev1.PersistentVolumeClaim, error)
+	Get(ctx context.Context, namespace, name string) (*corev1.PersistentVolumeClaim, error)
+	// List lists the PVCs in the namespace, or in all namespaces if it is empty
+	List(ctx context.Context, namespace string) ([]corev1.PersistentVolumeClaim, error)
+	// Create creates a PVC. An existing PVC created by kmon with the same spec is reused,
+	// in which case the returned bool is false, while a PVC with a different spec results in a *SpecMismatchError
+	Create(ctx context.Context, namespace, name string, opts ...PVCOptions) (*corev1.PersistentVolumeClaim, bool, error)
+	// CreateFromSnapshot creates a PVC restored from the specified VolumeSnapshot,
+	// sized by the snapshot restore size and using the storage class of the snapshot source PVC, if it still exists
+	CreateFromSnapshot(ctx context.Context, namespace, name, snapshotName string, opts ...PVCOptions) (*corev1.PersistentVolumeClaim, bool, error)
+	// Replace deletes the existing PVC, if any, and creates it again.
+	// A PVC not created by kmon is never deleted, resulting in an unmanaged *SpecMismatchError instead
+	Replace(ctx context.Context, namespace, name string, opts ...PVCOptions) (*corev1.PersistentVolumeClaim, error)
 	// Delete deletes a PVC
-	Delete(namespace, name string) error
-	// CreateVolumeSnapshotFromPVC crates a new PVC using the provided snapshot class name and snapshot name
-	CreateVolumeSnapshotFromPVC(namespace string, name string, snapshotClassName string, sourcePVCName string) (*v3.VolumeSnapshot, error)
+	Delete(ctx context.Context, namespace, name string) error
+	// WaitDeleted waits for the PVC to get deleted before proceeding
+	WaitDeleted(ctx context.Context, namespace, name string, timeoutSeconds int) error
+	// WaitBound waits for the PVC to get bound to a PersistentVolume and returns it
+	WaitBound(ctx context.Context, namespace, name string, timeoutSeconds int) (*corev1.PersistentVolumeClaim, error)
+	// WaitFor waits until cond is met by the PVC, returning the last observed state of the PVC even if it is not
+	WaitFor(ctx context.Context, namespace, name string, timeoutSeconds int, cond func(*corev1.PersistentVolumeClaim) (bool, error)) (*corev1.PersistentVolumeClaim, error)
+	// Resize requests a new size for the PVC. It fails if the StorageClass does not allow volume expansion
+	// or the PVC would shrink, requesting the current size is a no-op
+	Resize(ctx context.Context, namespace, name string, size resource.Quantity) (*corev1.PersistentVolumeClaim, error)
+	// CreateVolumeSnapshotFromPVC creates a VolumeSnapshot of the source PVC, named by the snapshot name as a prefix.
+	// An empty snapshot class name selects the VolumeSnapshotClass of the CSI driver of the PVC
+	CreateVolumeSnapshotFromPVC(ctx context.Context, namespace string, name string, snapshotClassName string, sourcePVCName string) (*v3.VolumeSnapshot, error)
+	// GetVolumeSnapshot fetches a VolumeSnapshot
+	GetVolumeSnapshot(ctx context.Context, namespace, name string) (*v3.VolumeSnapshot, error)
+	// ListVolumeSnapshots lists the VolumeSnapshots in the namespace, or in all namespaces if it is empty
+	ListVolumeSnapshots(ctx context.Context, namespace string) ([]v3.VolumeSnapshot, error)
+	// DeleteVolumeSnapshot deletes a VolumeSnapshot
+	DeleteVolumeSnapshot(ctx context.Context, namespace, name string) error
+	// ListVolumeSnapshotContents lists all VolumeSnapshotContents
+	ListVolumeSnapshotContents(ctx context.Context) ([]v3.VolumeSnapshotContent, error)
+	// DeleteVolumeSnapshotContent deletes a VolumeSnapshotContent
+	DeleteVolumeSnapshotContent(ctx context.Context, name string) error
+}
+
+type snapshotClient interface {
+	v2.VolumeSnapshotsGetter
+	v2.VolumeSnapshotContentsGetter
+	v2.VolumeSnapshotClassesGetter
 }
 
 type pvc struct {
-	ctx  context.Context
-	log  *slog.Logger
-	core v1.CoreV1Interface
-	snap v2.VolumeSnapshotsGetter
+	log     *slog.Logger
+	core    v1.CoreV1Interface
+	snap    snapshotClient
+	storage storagev1.StorageClassesGetter
 }
 type PVCOptions func(*corev1.PersistentVolumeClaim)
 
@@ -37,6 +80,43 @@ func WithStorageClassName(storageClassName string) PVCOptions {
 		pvc.Spec.StorageClassName = &storageClassName
 	}
 }
+
+// WithPVCGenerateName lets the API server generate a unique PVC name, using the requested name as a prefix
+func WithPVCGenerateName() PVCOptions {
+	return func(pvc *corev1.PersistentVolumeClaim) {
+		pvc.GenerateName = pvc.Name + "-"
+		pvc.Name = ""
+	}
+}
+
+// WithStorageSize sets the requested storage size, which defaults to 5Gi
+func WithStorageSize(size resource.Quantity) PVCOptions {
+	return func(pvc *corev1.PersistentVolumeClaim) {
+		pvc.Spec.Resources.Requests[corev1.ResourceStorage] = size
+	}
+}
+
+// WithAccessModes sets the access modes, which default to ReadWriteOnce
+func WithAccessModes(modes ...corev1.PersistentVolumeAccessMode) PVCOptions {
+	return func(pvc *corev1.PersistentVolumeClaim) {
+		pvc.Spec.AccessModes = modes
+	}
+}
+
+// WithVolumeMode sets the volume mode, which defaults to Filesystem
+func WithVolumeMode(mode corev1.PersistentVolumeMode) PVCOptions {
+	return func(pvc *corev1.PersistentVolumeClaim) {
+		pvc.Spec.VolumeMode = &mode
+	}
+}
+
+// WithVolumeName binds the PVC to the specified PersistentVolume instead of provisioning a new one
+func WithVolumeName(volumeName string) PVCOptions {
+	return func(pvc *corev1.PersistentVolumeClaim) {
+		pvc.Spec.VolumeName = volumeName
+	}
+}
+
 func WithRestoreFromVolumeSnapshot(snapshotName string) PVCOptions {
 	apiGr := "snapshot.storage.k8s.io"
 
@@ -49,23 +129,245 @@ func WithRestoreFromVolumeSnapshot(snapshotName string) PVCOptions {
 	}
 }
 
-func (p *pvc) Get(namespace, name string) (*corev1.PersistentVolumeClaim, error) {
+func (p *pvc) Get(ctx context.Context, namespace, name string) (*corev1.PersistentVolumeClaim, error) {
 	p.log.Info("getting pvc", "namespace", namespace, "name", name)
 
-	return p.core.PersistentVolumeClaims(namespace).Get(p.ctx, name, metav1.GetOptions{})
+	claim, err := p.core.PersistentVolumeClaims(namespace).Get(ctx, name, metav1.GetOptions{})
+
+	return claim, apiError(err)
+}
+
+func (p *pvc) CreateFromSnapshot(ctx context.Context, namespace, name, snapshotName string, opts ...PVCOptions) (*corev1.PersistentVolumeClaim, bool, error) {
+	vs, err := p.GetVolumeSnapshot(ctx, namespace, snapshotName)
+	if err != nil {
+		return nil, false, fmt.Errorf("could not get volume snapshot: %w", err)
+	}
+
+	if st := vs.Status; st != nil && st.Error != nil && st.Error.Message != nil {
+		return nil, false, Errorf(ErrNotReady, "volume snapshot %s/%s failed: %s", namespace, snapshotName, *st.Error.Message)
+	}
+	if st := vs.Status; st == nil || st.ReadyToUse == nil || !*st.ReadyToUse {
+		return nil, false, Errorf(ErrNotReady, "volume snapshot %s/%s is not ready to use yet", namespace, snapshotName)
+	}
+
+	restoreOpts := []PVCOptions{WithRestoreFromVolumeSnapshot(snapshotName)}
+
+	if vs.Status != nil && vs.Status.RestoreSize != nil {
+		restoreOpts = append(restoreOpts, WithStorageSize(*vs.Status.RestoreSize))
+	}
+
+	// the restored volume has to be provisioned by the same driver the snapshot was taken with,
+	// which is most likely to be the storage class of the source pvc
+	if source := vs.Spec.Source.PersistentVolumeClaimName; source != nil {
+		src, err := p.core.PersistentVolumeClaims(namespace).Get(ctx, *source, metav1.GetOptions{})
+		if err == nil && src.Spec.StorageClassName != nil {
+			restoreOpts = append(restoreOpts, WithStorageClassName(*src.Spec.StorageClassName))
+		}
+	}
+
+	return p.Create(ctx, namespace, name, append(restoreOpts, opts...)...)
 }
 
-func (p *pvc) CreateFromSnapshot() {
+func (p *pvc) List(ctx context.Context, namespace string) ([]corev1.PersistentVolumeClaim, error) {
+	list, err := p.core.PersistentVolumeClaims(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not list pvcs: %w", apiError(err))
+	}
+
+	return list.Items, nil
+}
+
+func (p *pvc) ListVolumeSnapshots(ctx context.Context, namespace string) ([]v3.VolumeSnapshot, error) {
+	list, err := p.snap.VolumeSnapshots(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not list volume snapshots: %w", apiError(err))
+	}
 
+	return list.Items, nil
 }
 
-func (p *pvc) Create(namespace, name string, opts ...PVCOptions) (*corev1.PersistentVolumeClaim, error) {
+func (p *pvc) GetVolumeSnapshot(ctx context.Context, namespace, name string) (*v3.VolumeSnapshot, error) {
+	p.log.Info("getting volume snapshot", "namespace", namespace, "name", name)
+
+	vs, err := p.snap.VolumeSnapshots(namespace).Get(ctx, name, metav1.GetOptions{})
+
+	return vs, apiError(err)
+}
+
+func (p *pvc) Create(ctx context.Context, namespace, name string, opts ...PVCOptions) (*corev1.PersistentVolumeClaim, bool, error) {
 	p.log.Info("creating pvc", "namespace", namespace, "name", name)
 
+	def := p.definition(namespace, name, opts...)
+
+	pvc, created, err := getOrCreate(ctx, "pvc", def, def.Spec, p.client(namespace))
+	if err == nil && !created {
+		p.log.Info("reusing existing pvc", "namespace", namespace, "name", name)
+	}
+
+	return pvc, created, apiError(err)
+}
+
+func (p *pvc) Replace(ctx context.Context, namespace, name string, opts ...PVCOptions) (*corev1.PersistentVolumeClaim, error) {
+	p.log.Info("replacing pvc", "namespace", namespace, "name", name)
+
+	existing, err := p.core.PersistentVolumeClaims(namespace).Get(ctx, name, metav1.GetOptions{})
+	switch {
+	case err == nil && !isManaged(existing):
+		return nil, &SpecMismatchError{Kind: "pvc", Namespace: namespace, Name: name, Unmanaged: true}
+	case err != nil && !apierrors.IsNotFound(err):
+		return nil, apiError(err)
+	}
+
+	if err := p.Delete(ctx, namespace, name); err != nil && !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+
+	if err := p.WaitDeleted(ctx, namespace, name, 60); err != nil {
+		return nil, err
+	}
+
+	def := p.definition(namespace, name, opts...)
+	pvc, _, err := getOrCreate(ctx, "pvc", def, def.Spec, p.client(namespace))
+
+	return pvc, apiError(err)
+}
+
+func (p *pvc) WaitDeleted(ctx context.Context, namespace, name string, timeoutSec int) error {
+	p.log.Info("waiting for pvc to be deleted", "namespace", namespace, "name", name)
+
+	ctx, cancel := context.WithTimeoutCause(
+		ctx,
+		time.Second*time.Duration(timeoutSec),
+		Errorf(ErrTimeout, "timeout waiting for pvc to be deleted after %ds", timeoutSec),
+	)
+	defer cancel()
+
+	err := waitDeleted(ctx, nameListWatch(p.core.PersistentVolumeClaims(namespace), name), &corev1.PersistentVolumeClaim{}, namespace, name)
+	if err != nil {
+		return fmt.Errorf("pvc %s/%s was not deleted: %w", namespace, name, err)
+	}
+
+	return nil
+}
+
+func (p *pvc) WaitBound(ctx context.Context, namespace, name string, timeoutSec int) (*corev1.PersistentVolumeClaim, error) {
+	p.log.Info("waiting for pvc to be bound", "namespace", namespace, "name", name)
+
+	pvc, err := p.WaitFor(ctx, namespace, name, timeoutSec, func(claim *corev1.PersistentVolumeClaim) (bool, error) {
+		return claim.Status.Phase == corev1.ClaimBound, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("pvc %s/%s was not bound: %w", namespace, name, err)
+	}
+
+	return pvc, nil
+}
+
+func (p *pvc) WaitFor(ctx context.Context, namespace, name string, timeoutSec int, cond func(*corev1.PersistentVolumeClaim) (bool, error)) (*corev1.PersistentVolumeClaim, error) {
+	ctx, cancel := context.WithTimeoutCause(
+		ctx,
+		time.Second*time.Duration(timeoutSec),
+		Errorf(ErrTimeout, "timeout waiting for pvc after %ds: %w", timeoutSec, context.DeadlineExceeded),
+	)
+	defer cancel()
+
+	var last *corev1.PersistentVolumeClaim
+	check := func(obj any) (bool, error) {
+		claim, ok := obj.(*corev1.PersistentVolumeClaim)
+		if !ok {
+			return false, nil
+		}
+
+		last = claim
+		return cond(claim)
+	}
+
+	_, err := watchtools.UntilWithSync(ctx, nameListWatch(p.core.PersistentVolumeClaims(namespace), name), &corev1.PersistentVolumeClaim{},
+		func(store cache.Store) (bool, error) {
+			obj, exists, err := store.GetByKey(namespace + "/" + name)
+			if err != nil || !exists {
+				return false, err
+			}
+
+			return check(obj)
+		},
+		func(event watch.Event) (bool, error) {
+			if event.Type == watch.Deleted {
+				return false, Errorf(ErrNotFound, "pvc %s/%s was deleted", namespace, name)
+			}
+
+			return check(event.Object)
+		},
+	)
+	if err != nil && ctx.Err() != nil {
+		err = context.Cause(ctx)
+	}
+
+	return last, apiError(err)
+}
+
+func (p *pvc) Resize(ctx context.Context, namespace, name string, size resource.Quantity) (*corev1.PersistentVolumeClaim, error) {
+	p.log.Info("resizing pvc", "namespace", namespace, "name", name, "size", size.String())
+
+	claim, err := p.Get(ctx, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+
+	current := claim.Spec.Resources.Requests[corev1.ResourceStorage]
+	switch size.Cmp(current) {
+	case 0:
+		return claim, nil
+	case -1:
+		return nil, Errorf(ErrValidationFailed, "pvc %s/%s can not be shrunk from %s to %s", namespace, name, current.String(), size.String())
+	}
+
+	if claim.Spec.StorageClassName == nil || *claim.Spec.StorageClassName == "" {
+		return nil, Errorf(ErrValidationFailed, "pvc %s/%s has no storage class, its volume can not be expanded", namespace, name)
+	}
+
+	sc, err := p.storage.StorageClasses().Get(ctx, *claim.Spec.StorageClassName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not get storage class of pvc %s/%s: %w", namespace, name, apiError(err))
+	}
+	if sc.AllowVolumeExpansion == nil || !*sc.AllowVolumeExpansion {
+		return nil, Errorf(ErrValidationFailed, "storage class %s does not allow volume expansion", sc.Name)
+	}
+
+	patch, err := json.Marshal(map[string]any{
+		"spec": map[string]any{
+			"resources": map[string]any{
+				"requests": map[string]any{string(corev1.ResourceStorage): size.String()},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	claim, err = p.core.PersistentVolumeClaims(namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+
+	return claim, apiError(err)
+}
+
+func (p *pvc) client(namespace string) objectClient[*corev1.PersistentVolumeClaim] {
+	return objectClient[*corev1.PersistentVolumeClaim]{
+		get: func(ctx context.Context, name string) (*corev1.PersistentVolumeClaim, error) {
+			return p.core.PersistentVolumeClaims(namespace).Get(ctx, name, metav1.GetOptions{})
+		},
+		create: func(ctx context.Context, obj *corev1.PersistentVolumeClaim) (*corev1.PersistentVolumeClaim, error) {
+			return p.core.PersistentVolumeClaims(namespace).Create(ctx, obj, metav1.CreateOptions{})
+		},
+	}
+}
+
+// definition builds the PVC kmon creates by default, with the options applied
+func (p *pvc) definition(namespace, name string, opts ...PVCOptions) *corev1.PersistentVolumeClaim {
 	pvcObject := &corev1.PersistentVolumeClaim{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      name,
 			Namespace: namespace,
+			Labels:    map[string]string{ManagedByLabel: ManagedBy},
 		},
 		Spec: corev1.PersistentVolumeClaimSpec{
 			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
@@ -81,39 +383,107 @@ func (p *pvc) Create(namespace, name string, opts ...PVCOptions) (*corev1.Persis
 		opt(pvcObject)
 	}
 
-	return p.core.PersistentVolumeClaims(namespace).Create(p.ctx, pvcObject, metav1.CreateOptions{})
+	return pvcObject
 }
 
-func (p *pvc) Delete(namespace, name string) error {
+func (p *pvc) Delete(ctx context.Context, namespace, name string) error {
 	p.log.Info("deleting pvc", "namespace", namespace, "name", name)
 
-	return p.core.PersistentVolumeClaims(namespace).Delete(p.ctx, name, metav1.DeleteOptions{})
+	return apiError(p.core.PersistentVolumeClaims(namespace).Delete(ctx, name, metav1.DeleteOptions{}))
 }
 
-func (p *pvc) CreateVolumeSnapshotFromPVC(namespace string, name string, snapshotClassName string, sourcePVCName string) (*v3.VolumeSnapshot, error) {
-	p.log.Info("creating pvc", "namespace", namespace, "name", name)
-
-	var snapClassName *string
-	if snapshotClassName != "" {
-		snapClassName = &snapshotClassName
+func (p *pvc) CreateVolumeSnapshotFromPVC(ctx context.Context, namespace string, name string, snapshotClassName string, sourcePVCName string) (*v3.VolumeSnapshot, error) {
+	if snapshotClassName == "" {
+		var err error
+		if snapshotClassName, err = p.snapshotClassFor(ctx, namespace, sourcePVCName); err != nil {
+			return nil, err
+		}
 	}
 
-	vs, err := p.snap.VolumeSnapshots(namespace).Create(p.ctx, &v3.VolumeSnapshot{
+	p.log.Info("creating volume snapshot", "namespace", namespace, "name", name, "snapshotClass", snapshotClassName)
+
+	vs, err := p.snap.VolumeSnapshots(namespace).Create(ctx, &v3.VolumeSnapshot{
 		ObjectMeta: metav1.ObjectMeta{
 			GenerateName: fmt.Sprintf("%s-", name),
 			Namespace:    namespace,
+			Labels:       map[string]string{ManagedByLabel: ManagedBy},
 		},
 		Spec: v3.VolumeSnapshotSpec{
 			Source: v3.VolumeSnapshotSource{
 				PersistentVolumeClaimName: &sourcePVCName,
 			},
-			VolumeSnapshotClassName: snapClassName,
+			VolumeSnapshotClassName: &snapshotClassName,
 		},
 		Status: nil,
 	}, metav1.CreateOptions{})
 	if err != nil {
-		return nil, err
+		return nil, apiError(err)
 	}
 
 	return vs, nil
 }
+
+// snapshotClassFor selects the VolumeSnapshotClass of the CSI driver of the PVC, taken from its PersistentVolume,
+// or from its StorageClass while it is not bound yet
+func (p *pvc) snapshotClassFor(ctx context.Context, namespace, name string) (string, error) {
+	claim, err := p.Get(ctx, namespace, name)
+	if err != nil {
+		return "", err
+	}
+
+	var driver string
+	switch {
+	case claim.Spec.VolumeName != "":
+		vol, err := p.core.PersistentVolumes().Get(ctx, claim.Spec.VolumeName, metav1.GetOptions{})
+		if err != nil {
+			return "", apiError(err)
+		}
+		if vol.Spec.CSI == nil {
+			return "", Errorf(ErrValidationFailed, "pvc %s/%s is not backed by a csi driver, which is required for snapshots", namespace, name)
+		}
+		driver = vol.Spec.CSI.Driver
+	case claim.Spec.StorageClassName != nil && *claim.Spec.StorageClassName != "":
+		sc, err := p.storage.StorageClasses().Get(ctx, *claim.Spec.StorageClassName, metav1.GetOptions{})
+		if err != nil {
+			return "", apiError(err)
+		}
+		driver = sc.Provisioner
+	default:
+		return "", Errorf(ErrValidationFailed, "could not find the csi driver of pvc %s/%s, set the snapshot class name", namespace, name)
+	}
+
+	classes, err := listSnapshotClasses(ctx, p.snap)
+	if err != nil {
+		return "", err
+	}
+
+	className, err := SelectSnapshotClass(classes, driver)
+	if err != nil {
+		return "", fmt.Errorf("could not select a volume snapshot class for pvc %s/%s: %w", namespace, name, err)
+	}
+
+	p.log.Info("selected volume snapshot class", "driver", driver, "snapshotClass", className)
+
+	return className, nil
+}
+
+func (p *pvc) DeleteVolumeSnapshot(ctx context.Context, namespace, name string) error {
+	p.log.Info("deleting volume snapshot", "namespace", namespace, "name", name)
+
+	return apiError(p.snap.VolumeSnapshots(namespace).Delete(ctx, name, metav1.DeleteOptions{}))
+}
+
+func (p *pvc) ListVolumeSnapshotContents(ctx context.Context) ([]v3.VolumeSnapshotContent, error) {
+	list, err := p.snap.VolumeSnapshotContents().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not list volume snapshot contents: %w", apiError(err))
+	}
+
+	return list.Items, nil
+}
+
+func (p *pvc) DeleteVolumeSnapshotContent(ctx context.Context, name string) error {
+	p.log.Info("deleting volume snapshot content", "name", name)
+
+	return apiError(p.snap.VolumeSnapshotContents().Delete(ctx, name, metav1.DeleteOptions{}))
+}
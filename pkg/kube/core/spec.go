@@ -0,0 +1,181 @@
+package core
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// SpecHashAnnotation holds the hash of the spec kmon created the object with
+	SpecHashAnnotation = "kmon.io/spec-hash"
+	// AppliedSpecAnnotation holds the spec kmon created the object with, used to explain spec mismatches
+	AppliedSpecAnnotation = "kmon.io/applied-spec"
+	// ManagedByLabel marks the pods, pvcs and snapshots kmon creates, with the value ManagedBy
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+	ManagedBy      = "kmon"
+)
+
+// SpecMismatchError is returned when an object with the requested name already exists,
+// but was either not created by kmon or was created with a different spec
+type SpecMismatchError struct {
+	Kind      string
+	Namespace string
+	Name      string
+	// Unmanaged is set when the existing object carries no kmon spec annotations
+	Unmanaged bool
+	// Diff lists the differences between the existing and the requested spec
+	Diff []string
+}
+
+func (e *SpecMismatchError) Error() string {
+	if e.Unmanaged {
+		return fmt.Sprintf("%s %s/%s already exists and is not managed by kmon", e.Kind, e.Namespace, e.Name)
+	}
+
+	return fmt.Sprintf("%s %s/%s already exists with a different spec:\n  %s", e.Kind, e.Namespace, e.Name, strings.Join(e.Diff, "\n  "))
+}
+
+// Is makes a spec mismatch match ErrAlreadyExists
+func (e *SpecMismatchError) Is(target error) bool {
+	return target == ErrAlreadyExists
+}
+
+// objectClient is the subset of a typed client getOrCreate needs
+type objectClient[T metav1.Object] struct {
+	get    func(ctx context.Context, name string) (T, error)
+	create func(ctx context.Context, obj T) (T, error)
+}
+
+// getOrCreate creates the desired object, or reuses an existing object with the same name if it was created
+// by kmon with the same spec. Objects which only have generateName set are always created.
+// The returned bool reports whether the object was created by this call
+func getOrCreate[T metav1.Object](ctx context.Context, kind string, desired T, spec any, cl objectClient[T]) (T, bool, error) {
+	var zero T
+
+	specJSON, err := json.Marshal(spec)
+	if err != nil {
+		return zero, false, fmt.Errorf("could not marshal %s spec: %w", kind, err)
+	}
+
+	hash := specHash(specJSON)
+
+	annotations := maps.Clone(desired.GetAnnotations())
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[SpecHashAnnotation] = hash
+	annotations[AppliedSpecAnnotation] = string(specJSON)
+	desired.SetAnnotations(annotations)
+
+	if desired.GetName() == "" {
+		created, err := cl.create(ctx, desired)
+		return created, err == nil, err
+	}
+
+	existing, err := cl.get(ctx, desired.GetName())
+	if apierrors.IsNotFound(err) {
+		created, err := cl.create(ctx, desired)
+		return created, err == nil, err
+	}
+	if err != nil {
+		return zero, false, err
+	}
+
+	if existing.GetAnnotations()[SpecHashAnnotation] == hash {
+		return existing, false, nil
+	}
+
+	mismatch := &SpecMismatchError{
+		Kind:      kind,
+		Namespace: desired.GetNamespace(),
+		Name:      desired.GetName(),
+	}
+
+	if !isManaged(existing) {
+		mismatch.Unmanaged = true
+		return zero, false, mismatch
+	}
+
+	mismatch.Diff, err = specDiff([]byte(existing.GetAnnotations()[AppliedSpecAnnotation]), specJSON)
+	if err != nil {
+		return zero, false, fmt.Errorf("could not compare %s specs: %w", kind, err)
+	}
+
+	return zero, false, mismatch
+}
+
+// isManaged reports whether the object was created by kmon, holding the spec it was created with
+func isManaged(obj metav1.Object) bool {
+	_, ok := obj.GetAnnotations()[AppliedSpecAnnotation]
+	return ok
+}
+
+func specHash(specJSON []byte) string {
+	sum := sha256.Sum256(specJSON)
+	return hex.EncodeToString(sum[:8])
+}
+
+// specDiff compares two JSON documents field by field and returns the differences as "path: old -> new" lines
+func specDiff(existingJSON, desiredJSON []byte) ([]string, error) {
+	var existing, desired any
+	if err := json.Unmarshal(existingJSON, &existing); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(desiredJSON, &desired); err != nil {
+		return nil, err
+	}
+
+	oldFields, newFields := map[string]string{}, map[string]string{}
+	flatten("spec", existing, oldFields)
+	flatten("spec", desired, newFields)
+
+	keys := slices.Sorted(maps.Keys(oldFields))
+	for k := range newFields {
+		if _, ok := oldFields[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+
+	var diff []string
+	for _, k := range keys {
+		o, inOld := oldFields[k]
+		n, inNew := newFields[k]
+
+		switch {
+		case !inNew:
+			diff = append(diff, fmt.Sprintf("- %s: %s", k, o))
+		case !inOld:
+			diff = append(diff, fmt.Sprintf("+ %s: %s", k, n))
+		case o != n:
+			diff = append(diff, fmt.Sprintf("~ %s: %s -> %s", k, o, n))
+		}
+	}
+
+	return diff, nil
+}
+
+func flatten(prefix string, v any, out map[string]string) {
+	switch val := v.(type) {
+	case map[string]any:
+		for k, child := range val {
+			flatten(prefix+"."+k, child, out)
+		}
+	case []any:
+		for i, child := range val {
+			flatten(fmt.Sprintf("%s[%d]", prefix, i), child, out)
+		}
+	default:
+		b, _ := json.Marshal(val)
+		out[prefix] = string(b)
+	}
+}
@@ -0,0 +1,234 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	v3 "github.com/kubernetes-csi/external-snapshotter/client/v8/apis/volumesnapshot/v1"
+	v2 "github.com/kubernetes-csi/external-snapshotter/client/v8/clientset/versioned/typed/volumesnapshot/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/discovery"
+	appsv1 "k8s.io/client-go/kubernetes/typed/apps/v1"
+	storagev1client "k8s.io/client-go/kubernetes/typed/storage/v1"
+)
+
+const (
+	// SnapshotGroup is the API group of the VolumeSnapshot CRDs
+	SnapshotGroup = "snapshot.storage.k8s.io"
+	// SnapshotVersion is the version of the snapshot API kmon works with
+	SnapshotVersion = "v1"
+
+	// DefaultSnapshotClassAnnotation marks the VolumeSnapshotClass used for snapshots of its driver not naming a class
+	DefaultSnapshotClassAnnotation = "snapshot.storage.kubernetes.io/is-default-class"
+	// DefaultStorageClassAnnotation marks the StorageClass used for PVCs not naming a class
+	DefaultStorageClassAnnotation = "storageclass.kubernetes.io/is-default-class"
+
+	// snapshotControllerImage is part of the image name of the common snapshot controller,
+	// e.g. registry.k8s.io/sig-storage/snapshot-controller
+	snapshotControllerImage = "snapshot-controller"
+)
+
+// SnapshotResources are the resources of the snapshot API kmon needs
+var SnapshotResources = []string{"volumesnapshots", "volumesnapshotcontents", "volumesnapshotclasses"}
+
+type StorageManager interface {
+	// SnapshotAPI discovers the served versions of the snapshot API and the resources of SnapshotVersion
+	SnapshotAPI(ctx context.Context) (SnapshotAPI, error)
+	// SnapshotControllers lists the Deployments running a snapshot controller, in all namespaces.
+	// Managed clusters may run it on the control plane, where it can not be found
+	SnapshotControllers(ctx context.Context) ([]SnapshotController, error)
+	// CSIDrivers lists the registered CSI drivers
+	CSIDrivers(ctx context.Context) ([]storagev1.CSIDriver, error)
+	// StorageClasses lists all StorageClasses
+	StorageClasses(ctx context.Context) ([]storagev1.StorageClass, error)
+	// VolumeSnapshotClasses lists all VolumeSnapshotClasses
+	VolumeSnapshotClasses(ctx context.Context) ([]v3.VolumeSnapshotClass, error)
+}
+
+// SnapshotAPI is the discovered state of the snapshot API, which is not installed if it has no versions
+type SnapshotAPI struct {
+	Versions         []string `json:"versions"`
+	PreferredVersion string   `json:"preferredVersion,omitempty"`
+	Resources        []string `json:"resources"`
+}
+
+// Missing returns the SnapshotResources which are not served
+func (s SnapshotAPI) Missing() []string {
+	var missing []string
+	for _, r := range SnapshotResources {
+		if !slices.Contains(s.Resources, r) {
+			missing = append(missing, r)
+		}
+	}
+
+	return missing
+}
+
+// SnapshotController is a Deployment running a snapshot controller
+type SnapshotController struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Image     string `json:"image"`
+	Replicas  int32  `json:"replicas"`
+	Ready     int32  `json:"ready"`
+}
+
+type storageClient interface {
+	storagev1client.StorageClassesGetter
+	storagev1client.CSIDriversGetter
+}
+
+type discoveryClient interface {
+	discovery.ServerGroupsInterface
+	discovery.ServerResourcesInterface
+}
+
+type storage struct {
+	log       *slog.Logger
+	discovery discoveryClient
+	apps      appsv1.DeploymentsGetter
+	classes   storageClient
+	snap      v2.VolumeSnapshotClassesGetter
+}
+
+func (s *storage) SnapshotAPI(ctx context.Context) (SnapshotAPI, error) {
+	var api SnapshotAPI
+
+	groups, err := s.discovery.ServerGroups()
+	if err != nil {
+		return api, fmt.Errorf("could not discover api groups: %w", apiError(err))
+	}
+
+	for _, g := range groups.Groups {
+		if g.Name != SnapshotGroup {
+			continue
+		}
+
+		for _, v := range g.Versions {
+			api.Versions = append(api.Versions, v.Version)
+		}
+		api.PreferredVersion = g.PreferredVersion.Version
+	}
+
+	if !slices.Contains(api.Versions, SnapshotVersion) {
+		return api, nil
+	}
+
+	resources, err := s.discovery.ServerResourcesForGroupVersion(SnapshotGroup + "/" + SnapshotVersion)
+	if err != nil {
+		return api, fmt.Errorf("could not discover %s resources: %w", SnapshotGroup, apiError(err))
+	}
+
+	for _, r := range resources.APIResources {
+		// subresources like volumesnapshots/status are left out
+		if !strings.Contains(r.Name, "/") {
+			api.Resources = append(api.Resources, r.Name)
+		}
+	}
+
+	return api, nil
+}
+
+func (s *storage) SnapshotControllers(ctx context.Context) ([]SnapshotController, error) {
+	list, err := s.apps.Deployments("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not list deployments: %w", apiError(err))
+	}
+
+	var controllers []SnapshotController
+	for _, d := range list.Items {
+		for _, c := range d.Spec.Template.Spec.Containers {
+			if !strings.Contains(c.Image, snapshotControllerImage) {
+				continue
+			}
+
+			replicas := int32(1)
+			if d.Spec.Replicas != nil {
+				replicas = *d.Spec.Replicas
+			}
+
+			controllers = append(controllers, SnapshotController{
+				Namespace: d.Namespace,
+				Name:      d.Name,
+				Image:     c.Image,
+				Replicas:  replicas,
+				Ready:     d.Status.ReadyReplicas,
+			})
+
+			break
+		}
+	}
+
+	return controllers, nil
+}
+
+func (s *storage) CSIDrivers(ctx context.Context) ([]storagev1.CSIDriver, error) {
+	list, err := s.classes.CSIDrivers().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not list csi drivers: %w", apiError(err))
+	}
+
+	return list.Items, nil
+}
+
+func (s *storage) StorageClasses(ctx context.Context) ([]storagev1.StorageClass, error) {
+	list, err := s.classes.StorageClasses().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not list storage classes: %w", apiError(err))
+	}
+
+	return list.Items, nil
+}
+
+func (s *storage) VolumeSnapshotClasses(ctx context.Context) ([]v3.VolumeSnapshotClass, error) {
+	return listSnapshotClasses(ctx, s.snap)
+}
+
+func listSnapshotClasses(ctx context.Context, snap v2.VolumeSnapshotClassesGetter) ([]v3.VolumeSnapshotClass, error) {
+	list, err := snap.VolumeSnapshotClasses().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not list volume snapshot classes: %w", apiError(err))
+	}
+
+	return list.Items, nil
+}
+
+// SelectSnapshotClass picks the class of the driver marked as default, or the only class of the driver.
+// It fails if the driver has no class, or several classes and none of them is the default
+func SelectSnapshotClass(classes []v3.VolumeSnapshotClass, driver string) (string, error) {
+	var names []string
+	for _, c := range classes {
+		if c.Driver != driver {
+			continue
+		}
+
+		if IsDefaultSnapshotClass(c) {
+			return c.Name, nil
+		}
+
+		names = append(names, c.Name)
+	}
+
+	switch len(names) {
+	case 0:
+		return "", Errorf(ErrNotFound, "no volume snapshot class found for csi driver %s", driver)
+	case 1:
+		return names[0], nil
+	default:
+		return "", Errorf(ErrValidationFailed, "csi driver %s has several volume snapshot classes and none is the default, pick one of %s", driver, strings.Join(names, ", "))
+	}
+}
+
+// IsDefaultSnapshotClass reports whether the class is the default one of its driver
+func IsDefaultSnapshotClass(c v3.VolumeSnapshotClass) bool {
+	return c.Annotations[DefaultSnapshotClassAnnotation] == "true"
+}
+
+// IsDefaultStorageClass reports whether the class is the default one of the cluster
+func IsDefaultStorageClass(sc storagev1.StorageClass) bool {
+	return sc.Annotations[DefaultStorageClassAnnotation] == "true"
+}
@@ -0,0 +1,69 @@
+package core
+
+import (
+	"context"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+	watchtools "k8s.io/client-go/tools/watch"
+)
+
+// listWatcher is implemented by all namespaced typed clients, e.g. PodInterface
+type listWatcher[L runtime.Object] interface {
+	List(ctx context.Context, opts metav1.ListOptions) (L, error)
+	Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error)
+}
+
+// fieldSelectorListWatch returns a ListerWatcher limited to the objects matching the field selector
+func fieldSelectorListWatch[L runtime.Object](cl listWatcher[L], selector fields.Selector) *cache.ListWatch {
+	return &cache.ListWatch{
+		ListWithContextFunc: func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+			options.FieldSelector = selector.String()
+			return cl.List(ctx, options)
+		},
+		WatchFuncWithContext: func(ctx context.Context, options metav1.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = selector.String()
+			return cl.Watch(ctx, options)
+		},
+	}
+}
+
+// labelSelectorListWatch returns a ListerWatcher limited to the objects matching the label selector
+func labelSelectorListWatch[L runtime.Object](cl listWatcher[L], selector string) *cache.ListWatch {
+	return &cache.ListWatch{
+		ListWithContextFunc: func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+			options.LabelSelector = selector
+			return cl.List(ctx, options)
+		},
+		WatchFuncWithContext: func(ctx context.Context, options metav1.ListOptions) (watch.Interface, error) {
+			options.LabelSelector = selector
+			return cl.Watch(ctx, options)
+		},
+	}
+}
+
+// nameListWatch returns a ListerWatcher limited to a single object
+func nameListWatch[L runtime.Object](cl listWatcher[L], name string) *cache.ListWatch {
+	return fieldSelectorListWatch(cl, fields.OneTermEqualSelector("metadata.name", name))
+}
+
+// waitDeleted waits for the object with the given namespace and name to disappear
+func waitDeleted(ctx context.Context, lw cache.ListerWatcher, objType runtime.Object, namespace, name string) error {
+	_, err := watchtools.UntilWithSync(ctx, lw, objType,
+		func(store cache.Store) (bool, error) {
+			_, exists, err := store.GetByKey(namespace + "/" + name)
+			return !exists, err
+		},
+		func(event watch.Event) (bool, error) {
+			return event.Type == watch.Deleted, nil
+		},
+	)
+	if err != nil && ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+
+	return err
+}
@@ -0,0 +1,206 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	appsv1 "k8s.io/client-go/kubernetes/typed/apps/v1"
+	batchv1 "k8s.io/client-go/kubernetes/typed/batch/v1"
+)
+
+const (
+	KindDeployment  = "Deployment"
+	KindStatefulSet = "StatefulSet"
+)
+
+type WorkloadManager interface {
+	// ForPod resolves the Deployment or StatefulSet controlling the pod, along with its current replicas.
+	// Pods managed otherwise, or not managed at all, result in an error
+	ForPod(ctx context.Context, pod *corev1.Pod) (Workload, error)
+	// Scale sets the replicas of the workload
+	Scale(ctx context.Context, w Workload, replicas int32) error
+	// PodTemplates lists the pod templates of all workloads in the namespace, or in all namespaces if it is empty
+	PodTemplates(ctx context.Context, namespace string) ([]PodTemplate, error)
+}
+
+// PodTemplate is the pod spec of a workload, along with the names of the volume claim templates of StatefulSets
+type PodTemplate struct {
+	Kind           string
+	Namespace      string
+	Name           string
+	Spec           corev1.PodSpec
+	ClaimTemplates []string
+}
+
+// UsesPVC reports whether pods created from the template mount the named pvc
+func (t PodTemplate) UsesPVC(pvcName string) bool {
+	if slices.Contains(PVCNames(t.Spec), pvcName) {
+		return true
+	}
+
+	// StatefulSet pvcs are named <claim template>-<statefulset>-<ordinal>
+	for _, claim := range t.ClaimTemplates {
+		if strings.HasPrefix(pvcName, claim+"-"+t.Name+"-") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Workload is a scalable controller of pods
+type Workload struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Replicas  int32  `json:"replicas"`
+}
+
+func (w Workload) String() string {
+	return fmt.Sprintf("%s %s/%s", w.Kind, w.Namespace, w.Name)
+}
+
+type appsClient interface {
+	appsv1.DeploymentsGetter
+	appsv1.StatefulSetsGetter
+	appsv1.ReplicaSetsGetter
+	appsv1.DaemonSetsGetter
+	batchv1.JobsGetter
+	batchv1.CronJobsGetter
+}
+
+type workload struct {
+	log  *slog.Logger
+	apps appsClient
+}
+
+func (wl *workload) ForPod(ctx context.Context, pod *corev1.Pod) (Workload, error) {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return Workload{}, Errorf(ErrValidationFailed, "pod %s/%s is not managed by a Deployment or a StatefulSet", pod.Namespace, pod.Name)
+	}
+
+	w := Workload{Kind: owner.Kind, Namespace: pod.Namespace, Name: owner.Name}
+
+	switch owner.Kind {
+	case "ReplicaSet":
+		rs, err := wl.apps.ReplicaSets(pod.Namespace).Get(ctx, owner.Name, metav1.GetOptions{})
+		if err != nil {
+			return Workload{}, apiError(err)
+		}
+
+		rsOwner := metav1.GetControllerOf(rs)
+		if rsOwner == nil || rsOwner.Kind != KindDeployment {
+			return Workload{}, Errorf(ErrValidationFailed, "pod %s/%s is managed by ReplicaSet %s, which is not managed by a Deployment", pod.Namespace, pod.Name, rs.Name)
+		}
+
+		w.Kind, w.Name = KindDeployment, rsOwner.Name
+	case KindStatefulSet:
+	default:
+		return Workload{}, Errorf(ErrValidationFailed, "pod %s/%s is managed by %s %s, which can not be scaled", pod.Namespace, pod.Name, owner.Kind, owner.Name)
+	}
+
+	scale, err := wl.getScale(ctx, w)
+	if err != nil {
+		return Workload{}, err
+	}
+
+	w.Replicas = scale.Spec.Replicas
+
+	return w, nil
+}
+
+func (wl *workload) Scale(ctx context.Context, w Workload, replicas int32) error {
+	wl.log.Info("scaling workload", "kind", w.Kind, "namespace", w.Namespace, "name", w.Name, "replicas", replicas)
+
+	scale, err := wl.getScale(ctx, w)
+	if err != nil {
+		return err
+	}
+
+	scale.Spec.Replicas = replicas
+
+	switch w.Kind {
+	case KindDeployment:
+		_, err = wl.apps.Deployments(w.Namespace).UpdateScale(ctx, w.Name, scale, metav1.UpdateOptions{})
+	case KindStatefulSet:
+		_, err = wl.apps.StatefulSets(w.Namespace).UpdateScale(ctx, w.Name, scale, metav1.UpdateOptions{})
+	}
+
+	return apiError(err)
+}
+
+func (wl *workload) PodTemplates(ctx context.Context, namespace string) ([]PodTemplate, error) {
+	var templates []PodTemplate
+
+	deployments, err := wl.apps.Deployments(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not list deployments: %w", apiError(err))
+	}
+	for _, d := range deployments.Items {
+		templates = append(templates, PodTemplate{Kind: KindDeployment, Namespace: d.Namespace, Name: d.Name, Spec: d.Spec.Template.Spec})
+	}
+
+	statefulSets, err := wl.apps.StatefulSets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not list statefulsets: %w", apiError(err))
+	}
+	for _, sts := range statefulSets.Items {
+		t := PodTemplate{Kind: KindStatefulSet, Namespace: sts.Namespace, Name: sts.Name, Spec: sts.Spec.Template.Spec}
+		for _, claim := range sts.Spec.VolumeClaimTemplates {
+			t.ClaimTemplates = append(t.ClaimTemplates, claim.Name)
+		}
+
+		templates = append(templates, t)
+	}
+
+	daemonSets, err := wl.apps.DaemonSets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not list daemonsets: %w", apiError(err))
+	}
+	for _, ds := range daemonSets.Items {
+		templates = append(templates, PodTemplate{Kind: "DaemonSet", Namespace: ds.Namespace, Name: ds.Name, Spec: ds.Spec.Template.Spec})
+	}
+
+	jobs, err := wl.apps.Jobs(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not list jobs: %w", apiError(err))
+	}
+	for _, j := range jobs.Items {
+		templates = append(templates, PodTemplate{Kind: "Job", Namespace: j.Namespace, Name: j.Name, Spec: j.Spec.Template.Spec})
+	}
+
+	cronJobs, err := wl.apps.CronJobs(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not list cronjobs: %w", apiError(err))
+	}
+	for _, cj := range cronJobs.Items {
+		templates = append(templates, PodTemplate{Kind: "CronJob", Namespace: cj.Namespace, Name: cj.Name, Spec: cj.Spec.JobTemplate.Spec.Template.Spec})
+	}
+
+	return templates, nil
+}
+
+func (wl *workload) getScale(ctx context.Context, w Workload) (*autoscalingv1.Scale, error) {
+	var (
+		scale *autoscalingv1.Scale
+		err   error
+	)
+
+	switch w.Kind {
+	case KindDeployment:
+		scale, err = wl.apps.Deployments(w.Namespace).GetScale(ctx, w.Name, metav1.GetOptions{})
+	case KindStatefulSet:
+		scale, err = wl.apps.StatefulSets(w.Namespace).GetScale(ctx, w.Name, metav1.GetOptions{})
+	default:
+		return nil, Errorf(ErrValidationFailed, "%s can not be scaled", w)
+	}
+
+	return scale, apiError(err)
+}
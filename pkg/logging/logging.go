@@ -0,0 +1,99 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+type Format string
+
+var (
+	FormatText Format = "text"
+	FormatJSON Format = "json"
+)
+
+// Handler is a slog.Handler which can be reconfigured after the loggers using it were created,
+// as the log format and level are only known once the command line flags are parsed
+type Handler struct {
+	root *root
+	// ops replays WithAttrs and WithGroup calls on top of the currently configured handler
+	ops []func(slog.Handler) slog.Handler
+}
+
+type root struct {
+	mu    sync.RWMutex
+	w     io.Writer
+	level slog.LevelVar
+	h     slog.Handler
+}
+
+// NewHandler returns a text handler writing to w at the info level, until it gets reconfigured
+func NewHandler(w io.Writer) *Handler {
+	r := &root{w: w}
+	r.h = slog.NewTextHandler(w, &slog.HandlerOptions{Level: &r.level})
+
+	return &Handler{root: r}
+}
+
+// Configure switches the format and the level of all loggers created from the handler
+func (h *Handler) Configure(format Format, level string) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(strings.ToUpper(level))); err != nil {
+		return fmt.Errorf("invalid log level %q: %w", level, err)
+	}
+
+	opts := &slog.HandlerOptions{Level: &h.root.level}
+
+	var base slog.Handler
+	switch format {
+	case FormatText:
+		base = slog.NewTextHandler(h.root.w, opts)
+	case FormatJSON:
+		base = slog.NewJSONHandler(h.root.w, opts)
+	default:
+		return fmt.Errorf("invalid log format %q, expected one of: %s, %s", format, FormatText, FormatJSON)
+	}
+
+	h.root.mu.Lock()
+	defer h.root.mu.Unlock()
+
+	h.root.level.Set(lvl)
+	h.root.h = base
+
+	return nil
+}
+
+func (h *Handler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.root.level.Level()
+}
+
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	h.root.mu.RLock()
+	base := h.root.h
+	h.root.mu.RUnlock()
+
+	for _, op := range h.ops {
+		base = op(base)
+	}
+
+	return base.Handle(ctx, r)
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(base slog.Handler) slog.Handler { return base.WithAttrs(attrs) })
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	return h.with(func(base slog.Handler) slog.Handler { return base.WithGroup(name) })
+}
+
+func (h *Handler) with(op func(slog.Handler) slog.Handler) *Handler {
+	ops := make([]func(slog.Handler) slog.Handler, len(h.ops), len(h.ops)+1)
+	copy(ops, h.ops)
+
+	return &Handler{root: h.root, ops: append(ops, op)}
+}